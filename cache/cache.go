@@ -0,0 +1,611 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+// Package cache implements an in-memory HTTP response cache.
+//
+// The cache stores responses to GET and HEAD requests as a shared cache
+// following the Cache-Control, Expires, ETag and Vary response headers.
+//
+//  c := cache.New(64 << 20)
+//  h = c.Handler(h)
+//
+// Handlers set the freshness lifetime of a response with the Cache-Control
+// header:
+//
+//  w := req.Respond(web.StatusOK,
+//      web.HeaderCacheControl, "max-age=60, stale-while-revalidate=600",
+//      cache.HeaderCacheTag, "items item-42")
+//
+// The cache revalidates stale responses that have an ETag by calling the
+// handler with an If-None-Match header. Use web.ETagHandler to add ETags and
+// answer conditional requests.
+//
+// Concurrent requests for a response that is not in the cache are coalesced
+// so that the handler is called once.
+package cache
+
+import (
+	"bufio"
+	"bytes"
+	"container/list"
+	"errors"
+	"github.com/garyburd/twister/web"
+	"io"
+	"log"
+	"net"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HeaderCacheTag is the response header for tagging cached responses. The
+// value is a space or comma separated list of tags. The cache removes the
+// header from responses. Use PurgeTag to remove responses by tag.
+const HeaderCacheTag = "Cache-Tag"
+
+// timeNow is replaced in tests.
+var timeNow = time.Now
+
+// Cache is an in-memory HTTP response cache. The cache evicts the least
+// recently used responses when the total size of the cached responses exceeds
+// the maximum size.
+type Cache struct {
+	// MaxEntrySize is the maximum size of a cached response body. If zero,
+	// then one sixteenth of the cache size is used.
+	MaxEntrySize int
+
+	maxSize int64
+
+	mu        sync.Mutex
+	entries   map[string]*entry
+	primaries map[string]*primary
+	lru       list.List
+	size      int64
+	calls     map[string]*call
+}
+
+// primary holds the Vary header names and entry keys for a method and URL.
+type primary struct {
+	vary []string
+	keys map[string]bool
+}
+
+type entry struct {
+	key, primaryKey, path string
+
+	status int
+	header web.Header
+	body   []byte
+	tags   []string
+	etag   string
+
+	// Time when the response was stored or revalidated, the freshness
+	// lifetime and the stale-while-revalidate period.
+	stored time.Time
+	fresh  time.Duration
+	swr    time.Duration
+
+	size int64
+	elem *list.Element
+}
+
+// call is an in-flight request to the handler.
+type call struct {
+	done chan struct{}
+}
+
+// New returns a new cache holding up to maxSize bytes of responses.
+func New(maxSize int64) *Cache {
+	return &Cache{
+		maxSize:   maxSize,
+		entries:   make(map[string]*entry),
+		primaries: make(map[string]*primary),
+		calls:     make(map[string]*call),
+	}
+}
+
+func (c *Cache) maxEntrySize() int {
+	if c.MaxEntrySize > 0 {
+		return c.MaxEntrySize
+	}
+	return int(c.maxSize / 16)
+}
+
+// Len returns the number of cached responses.
+func (c *Cache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries)
+}
+
+// PurgePrefix removes the cached responses for URL paths starting with
+// prefix and returns the number of responses removed.
+func (c *Cache) PurgePrefix(prefix string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := 0
+	for _, e := range c.entries {
+		if strings.HasPrefix(e.path, prefix) {
+			c.remove(e)
+			n++
+		}
+	}
+	return n
+}
+
+// PurgeTag removes the cached responses tagged with tag and returns the
+// number of responses removed.
+func (c *Cache) PurgeTag(tag string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := 0
+	for _, e := range c.entries {
+		for _, t := range e.tags {
+			if t == tag {
+				c.remove(e)
+				n++
+				break
+			}
+		}
+	}
+	return n
+}
+
+// remove removes an entry. The caller must hold the lock.
+func (c *Cache) remove(e *entry) {
+	delete(c.entries, e.key)
+	c.lru.Remove(e.elem)
+	c.size -= e.size
+	if p := c.primaries[e.primaryKey]; p != nil {
+		delete(p.keys, e.key)
+		if len(p.keys) == 0 {
+			delete(c.primaries, e.primaryKey)
+		}
+	}
+}
+
+// varyKey returns the part of the cache key for the Vary header names.
+func varyKey(header web.Header, vary []string) string {
+	var buf bytes.Buffer
+	for _, name := range vary {
+		buf.WriteByte(0)
+		buf.WriteString(strings.Join(header[name], ","))
+	}
+	return buf.String()
+}
+
+// lookup returns the key and the entry for the request. The entry is nil if
+// the response is not cached. The caller must hold the lock.
+func (c *Cache) lookup(primaryKey string, header web.Header) (string, *entry) {
+	p := c.primaries[primaryKey]
+	if p == nil {
+		return primaryKey, nil
+	}
+	key := primaryKey + varyKey(header, p.vary)
+	return key, c.entries[key]
+}
+
+// store adds an entry to the cache. The caller must hold the lock.
+func (c *Cache) store(e *entry, vary []string) {
+	if old := c.entries[e.key]; old != nil {
+		c.remove(old)
+	}
+	p := c.primaries[e.primaryKey]
+	if p != nil && strings.Join(p.vary, ",") != strings.Join(vary, ",") {
+		// The Vary header changed. Remove the variants stored with the old
+		// header.
+		for key := range p.keys {
+			c.remove(c.entries[key])
+		}
+		p = nil
+	}
+	if p == nil {
+		p = &primary{vary: vary, keys: make(map[string]bool)}
+		c.primaries[e.primaryKey] = p
+	}
+	p.keys[e.key] = true
+	c.entries[e.key] = e
+	e.elem = c.lru.PushFront(e)
+	c.size += e.size
+	for c.size > c.maxSize && c.lru.Len() > 0 {
+		c.remove(c.lru.Back().Value.(*entry))
+	}
+}
+
+// cacheControl parses the Cache-Control header. The map keys are lowercase
+// directive names.
+func cacheControl(header web.Header) map[string]string {
+	m := make(map[string]string)
+	for _, s := range header.GetList(web.HeaderCacheControl) {
+		name, value := s, ""
+		if i := strings.IndexByte(s, '='); i >= 0 {
+			name, value = s[:i], web.UnquoteHeaderValue(strings.TrimSpace(s[i+1:]))
+		}
+		m[strings.ToLower(strings.TrimSpace(name))] = value
+	}
+	return m
+}
+
+func seconds(s string) (time.Duration, bool) {
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	return time.Duration(n) * time.Second, true
+}
+
+// cacheableStatus is the set of status codes that can be cached.
+var cacheableStatus = map[int]bool{
+	web.StatusOK:                          true,
+	web.StatusNonAuthoritativeInformation: true,
+	web.StatusNoContent:                   true,
+	web.StatusMultipleChoices:             true,
+	web.StatusMovedPermanently:            true,
+	web.StatusPermanentRedirect:           true,
+	web.StatusNotFound:                    true,
+	web.StatusMethodNotAllowed:            true,
+	web.StatusGone:                        true,
+	web.StatusRequestURITooLong:           true,
+	web.StatusNotImplemented:              true,
+}
+
+// freshness sets the freshness lifetime and stale-while-revalidate period of
+// the entry from the response header.
+func (e *entry) freshness(cc map[string]string) {
+	e.fresh, e.swr = 0, 0
+	if _, ok := cc["no-cache"]; ok {
+		return
+	}
+	if d, ok := seconds(cc["s-maxage"]); ok {
+		e.fresh = d
+	} else if d, ok := seconds(cc["max-age"]); ok {
+		e.fresh = d
+	} else if s := e.header.Get(web.HeaderExpires); s != "" {
+		if t, err := time.Parse(time.RFC1123, s); err == nil {
+			e.fresh = t.Sub(e.stored)
+		}
+	}
+	if d, ok := seconds(cc["stale-while-revalidate"]); ok {
+		e.swr = d
+	}
+}
+
+// newEntry returns an entry for the buffered response or nil if the response
+// cannot be stored.
+func newEntry(req *web.Request, key, primaryKey string, br *web.BufferedResponder, now time.Time) (*entry, []string) {
+	if !cacheableStatus[br.Status] {
+		return nil, nil
+	}
+	cc := cacheControl(br.Header)
+	if _, ok := cc["no-store"]; ok {
+		return nil, nil
+	}
+	if _, ok := cc["private"]; ok {
+		return nil, nil
+	}
+	if _, ok := br.Header[web.HeaderSetCookie]; ok {
+		return nil, nil
+	}
+	if _, ok := req.Header[web.HeaderAuthorization]; ok {
+		_, public := cc["public"]
+		_, sMaxAge := cc["s-maxage"]
+		if !public && !sMaxAge {
+			return nil, nil
+		}
+	}
+	var vary []string
+	for _, name := range br.Header.GetList(web.HeaderVary) {
+		if name == "*" {
+			return nil, nil
+		}
+		vary = append(vary, web.HeaderName(name))
+	}
+	e := &entry{
+		key:        primaryKey + varyKey(req.Header, vary),
+		primaryKey: primaryKey,
+		path:       req.URL.Path,
+		status:     br.Status,
+		header:     br.Header,
+		body:       append([]byte(nil), br.Body.Bytes()...),
+		etag:       br.Header.Get(web.HeaderETag),
+		stored:     now,
+	}
+	for _, s := range br.Header.GetList(HeaderCacheTag) {
+		e.tags = append(e.tags, strings.Fields(s)...)
+	}
+	delete(e.header, HeaderCacheTag)
+	e.freshness(cc)
+	if e.fresh <= 0 && e.etag == "" {
+		return nil, nil
+	}
+	e.size = int64(len(e.key) + len(e.body))
+	for k, values := range e.header {
+		for _, v := range values {
+			e.size += int64(len(k) + len(v))
+		}
+	}
+	return e, vary
+}
+
+// Handler returns a handler that serves responses from the cache and stores
+// cacheable responses from h.
+//
+// The request Cache-Control directives no-store and no-cache bypass the
+// cache and force revalidation respectively. Responses with the no-store or
+// private directives, responses with the Set-Cookie header and responses to
+// requests with the Authorization header are not stored unless allowed by the
+// public or s-maxage directives. Responses without a freshness lifetime are
+// stored only if they have an ETag for revalidation.
+//
+// A stale response is served during the response's stale-while-revalidate
+// period while the response is revalidated in the background. The background
+// request is a copy of the original request.
+func (c *Cache) Handler(h web.Handler) web.Handler {
+	return &handler{c: c, h: h}
+}
+
+type handler struct {
+	c *Cache
+	h web.Handler
+}
+
+func (h *handler) ServeWeb(req *web.Request) {
+	if req.Method != "GET" && req.Method != "HEAD" {
+		h.h.ServeWeb(req)
+		return
+	}
+	cc := cacheControl(req.Header)
+	if _, ok := cc["no-store"]; ok {
+		h.h.ServeWeb(req)
+		return
+	}
+	_, noCache := cc["no-cache"]
+	c := h.c
+	primaryKey := req.Method + " " + req.URL.String()
+	waited := false
+	for {
+		now := timeNow()
+		c.mu.Lock()
+		key, e := c.lookup(primaryKey, req.Header)
+		if e != nil && !noCache {
+			age := now.Sub(e.stored)
+			if age < e.fresh {
+				c.lru.MoveToFront(e.elem)
+				c.mu.Unlock()
+				serve(req, e, age)
+				return
+			}
+			if age < e.fresh+e.swr {
+				c.lru.MoveToFront(e.elem)
+				if c.calls[key] == nil {
+					cl := &call{done: make(chan struct{})}
+					c.calls[key] = cl
+					go h.revalidate(copyRequest(req), key, primaryKey, e, cl)
+				}
+				c.mu.Unlock()
+				serve(req, e, age)
+				return
+			}
+		}
+		if cl := c.calls[key]; cl != nil && !noCache && !waited {
+			c.mu.Unlock()
+			<-cl.done
+			waited = true
+			continue
+		}
+		if waited {
+			// The response from the coalesced request was not stored. Call
+			// the handler without coalescing to avoid serializing requests
+			// for responses that cannot be cached.
+			c.mu.Unlock()
+			h.h.ServeWeb(req)
+			return
+		}
+		cl := &call{done: make(chan struct{})}
+		c.calls[key] = cl
+		c.mu.Unlock()
+
+		defer c.endCall(key, cl)
+		h.fetch(req, key, primaryKey, e)
+		return
+	}
+}
+
+// endCall removes the call and releases the requests waiting for the call.
+// Calls are ended in a deferred function so that waiting requests are
+// released when the handler panics.
+func (c *Cache) endCall(key string, cl *call) {
+	c.mu.Lock()
+	if c.calls[key] == cl {
+		delete(c.calls, key)
+	}
+	c.mu.Unlock()
+	close(cl.done)
+}
+
+// discardResponder discards the response to a background revalidation.
+type discardResponder struct{}
+
+func (discardResponder) Respond(status int, header web.Header) io.Writer { return discardWriter{} }
+
+func (discardResponder) Hijack() (net.Conn, *bufio.Reader, error) {
+	return nil, nil, errors.New("cache: hijack not supported")
+}
+
+type discardWriter struct{}
+
+func (discardWriter) Write(p []byte) (int, error) { return len(p), nil }
+
+// copyValues returns a copy of m that does not share slices with m.
+func copyValues(m map[string][]string) map[string][]string {
+	if m == nil {
+		return nil
+	}
+	result := make(map[string][]string, len(m))
+	for k, v := range m {
+		result[k] = append([]string(nil), v...)
+	}
+	return result
+}
+
+// copyStrings returns a copy of m.
+func copyStrings(m map[string]string) map[string]string {
+	if m == nil {
+		return nil
+	}
+	result := make(map[string]string, len(m))
+	for k, v := range m {
+		result[k] = v
+	}
+	return result
+}
+
+// copyRequest returns a copy of the request for a background revalidation.
+// The copy does not share maps with the request because handlers, including
+// routers, modify the request while the original request is still in use.
+// The copy must be made before the original request handler returns.
+func copyRequest(req *web.Request) *web.Request {
+	u := *req.URL
+	r := &web.Request{
+		Responder:       discardResponder{},
+		Method:          req.Method,
+		RequestURI:      req.RequestURI,
+		ProtocolVersion: req.ProtocolVersion,
+		URL:             &u,
+		RemoteAddr:      req.RemoteAddr,
+		Header:          web.Header(copyValues(req.Header)),
+		Param:           web.Values(copyValues(req.Param)),
+		Cookie:          web.Values(copyValues(req.Cookie)),
+		URLParam:        copyStrings(req.URLParam),
+		ContentType:     req.ContentType,
+		ContentParam:    copyStrings(req.ContentParam),
+		ErrorHandler:    req.ErrorHandler,
+		ContentLength:   req.ContentLength,
+		Body:            strings.NewReader(""),
+		Env:             make(map[string]interface{}, len(req.Env)),
+	}
+	for k, v := range req.Env {
+		r.Env[k] = v
+	}
+	return r
+}
+
+// revalidate revalidates a stale entry using a copy of the request. A panic
+// in the handler is logged because there is no server goroutine to recover
+// it.
+func (h *handler) revalidate(r *web.Request, key, primaryKey string, e *entry, cl *call) {
+	defer h.c.endCall(key, cl)
+	defer func() {
+		if v := recover(); v != nil {
+			log.Printf("Panic while revalidating \"%s\": %v\n%s", r.URL, v, debug.Stack())
+		}
+	}()
+	h.fetch(r, key, primaryKey, e)
+}
+
+// fetch calls the handler and stores the response. If stale is not nil, then
+// fetch revalidates the stale entry.
+func (h *handler) fetch(req *web.Request, key, primaryKey string, stale *entry) {
+	c := h.c
+	header := req.Header
+	req.Header = make(web.Header)
+	for k, v := range header {
+		switch k {
+		case web.HeaderIfNoneMatch, web.HeaderIfModifiedSince:
+			// Request the full response for the cache. The cache answers
+			// the client's conditional request.
+		default:
+			req.Header[k] = v
+		}
+	}
+	if stale != nil && stale.etag != "" {
+		req.Header.Set(web.HeaderIfNoneMatch, stale.etag)
+	}
+	br := web.NewBufferedResponder(req.Responder, c.maxEntrySize())
+	req.Responder = br
+	func() {
+		// Restore the request when the handler panics so that the panic
+		// can be answered with an error response.
+		defer func() {
+			req.Responder = br.Responder
+			req.Header = header
+		}()
+		h.h.ServeWeb(req)
+	}()
+
+	if !br.Buffered() {
+		return
+	}
+	now := timeNow()
+
+	if br.Status == web.StatusNotModified && stale != nil && stale.etag != "" {
+		// Replace the stale entry with a copy updated with the headers from
+		// the 304 response.
+		e := *stale
+		e.header = make(web.Header)
+		for k, v := range stale.header {
+			e.header[k] = v
+		}
+		for k, v := range br.Header {
+			if k != web.HeaderContentLength && k != HeaderCacheTag {
+				e.header[k] = v
+			}
+		}
+		e.stored = now
+		e.freshness(cacheControl(e.header))
+		c.mu.Lock()
+		if c.entries[e.key] == stale {
+			vary := c.primaries[e.primaryKey].vary
+			c.store(&e, vary)
+		}
+		c.mu.Unlock()
+		serve(req, &e, 0)
+		return
+	}
+
+	e, vary := newEntry(req, key, primaryKey, br, now)
+	if e == nil || e.size > c.maxSize {
+		br.Commit()
+		return
+	}
+	c.mu.Lock()
+	c.store(e, vary)
+	c.mu.Unlock()
+	serve(req, e, 0)
+}
+
+// serve responds to the request with a cached entry. The entry header and
+// body must not be modified after the entry is stored.
+func serve(req *web.Request, e *entry, age time.Duration) {
+	header := make(web.Header)
+	for k, v := range e.header {
+		header[k] = v
+	}
+	header.Set(web.HeaderAge, strconv.Itoa(int(age/time.Second)))
+	if e.etag != "" && web.MatchIfNoneMatch(req.Header, e.etag) {
+		for k := range header {
+			if strings.HasPrefix(k, "Content-") {
+				delete(header, k)
+			}
+		}
+		req.Responder.Respond(web.StatusNotModified, header)
+		return
+	}
+	w := req.Responder.Respond(e.status, header)
+	if req.Method != "HEAD" {
+		w.Write(e.body)
+	}
+}
@@ -0,0 +1,438 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package cache
+
+import (
+	"fmt"
+	"github.com/garyburd/twister/web"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type testClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *testClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *testClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	c.mu.Unlock()
+}
+
+func setClock() *testClock {
+	clock := &testClock{now: time.Unix(1000000, 0)}
+	timeNow = clock.Now
+	return clock
+}
+
+// countingHandler responds with the number of calls and the given headers.
+type countingHandler struct {
+	mu     sync.Mutex
+	n      int
+	header []string
+}
+
+func (h *countingHandler) ServeWeb(req *web.Request) {
+	h.mu.Lock()
+	h.n++
+	n := h.n
+	h.mu.Unlock()
+	io.WriteString(req.Respond(web.StatusOK, h.header...), fmt.Sprintf("%d %s", n, req.Header.Get(web.HeaderAcceptLanguage)))
+}
+
+func (h *countingHandler) count() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.n
+}
+
+// waitForCalls waits for background revalidations to complete.
+func waitForCalls(c *Cache) {
+	for i := 0; i < 100; i++ {
+		c.mu.Lock()
+		n := len(c.calls)
+		c.mu.Unlock()
+		if n == 0 {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func get(h web.Handler, path string, kvs ...string) (int, web.Header, string) {
+	status, header, body := web.RunHandler("http://example.com"+path, "GET", web.NewHeader(kvs...), nil, h)
+	return status, header, string(body)
+}
+
+func TestFreshness(t *testing.T) {
+	clock := setClock()
+	tests := []struct {
+		header []string
+		stored bool
+		fresh  time.Duration
+	}{
+		{[]string{web.HeaderCacheControl, "max-age=60"}, true, 60 * time.Second},
+		{[]string{web.HeaderCacheControl, "max-age=60, s-maxage=120"}, true, 120 * time.Second},
+		{[]string{web.HeaderExpires, clock.Now().Add(30 * time.Second).UTC().Format(time.RFC1123)}, true, 30 * time.Second},
+		{[]string{web.HeaderCacheControl, "no-store, max-age=60"}, false, 0},
+		{[]string{web.HeaderCacheControl, "private, max-age=60"}, false, 0},
+		{[]string{web.HeaderCacheControl, "max-age=60", web.HeaderSetCookie, "a=b"}, false, 0},
+		{[]string{web.HeaderCacheControl, "max-age=60", web.HeaderVary, "*"}, false, 0},
+		{[]string{}, false, 0},
+		{[]string{web.HeaderETag, `"a"`}, true, 0},
+		{[]string{web.HeaderCacheControl, "no-cache, max-age=60", web.HeaderETag, `"a"`}, true, 0},
+	}
+	for _, tt := range tests {
+		c := New(1 << 20)
+		h := &countingHandler{header: tt.header}
+		ch := c.Handler(h)
+		get(ch, "/")
+		if (c.Len() == 1) != tt.stored {
+			t.Errorf("%v: stored=%v, want %v", tt.header, c.Len() == 1, tt.stored)
+			continue
+		}
+		if !tt.stored {
+			continue
+		}
+		for _, e := range c.entries {
+			if e.fresh != tt.fresh {
+				t.Errorf("%v: fresh=%v, want %v", tt.header, e.fresh, tt.fresh)
+			}
+		}
+	}
+}
+
+func TestCacheHit(t *testing.T) {
+	clock := setClock()
+	c := New(1 << 20)
+	h := &countingHandler{header: []string{web.HeaderCacheControl, "max-age=60"}}
+	ch := c.Handler(h)
+
+	tests := []struct {
+		advance time.Duration
+		kvs     []string
+		body    string
+		age     string
+	}{
+		{0, nil, "1 ", "0"},
+		{10 * time.Second, nil, "1 ", "10"},
+		{0, []string{web.HeaderCacheControl, "no-cache"}, "2 ", "0"},
+		{59 * time.Second, nil, "2 ", "59"},
+		{time.Second, nil, "3 ", "0"},
+	}
+	for i, tt := range tests {
+		clock.Advance(tt.advance)
+		_, header, body := get(ch, "/", tt.kvs...)
+		if body != tt.body || header.Get(web.HeaderAge) != tt.age {
+			t.Errorf("%d: body=%q, age=%q, want %q, %q", i, body, header.Get(web.HeaderAge), tt.body, tt.age)
+		}
+	}
+
+	// Authorization.
+	get(ch, "/auth", web.HeaderAuthorization, "Bearer x")
+	if _, _, body := get(ch, "/auth", web.HeaderAuthorization, "Bearer x"); body != "5 " {
+		t.Errorf("response to request with authorization was cached: %q", body)
+	}
+
+	// Other methods are not cached.
+	web.RunHandler("http://example.com/", "POST", nil, nil, ch)
+	if h.count() != 6 {
+		t.Errorf("POST not passed to handler")
+	}
+}
+
+func TestVary(t *testing.T) {
+	setClock()
+	c := New(1 << 20)
+	h := &countingHandler{header: []string{web.HeaderCacheControl, "max-age=60", web.HeaderVary, "accept-language"}}
+	ch := c.Handler(h)
+	for i, tt := range []struct{ lang, body string }{
+		{"en", "1 en"}, {"fr", "2 fr"}, {"en", "1 en"}, {"", "3 "}, {"fr", "2 fr"},
+	} {
+		_, _, body := get(ch, "/", web.HeaderAcceptLanguage, tt.lang)
+		if body != tt.body {
+			t.Errorf("%d: body=%q, want %q", i, body, tt.body)
+		}
+	}
+}
+
+func TestRevalidate(t *testing.T) {
+	clock := setClock()
+	c := New(1 << 20)
+	var ifNoneMatch []string
+	calls := 0
+	h := web.ETagHandler(1000, false, web.HandlerFunc(func(req *web.Request) {
+		calls++
+		ifNoneMatch = append(ifNoneMatch, req.Header.Get(web.HeaderIfNoneMatch))
+		io.WriteString(req.Respond(web.StatusOK, web.HeaderCacheControl, "max-age=10"), "hello")
+	}))
+	ch := c.Handler(h)
+
+	_, header, _ := get(ch, "/")
+	etag := header.Get(web.HeaderETag)
+
+	// Conditional request from client answered by cache.
+	status, _, _ := get(ch, "/", web.HeaderIfNoneMatch, etag)
+	if status != web.StatusNotModified || calls != 1 {
+		t.Errorf("client conditional: status=%d, calls=%d", status, calls)
+	}
+
+	// Stale response is revalidated with the stored ETag.
+	clock.Advance(20 * time.Second)
+	status, header, body := get(ch, "/", web.HeaderIfNoneMatch, `"other"`)
+	if status != web.StatusOK || body != "hello" || calls != 2 || header.Get(web.HeaderETag) != etag {
+		t.Errorf("revalidate: status=%d, body=%q, calls=%d, header=%v", status, body, calls, header)
+	}
+	if ifNoneMatch[0] != "" || ifNoneMatch[1] != etag {
+		t.Errorf("If-None-Match sent to handler = %q", ifNoneMatch)
+	}
+
+	// Revalidation refreshed the entry.
+	clock.Advance(5 * time.Second)
+	get(ch, "/")
+	if calls != 2 {
+		t.Errorf("revalidated entry not fresh, calls=%d", calls)
+	}
+}
+
+func TestStaleWhileRevalidate(t *testing.T) {
+	clock := setClock()
+	c := New(1 << 20)
+	h := &countingHandler{header: []string{web.HeaderCacheControl, "max-age=10, stale-while-revalidate=60"}}
+	ch := c.Handler(h)
+
+	get(ch, "/")
+	clock.Advance(30 * time.Second)
+	if _, header, body := get(ch, "/"); body != "1 " || header.Get(web.HeaderAge) != "30" {
+		t.Errorf("stale: body=%q, age=%q", body, header.Get(web.HeaderAge))
+	}
+	for i := 0; i < 100 && h.count() < 2; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if h.count() != 2 {
+		t.Fatalf("background revalidation did not run")
+	}
+	waitForCalls(c)
+	if _, _, body := get(ch, "/"); body != "2 " {
+		t.Errorf("after revalidation: body=%q", body)
+	}
+
+	// Beyond the stale-while-revalidate period.
+	clock.Advance(100 * time.Second)
+	if _, _, body := get(ch, "/"); body != "3 " {
+		t.Errorf("expired: body=%q", body)
+	}
+}
+
+// TestRevalidateCopiesRequest checks that the background revalidation does
+// not share the request maps with the original request. Run with -race.
+func TestRevalidateCopiesRequest(t *testing.T) {
+	clock := setClock()
+	c := New(1 << 20)
+	var mu sync.Mutex
+	calls := 0
+	router := web.NewRouter().Register("/items/<id>", "GET", func(req *web.Request) {
+		req.Param.Set("seen", req.URLParam["id"])
+		req.Header.Set("X-Seen", req.URLParam["site"])
+		req.Env["seen"] = true
+		mu.Lock()
+		calls++
+		mu.Unlock()
+		io.WriteString(req.Respond(web.StatusOK, web.HeaderCacheControl, "max-age=10, stale-while-revalidate=60"), "item")
+	})
+	ch := c.Handler(router)
+	h := web.HandlerFunc(func(req *web.Request) {
+		req.URLParam = map[string]string{"site": "a"}
+		ch.ServeWeb(req)
+		// Modify the request after the cache returns, as handlers wrapping
+		// the cache do.
+		req.URLParam["after"] = "x"
+		req.Param.Set("after", "x")
+		req.Header.Set("X-After", "x")
+		req.Env["after"] = true
+	})
+
+	get(h, "/items/1")
+	clock.Advance(30 * time.Second)
+	get(h, "/items/1")
+	waitForCalls(c)
+	mu.Lock()
+	defer mu.Unlock()
+	if calls != 2 {
+		t.Fatalf("background revalidation did not run")
+	}
+}
+
+// recoverHandler recovers panics from h as the server does.
+func recoverHandler(h web.Handler) web.Handler {
+	return web.HandlerFunc(func(req *web.Request) {
+		defer func() {
+			if r := recover(); r != nil {
+				req.Respond(web.StatusInternalServerError)
+			}
+		}()
+		h.ServeWeb(req)
+	})
+}
+
+func TestPanic(t *testing.T) {
+	clock := setClock()
+	c := New(1 << 20)
+	var mu sync.Mutex
+	calls := 0
+	ch := recoverHandler(c.Handler(web.HandlerFunc(func(req *web.Request) {
+		mu.Lock()
+		calls++
+		n := calls
+		mu.Unlock()
+		if n == 1 || n == 3 {
+			panic("boom")
+		}
+		io.WriteString(req.Respond(web.StatusOK, web.HeaderCacheControl, "max-age=10, stale-while-revalidate=60"), "hello")
+	})))
+
+	getWithTimeout := func() (int, string) {
+		type result struct {
+			status int
+			body   string
+		}
+		done := make(chan result, 1)
+		go func() {
+			status, _, body := get(ch, "/")
+			done <- result{status, body}
+		}()
+		select {
+		case r := <-done:
+			return r.status, r.body
+		case <-time.After(5 * time.Second):
+			t.Fatal("request blocked after panic")
+			return 0, ""
+		}
+	}
+
+	if status, _ := getWithTimeout(); status != web.StatusInternalServerError {
+		t.Errorf("panic: status=%d", status)
+	}
+	if status, body := getWithTimeout(); status != web.StatusOK || body != "hello" {
+		t.Errorf("after panic: status=%d, body=%q", status, body)
+	}
+
+	// The background revalidation panics.
+	clock.Advance(30 * time.Second)
+	if status, body := getWithTimeout(); status != web.StatusOK || body != "hello" {
+		t.Errorf("stale: status=%d, body=%q", status, body)
+	}
+	waitForCalls(c)
+	mu.Lock()
+	n := calls
+	mu.Unlock()
+	if n != 3 {
+		t.Fatalf("calls=%d, want 3", n)
+	}
+	clock.Advance(100 * time.Second)
+	if status, body := getWithTimeout(); status != web.StatusOK || body != "hello" {
+		t.Errorf("after revalidation panic: status=%d, body=%q", status, body)
+	}
+}
+
+func TestCoalescing(t *testing.T) {
+	setClock()
+	c := New(1 << 20)
+	release := make(chan struct{})
+	var mu sync.Mutex
+	calls := 0
+	ch := c.Handler(web.HandlerFunc(func(req *web.Request) {
+		mu.Lock()
+		calls++
+		mu.Unlock()
+		<-release
+		io.WriteString(req.Respond(web.StatusOK, web.HeaderCacheControl, "max-age=60"), "hello")
+	}))
+	var wg sync.WaitGroup
+	bodies := make([]string, 10)
+	for i := range bodies {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, _, bodies[i] = get(ch, "/")
+		}(i)
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if calls != 1 {
+		t.Errorf("calls=%d, want 1", calls)
+	}
+	for i, body := range bodies {
+		if body != "hello" {
+			t.Errorf("%d: body=%q", i, body)
+		}
+	}
+}
+
+func TestEvictionAndPurge(t *testing.T) {
+	setClock()
+	c := New(1000)
+	c.MaxEntrySize = 500
+	ch := c.Handler(web.HandlerFunc(func(req *web.Request) {
+		tags := "all " + strings.Trim(req.URL.Path, "/")
+		io.WriteString(req.Respond(web.StatusOK, web.HeaderCacheControl, "max-age=60", HeaderCacheTag, tags),
+			strings.Repeat("x", 200))
+	}))
+	for _, path := range []string{"/a/1", "/a/2", "/b/1", "/b/2"} {
+		get(ch, path)
+	}
+	if c.Len() != 4 {
+		t.Fatalf("Len()=%d, want 4", c.Len())
+	}
+	// Use /a/1 so that /a/2 is least recently used.
+	get(ch, "/a/1")
+	get(ch, "/c/1")
+	if c.Len() != 4 || c.size > 1000 {
+		t.Errorf("Len()=%d, size=%d after eviction", c.Len(), c.size)
+	}
+	if _, e := c.lookup("GET http://example.com/a/2", nil); e != nil {
+		t.Errorf("least recently used entry not evicted")
+	}
+	if _, header, _ := get(ch, "/a/1"); header.Get(HeaderCacheTag) != "" {
+		t.Errorf("Cache-Tag header not removed")
+	}
+
+	if n := c.PurgePrefix("/b/"); n != 2 {
+		t.Errorf("PurgePrefix removed %d, want 2", n)
+	}
+	if n := c.PurgeTag("c/1"); n != 1 {
+		t.Errorf("PurgeTag removed %d, want 1", n)
+	}
+	if n := c.PurgeTag("all"); n != 1 || c.Len() != 0 || c.size != 0 {
+		t.Errorf("PurgeTag(all) removed %d, Len()=%d, size=%d", n, c.Len(), c.size)
+	}
+
+	// Entries larger than MaxEntrySize are streamed and not stored.
+	c.MaxEntrySize = 100
+	if _, _, body := get(ch, "/d"); len(body) != 200 || c.Len() != 0 {
+		t.Errorf("large entry: len(body)=%d, Len()=%d", len(body), c.Len())
+	}
+}
@@ -65,8 +65,8 @@ var useAdapter = flag.Bool("adapter", false, "use net/http adapter")
 
 func main() {
 	flag.Parse()
-	r := web.NewRouter().
-		Register("/", "*", web.FormHandler(-1, false, web.HandlerFunc(handler))).
+	r := web.NewRouter()
+	r.Register("/", "*", web.FormHandler(-1, false, web.HandlerFunc(handler))).
 		Register("/static/<path:.*>", "GET", web.DirectoryHandler("static/", nil)).
 		Register("/example/file.txt", "GET", web.FileHandler("static/file.txt", nil)).
 		Register("/urlparam/<a>/<b>", "GET", handler).
@@ -77,6 +77,7 @@ func main() {
 		Register("/multipart", "POST", multipartHandler).
 		Register("/debug/expvar", "GET", expvar.ServeWeb).
 		Register("/debug/pprof/<:.*>", "*", pprof.ServeWeb).
+		Register("/debug/routes", "GET", web.RouteTableHandler(r)).
 		Register("/proxy", "GET", web.ProxyHeaderHandler("X-Real-Ip", "X-Scheme", web.HandlerFunc(handler)))
 
 	h := web.SetErrorHandler(errorHandler, r)
@@ -0,0 +1,158 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package htpasswd
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"strconv"
+)
+
+// bcryptEncoding is the base64 encoding used by bcrypt.
+var bcryptEncoding = base64.NewEncoding("./ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789").WithPadding(base64.NoPadding)
+
+const (
+	bcryptMinCost     = 4
+	bcryptMaxCost     = 31
+	bcryptSaltLen     = 22 // encoded
+	bcryptHashLen     = 31 // encoded
+	bcryptMaxKeyLen   = 72
+	bcryptHashBytes   = 23
+	bcryptPrefixLen   = len("$2y$10$")
+	bcryptEncodedSize = bcryptPrefixLen + bcryptSaltLen + bcryptHashLen
+)
+
+// bcryptMagic is the text encrypted by bcrypt.
+var bcryptMagic = []byte("OrpheanBeholderScryDoubt")
+
+// compareBcrypt returns true if password matches the bcrypt hash. The $2a$,
+// $2b$ and $2y$ versions are supported. The versions differ only in the
+// handling of long passwords by buggy implementations.
+func compareBcrypt(hash, password string) bool {
+	if len(hash) != bcryptEncodedSize || hash[0] != '$' || hash[1] != '2' || hash[3] != '$' || hash[6] != '$' {
+		return false
+	}
+	switch hash[2] {
+	case 'a', 'b', 'y':
+	default:
+		return false
+	}
+	cost, err := strconv.Atoi(hash[4:6])
+	if err != nil || cost < bcryptMinCost || cost > bcryptMaxCost {
+		return false
+	}
+	salt, err := bcryptEncoding.DecodeString(hash[bcryptPrefixLen : bcryptPrefixLen+bcryptSaltLen])
+	if err != nil {
+		return false
+	}
+	sum := bcryptEncoding.EncodeToString(bcrypt(cost, salt, password))
+	return subtle.ConstantTimeCompare([]byte(sum), []byte(hash[bcryptPrefixLen+bcryptSaltLen:])) == 1
+}
+
+// bcrypt returns the raw bcrypt hash of password.
+func bcrypt(cost int, salt []byte, password string) []byte {
+	key := make([]byte, 0, len(password)+1)
+	key = append(key, password...)
+	key = append(key, 0)
+	if len(key) > bcryptMaxKeyLen {
+		key = key[:bcryptMaxKeyLen]
+	}
+
+	c := &blowfish{p: initialP, s: initialS}
+	c.expandKey(key, salt)
+	for i := uint64(0); i < 1<<uint(cost); i++ {
+		c.expandKey(key, nil)
+		c.expandKey(salt, nil)
+	}
+
+	text := make([]uint32, len(bcryptMagic)/4)
+	for i := range text {
+		j := 0
+		text[i] = streamWord(bcryptMagic[4*i:4*i+4], &j)
+	}
+	for i := 0; i < 64; i++ {
+		for j := 0; j < len(text); j += 2 {
+			text[j], text[j+1] = c.encrypt(text[j], text[j+1])
+		}
+	}
+	p := make([]byte, 0, len(text)*4)
+	for _, w := range text {
+		p = append(p, byte(w>>24), byte(w>>16), byte(w>>8), byte(w))
+	}
+	return p[:bcryptHashBytes]
+}
+
+// blowfish is the Blowfish cipher state.
+type blowfish struct {
+	p [18]uint32
+	s [4][256]uint32
+}
+
+func (c *blowfish) f(x uint32) uint32 {
+	return ((c.s[0][x>>24] + c.s[1][x>>16&0xff]) ^ c.s[2][x>>8&0xff]) + c.s[3][x&0xff]
+}
+
+// encrypt encrypts the block l, r.
+func (c *blowfish) encrypt(l, r uint32) (uint32, uint32) {
+	l ^= c.p[0]
+	for i := 1; i < 17; i += 2 {
+		r ^= c.f(l) ^ c.p[i]
+		l ^= c.f(r) ^ c.p[i+1]
+	}
+	r ^= c.p[17]
+	return r, l
+}
+
+// streamWord returns the next big-endian word from p starting at *j and
+// wraps around to the start of p.
+func streamWord(p []byte, j *int) uint32 {
+	var w uint32
+	for i := 0; i < 4; i++ {
+		w = w<<8 | uint32(p[*j])
+		*j++
+		if *j >= len(p) {
+			*j = 0
+		}
+	}
+	return w
+}
+
+// expandKey mixes key and salt into the state using the expensive key
+// schedule from bcrypt. If salt is nil, then the salt is not mixed in.
+func (c *blowfish) expandKey(key, salt []byte) {
+	j := 0
+	for i := range c.p {
+		c.p[i] ^= streamWord(key, &j)
+	}
+	j = 0
+	var l, r uint32
+	next := func() {
+		if salt != nil {
+			l ^= streamWord(salt, &j)
+			r ^= streamWord(salt, &j)
+		}
+		l, r = c.encrypt(l, r)
+	}
+	for i := 0; i < len(c.p); i += 2 {
+		next()
+		c.p[i], c.p[i+1] = l, r
+	}
+	for k := range c.s {
+		for i := 0; i < len(c.s[k]); i += 2 {
+			next()
+			c.s[k][i], c.s[k][i+1] = l, r
+		}
+	}
+}
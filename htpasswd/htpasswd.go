@@ -0,0 +1,108 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+// Package htpasswd verifies passwords stored in Apache htpasswd files.
+//
+// The bcrypt ("$2y$", "$2a$" and "$2b$") and SHA-1 ("{SHA}") password
+// formats are supported. Users with passwords in other formats cannot be
+// verified.
+//
+//  f, err := htpasswd.Load("/etc/myapp/htpasswd")
+//  if err != nil {
+//      log.Fatal(err)
+//  }
+//  h = web.BasicAuthHandler("My App", f, h)
+package htpasswd
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+// File holds the users and password hashes from an htpasswd file. File
+// implements the web.PasswordVerifier interface.
+type File struct {
+	mu     sync.RWMutex
+	hashes map[string]string
+}
+
+// Load reads the htpasswd file with the given name.
+func Load(filename string) (*File, error) {
+	f := &File{}
+	if err := f.ReadFile(filename); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// ReadFile replaces the users with the users from the htpasswd file with the
+// given name. Call ReadFile to reload the file after the file is modified.
+func (f *File) ReadFile(filename string) error {
+	r, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	return f.Read(r)
+}
+
+// Read replaces the users with the users read from r. Blank lines and lines
+// starting with '#' are ignored.
+func (f *File) Read(r io.Reader) error {
+	hashes := make(map[string]string)
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		i := strings.IndexByte(line, ':')
+		if i < 0 {
+			continue
+		}
+		hashes[line[:i]] = line[i+1:]
+	}
+	if err := s.Err(); err != nil {
+		return err
+	}
+	f.mu.Lock()
+	f.hashes = hashes
+	f.mu.Unlock()
+	return nil
+}
+
+// VerifyPassword returns true if the password is correct for the user.
+func (f *File) VerifyPassword(user, password string) bool {
+	f.mu.RLock()
+	hash, found := f.hashes[user]
+	f.mu.RUnlock()
+	if !found {
+		return false
+	}
+	switch {
+	case strings.HasPrefix(hash, "$2"):
+		return compareBcrypt(hash, password)
+	case strings.HasPrefix(hash, "{SHA}"):
+		sum := sha1.Sum([]byte(password))
+		expected := base64.StdEncoding.EncodeToString(sum[:])
+		return subtle.ConstantTimeCompare([]byte(hash[len("{SHA}"):]), []byte(expected)) == 1
+	}
+	return false
+}
@@ -0,0 +1,84 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package htpasswd
+
+import (
+	"github.com/garyburd/twister/web"
+	"strings"
+	"testing"
+)
+
+const testFile = `
+# comment
+bcrypt:$2a$04$pBL/jb8GiiOfl766FGoLn.G4i2OpSORJHU1WZRGKEhgFcdbovux.G
+bcrypty:$2y$04$pBL/jb8GiiOfl766FGoLn.G4i2OpSORJHU1WZRGKEhgFcdbovux.G
+bcryptb:$2b$04$.fzkCp.J8uNvKN1LXbjj7up0U6uYU8fsX5W1n57OXpWwxp30CiJXG
+bcryptbad:$2x$04$.fzkCp.J8uNvKN1LXbjj7up0U6uYU8fsX5W1n57OXpWwxp30CiJXG
+sha:{SHA}W6ph5Mm5Pz8GgiULbPgzG37mj9g=
+md5:$apr1$abc$def
+`
+
+var verifyTests = []struct {
+	user, password string
+	ok             bool
+}{
+	{"bcrypt", "secret", true},
+	{"bcrypt", "wrong", false},
+	{"bcrypty", "secret", true},
+	{"bcryptb", "secret", true},
+	{"bcryptb", "secret ", false},
+	{"bcryptbad", "secret", false},
+	{"sha", "password", true},
+	{"sha", "wrong", false},
+	{"md5", "password", false},
+	{"unknown", "secret", false},
+}
+
+func TestVerifyPassword(t *testing.T) {
+	var f File
+	if err := f.Read(strings.NewReader(testFile)); err != nil {
+		t.Fatal(err)
+	}
+	var v web.PasswordVerifier = &f
+	for _, tt := range verifyTests {
+		if ok := v.VerifyPassword(tt.user, tt.password); ok != tt.ok {
+			t.Errorf("VerifyPassword(%q, %q) = %v, want %v", tt.user, tt.password, ok, tt.ok)
+		}
+	}
+}
+
+var bcryptTests = []struct {
+	hash, password string
+	ok             bool
+}{
+	{"$2a$05$CCCCCCCCCCCCCCCCCCCCC.E5YPO9kmyuRGyh0XouQYb4YMJKvyOeW", "U*U", true},
+	{"$2a$04$qFVRoDLPao1dvi5erOAZtu3zw/acG8sBxYKGypwdPRjYBeZk3LVqC", "", true},
+	{"$2a$04$zfZot/8c1JScSJYVCQhx0.KQZvCKGsr.YOoTYsQ7h4bIrsMS44tae", "p\u00e4ssw\u00f6rd", true},
+	{"$2a$04$OxFq.7qJsobDaY.NZWwe1ODMfJC8zSwPHLeMIlcIctJCXVXNPqAgi", strings.Repeat("x", 72), true},
+	// Passwords are truncated to 72 bytes.
+	{"$2a$04$OxFq.7qJsobDaY.NZWwe1ODMfJC8zSwPHLeMIlcIctJCXVXNPqAgi", strings.Repeat("x", 80), true},
+	{"$2a$04$OxFq.7qJsobDaY.NZWwe1ODMfJC8zSwPHLeMIlcIctJCXVXNPqAgi", strings.Repeat("x", 71), false},
+	{"$2a$03$OxFq.7qJsobDaY.NZWwe1ODMfJC8zSwPHLeMIlcIctJCXVXNPqAgi", strings.Repeat("x", 72), false},
+	{"$2a$04$OxFq.7qJsobDaY.NZWwe1ODMfJC8zSwPHLeMIlcIctJCXVXNPqAg", strings.Repeat("x", 72), false},
+	{"$2a$04$OxFq.7qJsobDaY.NZWwe1ODMfJC8zSwPHLeMIlcIctJCXVXNPqAgi$", strings.Repeat("x", 72), false},
+}
+
+func TestCompareBcrypt(t *testing.T) {
+	for _, tt := range bcryptTests {
+		if ok := compareBcrypt(tt.hash, tt.password); ok != tt.ok {
+			t.Errorf("compareBcrypt(%q, %q) = %v, want %v", tt.hash, tt.password, ok, tt.ok)
+		}
+	}
+}
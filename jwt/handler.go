@@ -0,0 +1,131 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package jwt
+
+import (
+	"errors"
+	"github.com/garyburd/twister/web"
+	"strings"
+)
+
+const claimsEnvKey = "twister.jwt.claims"
+
+// RequestClaims returns the claims stored in the request Env by Handler or
+// nil if the request does not have a verified token.
+func RequestClaims(req *web.Request) Claims {
+	c, _ := req.Env[claimsEnvKey].(Claims)
+	return c
+}
+
+// bearerToken returns the token from the Authorization header. The second
+// result is false if the header is present, but not a well formed bearer
+// token.
+func bearerToken(req *web.Request) (string, bool) {
+	values := req.Header[web.HeaderAuthorization]
+	if len(values) == 0 {
+		return "", true
+	}
+	if len(values) > 1 {
+		return "", false
+	}
+	s := strings.TrimSpace(values[0])
+	scheme := s
+	if i := strings.IndexByte(s, ' '); i >= 0 {
+		scheme = s[:i]
+	}
+	if !strings.EqualFold(scheme, "Bearer") {
+		// Another authentication scheme.
+		return "", true
+	}
+	token := strings.TrimSpace(s[len(scheme):])
+	if token == "" || strings.ContainsAny(token, " \t,") {
+		return "", false
+	}
+	return token, true
+}
+
+// challenge returns the WWW-Authenticate header value for realm and err.
+func challenge(realm string, err *Error, scope string) string {
+	s := "Bearer realm=" + web.QuoteHeaderValue(realm)
+	if scope != "" {
+		s += ", scope=" + web.QuoteHeaderValue(scope)
+	}
+	if err != nil {
+		s += `, error="` + err.Code + `"`
+		if err.Description != "" {
+			s += ", error_description=" + web.QuoteHeaderValue(err.Description)
+		}
+	}
+	return s
+}
+
+// Handler returns a handler that authenticates requests with bearer tokens
+// (RFC 6750) in the Authorization header. If the token is verified by v, then
+// the handler stores the claims in the request Env and calls h. Use
+// RequestClaims to get the claims.
+//
+// If the request does not have a token, then the handler responds with HTTP
+// status 401 and a challenge for realm. If the token is not valid, then the
+// handler responds with HTTP status 401 and the "invalid_token" error code. If
+// the Authorization header is malformed, then the handler responds with HTTP
+// status 400 and the "invalid_request" error code.
+func Handler(v *Verifier, realm string, h web.Handler) web.Handler {
+	return &handler{v: v, realm: realm, h: h}
+}
+
+type handler struct {
+	v     *Verifier
+	realm string
+	h     web.Handler
+}
+
+func (h *handler) ServeWeb(req *web.Request) {
+	token, ok := bearerToken(req)
+	if !ok {
+		err := &Error{Code: ErrorInvalidRequest, Description: "malformed authorization header"}
+		req.Error(web.StatusBadRequest, err, web.HeaderWWWAuthenticate, challenge(h.realm, err, ""))
+		return
+	}
+	if token == "" {
+		req.Error(web.StatusUnauthorized, errTokenMissing, web.HeaderWWWAuthenticate, challenge(h.realm, nil, ""))
+		return
+	}
+	claims, err := h.v.Verify(token)
+	if err != nil {
+		req.Error(web.StatusUnauthorized, err, web.HeaderWWWAuthenticate, challenge(h.realm, err.(*Error), ""))
+		return
+	}
+	req.Env[claimsEnvKey] = claims
+	h.h.ServeWeb(req)
+}
+
+var errTokenMissing = errors.New("jwt: token required")
+
+// RequireScope returns a handler that calls h if the "scope" claim of the
+// request's token includes scope. Otherwise, the handler responds with HTTP
+// status 403 and the "insufficient_scope" error code. RequireScope must be
+// wrapped by Handler.
+func RequireScope(realm, scope string, h web.Handler) web.Handler {
+	return web.HandlerFunc(func(req *web.Request) {
+		for _, s := range RequestClaims(req).Scopes() {
+			if s == scope {
+				h.ServeWeb(req)
+				return
+			}
+		}
+		err := &Error{Code: ErrorInsufficientScope, Description: "scope " + scope + " required"}
+		req.Error(web.StatusForbidden, err, web.HeaderWWWAuthenticate, challenge(realm, err, scope))
+	})
+}
@@ -0,0 +1,63 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package jwt
+
+import (
+	"github.com/garyburd/twister/web"
+	"io"
+	"strings"
+	"testing"
+)
+
+var handlerTestHandler = web.HandlerFunc(func(req *web.Request) {
+	io.WriteString(req.Respond(web.StatusOK), RequestClaims(req).String("sub"))
+})
+
+func TestHandler(t *testing.T) {
+	h := Handler(&Verifier{Keys: testKeySet()}, "api",
+		RequireScope("api", "read", handlerTestHandler))
+	tests := []struct {
+		authorization []string
+		status        int
+		challenge     string
+	}{
+		{nil, web.StatusUnauthorized, `Bearer realm="api"`},
+		{[]string{"Basic Z286cGFzcw=="}, web.StatusUnauthorized, `Bearer realm="api"`},
+		{[]string{"Bearer " + sign(t, "HS256", "h", Claims{"sub": "gopher", "scope": "read write"})}, web.StatusOK, ""},
+		{[]string{"bearer  " + sign(t, "ES256", "e", Claims{"sub": "gopher", "scope": "read"})}, web.StatusOK, ""},
+		{[]string{"Bearer " + sign(t, "HS256", "h", Claims{"sub": "gopher"})}, web.StatusForbidden,
+			`Bearer realm="api", scope="read", error="insufficient_scope", error_description="scope read required"`},
+		{[]string{"Bearer abc"}, web.StatusUnauthorized, `Bearer realm="api", error="invalid_token", error_description="malformed token"`},
+		{[]string{"Bearer "}, web.StatusBadRequest, `Bearer realm="api", error="invalid_request"`},
+		{[]string{"Bearer a b"}, web.StatusBadRequest, `Bearer realm="api", error="invalid_request"`},
+		{[]string{"Bearer a", "Bearer b"}, web.StatusBadRequest, `Bearer realm="api", error="invalid_request"`},
+	}
+	for i, tt := range tests {
+		header := web.NewHeader()
+		if tt.authorization != nil {
+			header[web.HeaderAuthorization] = tt.authorization
+		}
+		status, respHeader, body := web.RunHandler("/", "GET", header, nil, h)
+		if status != tt.status {
+			t.Errorf("%d: status=%d, want %d", i, status, tt.status)
+		}
+		if status == web.StatusOK && string(body) != "gopher" {
+			t.Errorf("%d: sub=%q", i, body)
+		}
+		if challenge := respHeader.Get(web.HeaderWWWAuthenticate); !strings.HasPrefix(challenge, tt.challenge) {
+			t.Errorf("%d: challenge=%q, want %q", i, challenge, tt.challenge)
+		}
+	}
+}
@@ -0,0 +1,177 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package jwt
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"math/big"
+)
+
+// jwk is a JSON Web Key (RFC 7517).
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	Crv string `json:"crv"`
+	K   string `json:"k"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// LoadJWKS reads a JSON Web Key Set (RFC 7517) from the file with the given
+// name.
+func LoadJWKS(filename string) (*KeySet, error) {
+	ks := NewKeySet()
+	if err := ks.ReadFile(filename); err != nil {
+		return nil, err
+	}
+	return ks, nil
+}
+
+// ReadFile replaces the keys in the set with the keys from the JSON Web Key
+// Set file with the given name. Call ReadFile to reload the keys after the
+// file is modified. Tokens can be verified with the set while the file is
+// read.
+func (ks *KeySet) ReadFile(filename string) error {
+	p, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	keys, err := parseJWKS(p)
+	if err != nil {
+		return err
+	}
+	ks.replace(keys)
+	return nil
+}
+
+// ParseJWKS parses a JSON Web Key Set (RFC 7517). Keys with type "oct",
+// "RSA", "EC" (curve P-256) and "OKP" (curve Ed25519) are supported. Keys
+// with other types and keys for use other than "sig" are ignored.
+func ParseJWKS(p []byte) (*KeySet, error) {
+	keys, err := parseJWKS(p)
+	if err != nil {
+		return nil, err
+	}
+	return NewKeySet(keys...), nil
+}
+
+func parseJWKS(p []byte) ([]*Key, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(p, &set); err != nil {
+		return nil, err
+	}
+	var keys []*Key
+	for _, j := range set.Keys {
+		if j.Use != "" && j.Use != "sig" {
+			continue
+		}
+		k, err := j.key()
+		if err != nil {
+			return nil, err
+		}
+		if k != nil {
+			keys = append(keys, k)
+		}
+	}
+	return keys, nil
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	p, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(p) == 0 {
+		return nil, errors.New("jwt: bad key parameter")
+	}
+	return new(big.Int).SetBytes(p), nil
+}
+
+// key returns the key or nil if the key type is not supported.
+func (j *jwk) key() (*Key, error) {
+	k := &Key{ID: j.Kid, Algorithm: j.Alg}
+	switch j.Kty {
+	case "oct":
+		secret, err := base64.RawURLEncoding.DecodeString(j.K)
+		if err != nil || len(secret) == 0 {
+			return nil, errors.New("jwt: bad oct key " + j.Kid)
+		}
+		k.Key = secret
+		if k.Algorithm == "" {
+			k.Algorithm = "HS256"
+		}
+	case "RSA":
+		n, err := decodeBigInt(j.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(j.E)
+		if err != nil {
+			return nil, err
+		}
+		if !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return nil, errors.New("jwt: bad RSA exponent in key " + j.Kid)
+		}
+		k.Key = &rsa.PublicKey{N: n, E: int(e.Int64())}
+		if k.Algorithm == "" {
+			k.Algorithm = "RS256"
+		}
+	case "EC":
+		if j.Crv != "P-256" {
+			return nil, nil
+		}
+		x, err := decodeBigInt(j.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(j.Y)
+		if err != nil {
+			return nil, err
+		}
+		curve := elliptic.P256()
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.New("jwt: EC point not on curve in key " + j.Kid)
+		}
+		k.Key = &ecdsa.PublicKey{Curve: curve, X: x, Y: y}
+		if k.Algorithm == "" {
+			k.Algorithm = "ES256"
+		}
+	case "OKP":
+		if j.Crv != "Ed25519" {
+			return nil, nil
+		}
+		x, err := base64.RawURLEncoding.DecodeString(j.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("jwt: bad Ed25519 key " + j.Kid)
+		}
+		k.Key = ed25519.PublicKey(x)
+		if k.Algorithm == "" {
+			k.Algorithm = "EdDSA"
+		}
+	default:
+		return nil, nil
+	}
+	return k, nil
+}
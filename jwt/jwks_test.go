@@ -0,0 +1,123 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package jwt
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func b64(p []byte) string {
+	return base64.RawURLEncoding.EncodeToString(p)
+}
+
+func testJWKS() []byte {
+	p, _ := json.Marshal(map[string]interface{}{
+		"keys": []map[string]string{
+			{"kty": "oct", "kid": "h", "k": b64(testHMACSecret)},
+			{"kty": "RSA", "kid": "r", "alg": "RS256", "use": "sig",
+				"n": b64(testRSAKey.N.Bytes()), "e": b64(big.NewInt(int64(testRSAKey.E)).Bytes())},
+			{"kty": "EC", "kid": "e", "crv": "P-256",
+				"x": b64(testECKey.X.Bytes()), "y": b64(testECKey.Y.Bytes())},
+			{"kty": "OKP", "kid": "d", "crv": "Ed25519", "x": b64(testEdKey[32:])},
+			{"kty": "RSA", "kid": "enc", "use": "enc",
+				"n": b64(testRSAKey.N.Bytes()), "e": b64(big.NewInt(int64(testRSAKey.E)).Bytes())},
+			{"kty": "EC", "kid": "p384", "crv": "P-384", "x": "AA", "y": "AA"},
+		},
+	})
+	return p
+}
+
+func TestLoadJWKS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jwks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "jwks.json")
+	if err := ioutil.WriteFile(filename, testJWKS(), 0600); err != nil {
+		t.Fatal(err)
+	}
+	ks, err := LoadJWKS(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ks.keys) != 4 {
+		t.Fatalf("loaded %d keys, want 4", len(ks.keys))
+	}
+	v := &Verifier{Keys: ks}
+	for _, k := range []struct{ alg, kid string }{{"HS256", "h"}, {"RS256", "r"}, {"ES256", "e"}, {"EdDSA", "d"}} {
+		if _, err := v.Verify(sign(t, k.alg, k.kid, Claims{"sub": "gopher"})); err != nil {
+			t.Errorf("%s: %v", k.alg, err)
+		}
+	}
+}
+
+func TestReadFileConcurrent(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jwks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "jwks.json")
+	if err := ioutil.WriteFile(filename, testJWKS(), 0600); err != nil {
+		t.Fatal(err)
+	}
+	ks := NewKeySet()
+	v := &Verifier{Keys: ks}
+	token := sign(t, "HS256", "h", Claims{"sub": "gopher"})
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 20; i++ {
+			if err := ks.ReadFile(filename); err != nil {
+				t.Error(err)
+			}
+			ks.Add(&Key{ID: "x", Algorithm: "HS256", Key: []byte("other")})
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 20; i++ {
+			v.Verify(token)
+		}
+	}()
+	wg.Wait()
+	if _, err := v.Verify(token); err != nil {
+		t.Errorf("after reload: %v", err)
+	}
+}
+
+func TestParseJWKSErrors(t *testing.T) {
+	tests := []string{
+		`{"keys": [{"kty": "oct", "k": ""}]}`,
+		`{"keys": [{"kty": "RSA", "n": "!!", "e": "AQAB"}]}`,
+		`{"keys": [{"kty": "EC", "crv": "P-256", "x": "AQ", "y": "AQ"}]}`,
+		`{"keys": [{"kty": "OKP", "crv": "Ed25519", "x": "AQ"}]}`,
+		`{"keys": `,
+	}
+	for _, tt := range tests {
+		if _, err := ParseJWKS([]byte(tt)); err == nil {
+			t.Errorf("ParseJWKS(%s) did not return error", tt)
+		}
+	}
+}
@@ -0,0 +1,312 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+// Package jwt verifies JSON Web Tokens (RFC 7519) sent as OAuth 2.0 bearer
+// tokens (RFC 6750).
+//
+// Tokens signed with the HS256, RS256, ES256 and EdDSA algorithms are
+// supported. Use Handler to authenticate requests:
+//
+//  keys, err := jwt.LoadJWKS("/etc/myapp/jwks.json")
+//  if err != nil {
+//      log.Fatal(err)
+//  }
+//  v := &jwt.Verifier{Keys: keys, Issuer: "https://auth.example.com", Audience: "api"}
+//  h = jwt.Handler(v, "api", h)
+//
+//  func serveItems(req *web.Request) {
+//      claims := jwt.RequestClaims(req)
+//      user := claims.String("sub")
+//      ...
+//  }
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Error is a token verification error. The Code field is an RFC 6750 error
+// code.
+type Error struct {
+	Code        string
+	Description string
+}
+
+// RFC 6750 error codes.
+const (
+	ErrorInvalidRequest    = "invalid_request"
+	ErrorInvalidToken      = "invalid_token"
+	ErrorInsufficientScope = "insufficient_scope"
+)
+
+func (e *Error) Error() string {
+	return "jwt: " + e.Code + ": " + e.Description
+}
+
+func invalidToken(description string) error {
+	return &Error{Code: ErrorInvalidToken, Description: description}
+}
+
+// Claims holds the claims from a token.
+type Claims map[string]interface{}
+
+// String returns the named claim as a string or "" if the claim is not a
+// string.
+func (c Claims) String(name string) string {
+	s, _ := c[name].(string)
+	return s
+}
+
+// Time returns the named NumericDate claim. The second result is false if the
+// claim is missing or not a number.
+func (c Claims) Time(name string) (time.Time, bool) {
+	f, ok := c[name].(float64)
+	if !ok {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(f), 0), true
+}
+
+// Audience returns the "aud" claim as a slice of strings.
+func (c Claims) Audience() []string {
+	switch aud := c["aud"].(type) {
+	case string:
+		return []string{aud}
+	case []interface{}:
+		var result []string
+		for _, a := range aud {
+			if s, ok := a.(string); ok {
+				result = append(result, s)
+			}
+		}
+		return result
+	}
+	return nil
+}
+
+// Scopes returns the space separated scopes in the "scope" claim.
+func (c Claims) Scopes() []string {
+	return strings.Fields(c.String("scope"))
+}
+
+// Verifier verifies tokens.
+type Verifier struct {
+	// Keys used to verify token signatures.
+	Keys *KeySet
+
+	// Issuer is the required value of the "iss" claim. If "", then the issuer
+	// is not checked.
+	Issuer string
+
+	// Audience is a value required in the "aud" claim. If "", then the
+	// audience is not checked.
+	Audience string
+
+	// ClockSkew is the tolerance for clock differences when checking the
+	// "exp" and "nbf" claims.
+	ClockSkew time.Duration
+
+	// now is replaced in tests.
+	now func() time.Time
+}
+
+type header struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+
+	// Crit lists the extension header parameters that must be understood.
+	// No extensions are supported.
+	Crit []string `json:"crit"`
+}
+
+// Verify verifies the token signature and claims and returns the claims. The
+// error is an *Error.
+func (v *Verifier) Verify(token string) (Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, invalidToken("malformed token")
+	}
+	var h header
+	if err := decodeSegment(parts[0], &h); err != nil {
+		return nil, invalidToken("malformed header")
+	}
+	if h.Crit != nil {
+		return nil, invalidToken("unsupported critical header parameter")
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, invalidToken("malformed signature")
+	}
+	if v.Keys == nil {
+		return nil, invalidToken("no keys")
+	}
+	signed := []byte(token[:len(parts[0])+1+len(parts[1])])
+	verified := false
+	for _, k := range v.Keys.find(h.Kid, h.Alg) {
+		if k.verify(signed, sig) {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return nil, invalidToken("signature not valid")
+	}
+
+	var claims Claims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, invalidToken("malformed claims")
+	}
+
+	now := time.Now()
+	if v.now != nil {
+		now = v.now()
+	}
+	if _, found := claims["exp"]; found {
+		exp, ok := claims.Time("exp")
+		if !ok || now.After(exp.Add(v.ClockSkew)) {
+			return nil, invalidToken("token expired")
+		}
+	}
+	if _, found := claims["nbf"]; found {
+		nbf, ok := claims.Time("nbf")
+		if !ok || now.Add(v.ClockSkew).Before(nbf) {
+			return nil, invalidToken("token not valid yet")
+		}
+	}
+	if v.Issuer != "" && claims.String("iss") != v.Issuer {
+		return nil, invalidToken("issuer not accepted")
+	}
+	if v.Audience != "" {
+		found := false
+		for _, aud := range claims.Audience() {
+			if aud == v.Audience {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, invalidToken("audience not accepted")
+		}
+	}
+	return claims, nil
+}
+
+func decodeSegment(s string, v interface{}) error {
+	p, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(p, v)
+}
+
+// Key is a key for verifying tokens.
+type Key struct {
+	// ID matches the "kid" header parameter. If ID is "", then the key is
+	// tried for all tokens with the key's algorithm.
+	ID string
+
+	// Algorithm is "HS256", "RS256", "ES256" or "EdDSA".
+	Algorithm string
+
+	// Key is a []byte for HS256, *rsa.PublicKey for RS256,
+	// *ecdsa.PublicKey for ES256 and ed25519.PublicKey for EdDSA.
+	Key interface{}
+}
+
+func (k *Key) verify(signed, sig []byte) bool {
+	switch k.Algorithm {
+	case "HS256":
+		secret, ok := k.Key.([]byte)
+		if !ok {
+			return false
+		}
+		m := hmac.New(sha256.New, secret)
+		m.Write(signed)
+		return hmac.Equal(m.Sum(nil), sig)
+	case "RS256":
+		pub, ok := k.Key.(*rsa.PublicKey)
+		if !ok {
+			return false
+		}
+		sum := sha256.Sum256(signed)
+		return rsa.VerifyPKCS1v15(pub, crypto.SHA256, sum[:], sig) == nil
+	case "ES256":
+		pub, ok := k.Key.(*ecdsa.PublicKey)
+		if !ok || len(sig) != 64 {
+			return false
+		}
+		sum := sha256.Sum256(signed)
+		r := new(big.Int).SetBytes(sig[:32])
+		s := new(big.Int).SetBytes(sig[32:])
+		return ecdsa.Verify(pub, sum[:], r, s)
+	case "EdDSA":
+		pub, ok := k.Key.(ed25519.PublicKey)
+		if !ok || len(pub) != ed25519.PublicKeySize {
+			return false
+		}
+		return ed25519.Verify(pub, signed, sig)
+	}
+	return false
+}
+
+// KeySet is a set of keys for verifying tokens. A KeySet can be modified
+// while tokens are verified with the set.
+type KeySet struct {
+	mu   sync.RWMutex
+	keys []*Key
+}
+
+// NewKeySet returns a key set containing the given keys.
+func NewKeySet(keys ...*Key) *KeySet {
+	return &KeySet{keys: keys}
+}
+
+// Add adds a key to the set.
+func (ks *KeySet) Add(k *Key) {
+	ks.mu.Lock()
+	ks.keys = append(ks.keys, k)
+	ks.mu.Unlock()
+}
+
+// replace replaces the keys in the set.
+func (ks *KeySet) replace(keys []*Key) {
+	ks.mu.Lock()
+	ks.keys = keys
+	ks.mu.Unlock()
+}
+
+// find returns the keys for the key ID and algorithm. The algorithm must
+// match the key algorithm to prevent algorithm substitution attacks.
+func (ks *KeySet) find(kid, alg string) []*Key {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	var result []*Key
+	for _, k := range ks.keys {
+		if k.Algorithm == alg && (kid == "" || k.ID == "" || k.ID == kid) {
+			result = append(result, k)
+		}
+	}
+	return result
+}
@@ -0,0 +1,189 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+var (
+	testHMACSecret = []byte("0123456789abcdef0123456789abcdef")
+	testRSAKey     *rsa.PrivateKey
+	testECKey      *ecdsa.PrivateKey
+	testEdKey      ed25519.PrivateKey
+)
+
+func init() {
+	var err error
+	if testRSAKey, err = rsa.GenerateKey(rand.Reader, 2048); err != nil {
+		panic(err)
+	}
+	if testECKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader); err != nil {
+		panic(err)
+	}
+	if _, testEdKey, err = ed25519.GenerateKey(rand.Reader); err != nil {
+		panic(err)
+	}
+}
+
+func testKeySet() *KeySet {
+	return NewKeySet(
+		&Key{ID: "h", Algorithm: "HS256", Key: testHMACSecret},
+		&Key{ID: "r", Algorithm: "RS256", Key: &testRSAKey.PublicKey},
+		&Key{ID: "e", Algorithm: "ES256", Key: &testECKey.PublicKey},
+		&Key{ID: "d", Algorithm: "EdDSA", Key: testEdKey.Public()})
+}
+
+// sign returns a token with the given header and claims signed with the
+// test key for alg.
+func sign(t *testing.T, alg, kid string, claims Claims) string {
+	return signHeader(t, map[string]interface{}{"alg": alg, "kid": kid, "typ": "JWT"}, claims)
+}
+
+// signHeader returns a token with the given header and claims signed with
+// the test key for the header's alg.
+func signHeader(t *testing.T, header map[string]interface{}, claims Claims) string {
+	alg, _ := header["alg"].(string)
+	h, _ := json.Marshal(header)
+	c, _ := json.Marshal(claims)
+	s := base64.RawURLEncoding.EncodeToString(h) + "." + base64.RawURLEncoding.EncodeToString(c)
+	var sig []byte
+	switch alg {
+	case "HS256":
+		m := hmac.New(sha256.New, testHMACSecret)
+		m.Write([]byte(s))
+		sig = m.Sum(nil)
+	case "RS256":
+		sum := sha256.Sum256([]byte(s))
+		var err error
+		if sig, err = rsa.SignPKCS1v15(rand.Reader, testRSAKey, crypto.SHA256, sum[:]); err != nil {
+			t.Fatal(err)
+		}
+	case "ES256":
+		sum := sha256.Sum256([]byte(s))
+		r, ss, err := ecdsa.Sign(rand.Reader, testECKey, sum[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		sig = make([]byte, 64)
+		r.FillBytes(sig[:32])
+		ss.FillBytes(sig[32:])
+	case "EdDSA":
+		sig = ed25519.Sign(testEdKey, []byte(s))
+	}
+	return s + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+var testNow = time.Unix(1000000, 0)
+
+func TestVerify(t *testing.T) {
+	v := &Verifier{
+		Keys:      testKeySet(),
+		Issuer:    "https://issuer.example.com",
+		Audience:  "api",
+		ClockSkew: time.Minute,
+		now:       func() time.Time { return testNow },
+	}
+	valid := Claims{"iss": "https://issuer.example.com", "aud": "api", "sub": "gopher", "exp": 1000100}
+	with := func(name string, value interface{}) Claims {
+		c := Claims{}
+		for k, v := range valid {
+			c[k] = v
+		}
+		if value == nil {
+			delete(c, name)
+		} else {
+			c[name] = value
+		}
+		return c
+	}
+	tests := []struct {
+		token string
+		ok    bool
+	}{
+		{sign(t, "HS256", "h", valid), true},
+		{sign(t, "RS256", "r", valid), true},
+		{sign(t, "ES256", "e", valid), true},
+		{sign(t, "EdDSA", "d", valid), true},
+		{sign(t, "HS256", "", valid), true},
+		{sign(t, "HS256", "r", valid), false},
+		{sign(t, "none", "", valid), false},
+		{sign(t, "HS256", "h", valid)[:20], false},
+		{sign(t, "HS256", "h", valid) + "x", false},
+		{sign(t, "HS256", "h", with("exp", 1000000-59)), true},
+		{sign(t, "HS256", "h", with("exp", 1000000-61)), false},
+		{sign(t, "HS256", "h", with("exp", "tomorrow")), false},
+		{sign(t, "HS256", "h", with("exp", nil)), true},
+		{sign(t, "HS256", "h", with("nbf", 1000000+59)), true},
+		{sign(t, "HS256", "h", with("nbf", 1000000+61)), false},
+		{sign(t, "HS256", "h", with("iss", "https://other.example.com")), false},
+		{sign(t, "HS256", "h", with("iss", nil)), false},
+		{sign(t, "HS256", "h", with("aud", []string{"other", "api"})), true},
+		{sign(t, "HS256", "h", with("aud", "other")), false},
+		{sign(t, "HS256", "h", with("aud", nil)), false},
+		{signHeader(t, map[string]interface{}{"alg": "HS256", "kid": "h", "crit": []string{"exp"}, "exp": 1}, valid), false},
+		{signHeader(t, map[string]interface{}{"alg": "HS256", "kid": "h", "crit": []string{}}, valid), false},
+		{signHeader(t, map[string]interface{}{"alg": "HS256", "kid": "h", "x-ext": 1}, valid), true},
+	}
+	for i, tt := range tests {
+		claims, err := v.Verify(tt.token)
+		if tt.ok {
+			if err != nil {
+				t.Errorf("%d: Verify returned error %v", i, err)
+			} else if claims.String("sub") != "gopher" {
+				t.Errorf("%d: sub=%q", i, claims.String("sub"))
+			}
+		} else {
+			if err == nil {
+				t.Errorf("%d: Verify did not return error", i)
+			} else if e, ok := err.(*Error); !ok || e.Code != ErrorInvalidToken {
+				t.Errorf("%d: err=%v, want invalid_token", i, err)
+			}
+		}
+	}
+}
+
+func TestVerifyNoKeys(t *testing.T) {
+	var v Verifier
+	_, err := v.Verify(sign(t, "HS256", "h", Claims{"sub": "gopher"}))
+	if e, ok := err.(*Error); !ok || e.Code != ErrorInvalidToken {
+		t.Errorf("err=%v, want invalid_token error", err)
+	}
+}
+
+func TestAlgorithmSubstitution(t *testing.T) {
+	// A token signed with HS256 using the RSA public key as the secret must
+	// not verify against the RSA key.
+	pub, _ := json.Marshal(testRSAKey.PublicKey)
+	saved := testHMACSecret
+	testHMACSecret = pub
+	token := sign(t, "HS256", "r", Claims{"sub": "gopher"})
+	testHMACSecret = saved
+	v := &Verifier{Keys: NewKeySet(&Key{ID: "r", Algorithm: "RS256", Key: &testRSAKey.PublicKey})}
+	if _, err := v.Verify(token); err == nil {
+		t.Error("token verified with substituted algorithm")
+	}
+}
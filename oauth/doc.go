@@ -1,2 +0,0 @@
-// Package oauth moved to http://github.com/garyburd/go-oauth
-package oauth
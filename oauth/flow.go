@@ -0,0 +1,165 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package oauth
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"github.com/garyburd/twister/web"
+	"strings"
+	"time"
+)
+
+// Flow implements the authorization code login flow with a pair of handlers.
+// The login handler redirects the browser to the authorization server. The
+// callback handler exchanges the authorization code for a token and calls the
+// Success function.
+//
+// The state and PKCE verifier are stored between the login and the callback
+// in a cookie signed with web.SignValue.
+type Flow struct {
+	// Config for the authorization server.
+	Config *Config
+
+	// Secret used to sign the state cookie. The secret is required.
+	Secret string
+
+	// Name of the state cookie. If "", then "oauth" is used.
+	CookieName string
+
+	// Secure attribute of the state cookie.
+	Secure bool
+
+	// MaxAge is the time allowed to complete login. If zero, then ten
+	// minutes is used.
+	MaxAge time.Duration
+
+	// Success is called by the callback handler with the token and the
+	// return_to parameter passed to the login handler. The return_to
+	// parameter is "/" if not specified. Success must respond to the request.
+	Success func(req *web.Request, token *Token, returnTo string)
+}
+
+var (
+	errStateMissing = errors.New("oauth: state cookie missing or expired")
+	errStateInvalid = errors.New("oauth: state does not match")
+	errCodeMissing  = errors.New("oauth: code missing")
+)
+
+func (f *Flow) cookieName() string {
+	if f.CookieName == "" {
+		return "oauth"
+	}
+	return f.CookieName
+}
+
+func (f *Flow) maxAge() time.Duration {
+	if f.MaxAge == 0 {
+		return 10 * time.Minute
+	}
+	return f.MaxAge
+}
+
+// safeReturnTo returns s if s is a local path and "/" otherwise. The check
+// prevents the login handler from being used as an open redirector.
+func safeReturnTo(s string) string {
+	if !strings.HasPrefix(s, "/") || strings.HasPrefix(s, "//") || strings.HasPrefix(s, "/\\") {
+		return "/"
+	}
+	return s
+}
+
+// LoginHandler returns a handler that redirects to the authorization server.
+// The optional return_to request parameter specifies the local path passed to
+// the Success function after login.
+func (f *Flow) LoginHandler() web.Handler {
+	if f.Secret == "" {
+		panic("twister: oauth.Flow requires a secret")
+	}
+	return web.HandlerFunc(func(req *web.Request) {
+		state := NewVerifier()
+		verifier := NewVerifier()
+		returnTo := safeReturnTo(req.Param.Get("return_to"))
+		value := state + "." + verifier + "." + base64.RawURLEncoding.EncodeToString([]byte(returnTo))
+		c := web.NewCookie(f.cookieName(), web.SignValue(f.Secret, f.cookieName(), f.maxAge(), value)).
+			MaxAge(f.maxAge()).
+			Secure(f.Secure).
+			SameSite(web.SameSiteLax)
+		if err := web.SetCookie(req, c); err != nil {
+			req.Error(web.StatusInternalServerError, err)
+			return
+		}
+		req.Redirect(f.Config.AuthCodeURL(state, verifier), false)
+	})
+}
+
+// CallbackHandler returns a handler for the redirect from the authorization
+// server. The handler checks the state, exchanges the code for a token and
+// calls the Success function.
+//
+// If the state does not match, then the handler responds with HTTP status
+// 400. If the authorization server returned an error, then the handler
+// responds with HTTP status 403 and an *Error. If the token exchange fails,
+// then the handler responds with HTTP status 502.
+func (f *Flow) CallbackHandler() web.Handler {
+	if f.Secret == "" {
+		panic("twister: oauth.Flow requires a secret")
+	}
+	return web.HandlerFunc(func(req *web.Request) {
+		name := f.cookieName()
+		web.SetCookie(req, web.NewCookie(name, "").Delete())
+
+		value, err := web.VerifyValue(f.Secret, name, req.Cookie.Get(name))
+		if err != nil {
+			req.Error(web.StatusBadRequest, errStateMissing)
+			return
+		}
+		parts := strings.Split(value, ".")
+		if len(parts) != 3 {
+			req.Error(web.StatusBadRequest, errStateMissing)
+			return
+		}
+		state, verifier := parts[0], parts[1]
+		returnTo, err := base64.RawURLEncoding.DecodeString(parts[2])
+		if err != nil {
+			req.Error(web.StatusBadRequest, errStateMissing)
+			return
+		}
+		if subtle.ConstantTimeCompare([]byte(state), []byte(req.Param.Get("state"))) != 1 {
+			req.Error(web.StatusBadRequest, errStateInvalid)
+			return
+		}
+		if code := req.Param.Get("error"); code != "" {
+			req.Error(web.StatusForbidden, &Error{
+				Code:        code,
+				Description: req.Param.Get("error_description"),
+				URI:         req.Param.Get("error_uri"),
+			})
+			return
+		}
+		code := req.Param.Get("code")
+		if code == "" {
+			req.Error(web.StatusBadRequest, errCodeMissing)
+			return
+		}
+		token, err := f.Config.Exchange(code, verifier)
+		if err != nil {
+			req.Error(web.StatusBadGateway, err)
+			return
+		}
+		f.Success(req, token, safeReturnTo(string(returnTo)))
+	})
+}
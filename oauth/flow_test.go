@@ -0,0 +1,111 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package oauth
+
+import (
+	"github.com/garyburd/twister/web"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// cookieFromHeader returns the "name=value" part of the Set-Cookie header.
+func cookieFromHeader(header web.Header) string {
+	s := header.Get(web.HeaderSetCookie)
+	if i := strings.IndexByte(s, ';'); i >= 0 {
+		s = s[:i]
+	}
+	return s
+}
+
+func TestFlow(t *testing.T) {
+	s := newFakeServer()
+	defer s.Close()
+	flow := &Flow{
+		Config: s.config(),
+		Secret: "secret",
+		Success: func(req *web.Request, token *Token, returnTo string) {
+			io.WriteString(req.Respond(web.StatusOK), token.AccessToken+" "+returnTo)
+		},
+	}
+	router := web.NewRouter().
+		Register("/login", "GET", flow.LoginHandler()).
+		Register("/callback", "GET", flow.CallbackHandler())
+
+	login := func(returnTo string) (cookie, callback string) {
+		status, header, _ := web.RunHandler("http://example.com/login?return_to="+url.QueryEscape(returnTo), "GET", nil, nil, router)
+		if status != web.StatusFound {
+			t.Fatalf("login status=%d", status)
+		}
+		// Follow the redirect to the authorization server.
+		client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+		resp, err := client.Get(header.Get(web.HeaderLocation))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusFound {
+			t.Fatalf("authorize status=%d", resp.StatusCode)
+		}
+		return cookieFromHeader(header), resp.Header.Get("Location")
+	}
+
+	cookie, callback := login("/items?a=b")
+	status, _, body := web.RunHandler(callback, "GET", web.NewHeader(web.HeaderCookie, cookie), nil, router)
+	if status != web.StatusOK || !strings.HasPrefix(string(body), "access-code") || !strings.HasSuffix(string(body), " /items?a=b") {
+		t.Errorf("callback status=%d, body=%q", status, body)
+	}
+
+	// Open redirect.
+	cookie, callback = login("//evil.example.com/")
+	status, _, body = web.RunHandler(callback, "GET", web.NewHeader(web.HeaderCookie, cookie), nil, router)
+	if status != web.StatusOK || !strings.HasSuffix(string(body), " /") {
+		t.Errorf("open redirect status=%d, body=%q", status, body)
+	}
+
+	// Missing cookie.
+	_, callback = login("/")
+	status, _, _ = web.RunHandler(callback, "GET", nil, nil, router)
+	if status != web.StatusBadRequest {
+		t.Errorf("missing cookie status=%d", status)
+	}
+
+	// Cookie from another login.
+	cookie, _ = login("/")
+	_, callback = login("/")
+	status, _, _ = web.RunHandler(callback, "GET", web.NewHeader(web.HeaderCookie, cookie), nil, router)
+	if status != web.StatusBadRequest {
+		t.Errorf("state mismatch status=%d", status)
+	}
+
+	// Error from authorization server.
+	cookie, callback = login("/")
+	u, _ := url.Parse(callback)
+	q := url.Values{"state": {u.Query().Get("state")}, "error": {"access_denied"}}
+	status, _, _ = web.RunHandler("http://example.com/callback?"+q.Encode(), "GET", web.NewHeader(web.HeaderCookie, cookie), nil, router)
+	if status != web.StatusForbidden {
+		t.Errorf("access denied status=%d", status)
+	}
+
+	// Code replay.
+	cookie, callback = login("/")
+	web.RunHandler(callback, "GET", web.NewHeader(web.HeaderCookie, cookie), nil, router)
+	status, _, _ = web.RunHandler(callback, "GET", web.NewHeader(web.HeaderCookie, cookie), nil, router)
+	if status != web.StatusBadGateway {
+		t.Errorf("code replay status=%d", status)
+	}
+}
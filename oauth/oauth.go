@@ -0,0 +1,272 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+// Package oauth implements an OAuth 2.0 (RFC 6749) client.
+//
+// The package supports the authorization code grant with PKCE (RFC 7636) and
+// token refresh. Use the Flow type to add login to an application:
+//
+//  flow := &oauth.Flow{
+//      Config: &oauth.Config{
+//          ClientID:     "client",
+//          ClientSecret: "secret",
+//          AuthURL:      "https://auth.example.com/authorize",
+//          TokenURL:     "https://auth.example.com/token",
+//          RedirectURL:  "https://app.example.com/callback",
+//          Scopes:       []string{"profile"},
+//      },
+//      Secret: cookieSecret,
+//      Success: func(req *web.Request, token *oauth.Token, returnTo string) {
+//          // Save the token in the session.
+//          req.Redirect(returnTo, false)
+//      },
+//  }
+//  router.Register("/login", "GET", flow.LoginHandler())
+//  router.Register("/callback", "GET", flow.CallbackHandler())
+package oauth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config describes a client application and the authorization server
+// endpoints.
+type Config struct {
+	// Client credentials issued by the authorization server. ClientSecret is
+	// "" for public clients.
+	ClientID     string
+	ClientSecret string
+
+	// AuthURL is the authorization endpoint and TokenURL is the token
+	// endpoint.
+	AuthURL  string
+	TokenURL string
+
+	// RedirectURL is the URL of the callback handler.
+	RedirectURL string
+
+	// Scopes requested from the authorization server.
+	Scopes []string
+
+	// Client is used for requests to the token endpoint. If nil, then a
+	// client with a thirty second timeout is used.
+	Client *http.Client
+}
+
+// Token is an access token and the associated information returned from the
+// token endpoint.
+type Token struct {
+	AccessToken  string
+	TokenType    string
+	RefreshToken string
+
+	// Expiry is the time when the access token expires. Expiry is the zero
+	// time if the token endpoint did not return an expiration.
+	Expiry time.Time
+
+	// Scope is the scope granted by the authorization server. Scope is "" if
+	// the granted scope is the requested scope.
+	Scope string
+
+	// Extra holds all of the fields in the token endpoint response.
+	Extra map[string]interface{}
+}
+
+// Valid returns true if the token has an access token that has not expired.
+func (t *Token) Valid() bool {
+	return t != nil && t.AccessToken != "" && (t.Expiry.IsZero() || time.Now().Before(t.Expiry))
+}
+
+// Error is an error response from the authorization server (RFC 6749
+// sections 4.1.2.1 and 5.2).
+type Error struct {
+	Code        string
+	Description string
+	URI         string
+}
+
+func (e *Error) Error() string {
+	s := "oauth: " + e.Code
+	if e.Description != "" {
+		s += ": " + e.Description
+	}
+	return s
+}
+
+// NewVerifier returns a new random PKCE code verifier.
+func NewVerifier() string {
+	p := make([]byte, 32)
+	if _, err := rand.Read(p); err != nil {
+		panic("twister: reading random bytes: " + err.Error())
+	}
+	return base64.RawURLEncoding.EncodeToString(p)
+}
+
+// S256Challenge returns the PKCE code challenge for verifier using the S256
+// method.
+func S256Challenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// AuthCodeURL returns the URL of the authorization endpoint for the
+// authorization code grant. The state parameter protects the callback from
+// cross-site request forgery. The verifier parameter is a PKCE code verifier
+// created with NewVerifier. If the verifier is "", then PKCE is not used.
+func (c *Config) AuthCodeURL(state, verifier string) string {
+	v := url.Values{
+		"response_type": {"code"},
+		"client_id":     {c.ClientID},
+	}
+	if c.RedirectURL != "" {
+		v.Set("redirect_uri", c.RedirectURL)
+	}
+	if len(c.Scopes) > 0 {
+		v.Set("scope", strings.Join(c.Scopes, " "))
+	}
+	if state != "" {
+		v.Set("state", state)
+	}
+	if verifier != "" {
+		v.Set("code_challenge", S256Challenge(verifier))
+		v.Set("code_challenge_method", "S256")
+	}
+	sep := "?"
+	if strings.Contains(c.AuthURL, "?") {
+		sep = "&"
+	}
+	return c.AuthURL + sep + v.Encode()
+}
+
+// Exchange exchanges an authorization code for a token. The verifier is the
+// PKCE code verifier passed to AuthCodeURL.
+func (c *Config) Exchange(code, verifier string) (*Token, error) {
+	v := url.Values{
+		"grant_type": {"authorization_code"},
+		"code":       {code},
+	}
+	if c.RedirectURL != "" {
+		v.Set("redirect_uri", c.RedirectURL)
+	}
+	if verifier != "" {
+		v.Set("code_verifier", verifier)
+	}
+	return c.requestToken(v)
+}
+
+// Refresh uses a refresh token to get a new token. If the token endpoint does
+// not return a new refresh token, then the returned token has the refresh
+// token passed to this method.
+func (c *Config) Refresh(refreshToken string) (*Token, error) {
+	t, err := c.requestToken(url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {refreshToken},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if t.RefreshToken == "" {
+		t.RefreshToken = refreshToken
+	}
+	return t, nil
+}
+
+// maxTokenResponseSize limits the size of token endpoint responses.
+const maxTokenResponseSize = 1 << 20
+
+// defaultClient is used for requests to the token endpoint when
+// Config.Client is nil. The timeout prevents an unresponsive authorization
+// server from blocking the callback handler.
+var defaultClient = &http.Client{Timeout: 30 * time.Second}
+
+func (c *Config) requestToken(v url.Values) (*Token, error) {
+	if c.ClientSecret == "" {
+		v.Set("client_id", c.ClientID)
+	}
+	r, err := http.NewRequest("POST", c.TokenURL, strings.NewReader(v.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.Header.Set("Accept", "application/json")
+	if c.ClientSecret != "" {
+		// RFC 6749 section 2.3.1 requires form encoding of the credentials.
+		r.SetBasicAuth(url.QueryEscape(c.ClientID), url.QueryEscape(c.ClientSecret))
+	}
+	client := c.Client
+	if client == nil {
+		client = defaultClient
+	}
+	resp, err := client.Do(r)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	p, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxTokenResponseSize))
+	if err != nil {
+		return nil, err
+	}
+	return parseTokenResponse(resp.StatusCode, p)
+}
+
+func parseTokenResponse(status int, p []byte) (*Token, error) {
+	var m map[string]interface{}
+	if err := json.Unmarshal(p, &m); err != nil {
+		if status != http.StatusOK {
+			return nil, errors.New("oauth: token endpoint returned status " + strconv.Itoa(status))
+		}
+		return nil, errors.New("oauth: bad token response: " + err.Error())
+	}
+	str := func(name string) string {
+		s, _ := m[name].(string)
+		return s
+	}
+	if code := str("error"); code != "" || status != http.StatusOK {
+		if code == "" {
+			return nil, errors.New("oauth: token endpoint returned status " + strconv.Itoa(status))
+		}
+		return nil, &Error{Code: code, Description: str("error_description"), URI: str("error_uri")}
+	}
+	t := &Token{
+		AccessToken:  str("access_token"),
+		TokenType:    str("token_type"),
+		RefreshToken: str("refresh_token"),
+		Scope:        str("scope"),
+		Extra:        m,
+	}
+	if t.AccessToken == "" {
+		return nil, errors.New("oauth: token response missing access_token")
+	}
+	// Some servers return expires_in as a string.
+	switch expiresIn := m["expires_in"].(type) {
+	case float64:
+		t.Expiry = time.Now().Add(time.Duration(expiresIn) * time.Second)
+	case string:
+		if n, err := strconv.ParseInt(expiresIn, 10, 64); err == nil {
+			t.Expiry = time.Now().Add(time.Duration(n) * time.Second)
+		}
+	}
+	return t, nil
+}
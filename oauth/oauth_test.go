@@ -0,0 +1,224 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package oauth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeServer is an in-process authorization server.
+type fakeServer struct {
+	*httptest.Server
+	mu         sync.Mutex
+	challenges map[string]string // code -> PKCE challenge
+}
+
+func newFakeServer() *fakeServer {
+	s := &fakeServer{challenges: make(map[string]string)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/authorize", s.authorize)
+	mux.HandleFunc("/token", s.token)
+	s.Server = httptest.NewServer(mux)
+	return s
+}
+
+func (s *fakeServer) config() *Config {
+	return &Config{
+		ClientID:     "client id",
+		ClientSecret: "se:cret",
+		AuthURL:      s.URL + "/authorize",
+		TokenURL:     s.URL + "/token",
+		RedirectURL:  "http://example.com/callback",
+		Scopes:       []string{"a", "b"},
+	}
+}
+
+// authorize issues a code and redirects to the client.
+func (s *fakeServer) authorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("response_type") != "code" || q.Get("client_id") != "client id" || q.Get("code_challenge_method") != "S256" {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	code := "code" + q.Get("state")[:8]
+	s.mu.Lock()
+	s.challenges[code] = q.Get("code_challenge")
+	s.mu.Unlock()
+	http.Redirect(w, r, q.Get("redirect_uri")+"?"+url.Values{"code": {code}, "state": {q.Get("state")}}.Encode(), http.StatusFound)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func (s *fakeServer) token(w http.ResponseWriter, r *http.Request) {
+	id, secret, _ := r.BasicAuth()
+	if id != url.QueryEscape("client id") || secret != url.QueryEscape("se:cret") {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	}
+	switch r.PostFormValue("grant_type") {
+	case "authorization_code":
+		code := r.PostFormValue("code")
+		s.mu.Lock()
+		challenge, found := s.challenges[code]
+		delete(s.challenges, code)
+		s.mu.Unlock()
+		if !found || S256Challenge(r.PostFormValue("code_verifier")) != challenge {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant", "error_description": "bad code"})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"access_token": "access-" + code, "token_type": "Bearer",
+			"refresh_token": "refresh", "expires_in": 3600})
+	case "refresh_token":
+		if r.PostFormValue("refresh_token") != "refresh" {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"access_token": "refreshed", "token_type": "Bearer", "expires_in": "60"})
+	default:
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "unsupported_grant_type"})
+	}
+}
+
+func TestAuthCodeURL(t *testing.T) {
+	c := &Config{ClientID: "id", AuthURL: "https://example.com/auth?x=1", RedirectURL: "https://app/cb", Scopes: []string{"a", "b"}}
+	u, err := url.Parse(c.AuthCodeURL("st", "verifier"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := u.Query()
+	for name, want := range map[string]string{
+		"x":                     "1",
+		"response_type":         "code",
+		"client_id":             "id",
+		"redirect_uri":          "https://app/cb",
+		"scope":                 "a b",
+		"state":                 "st",
+		"code_challenge":        S256Challenge("verifier"),
+		"code_challenge_method": "S256",
+	} {
+		if q.Get(name) != want {
+			t.Errorf("%s=%q, want %q", name, q.Get(name), want)
+		}
+	}
+}
+
+func TestS256Challenge(t *testing.T) {
+	// Example from RFC 7636 appendix B.
+	if c := S256Challenge("dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"); c != "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM" {
+		t.Errorf("challenge=%q", c)
+	}
+}
+
+func TestExchangeAndRefresh(t *testing.T) {
+	s := newFakeServer()
+	defer s.Close()
+	c := s.config()
+
+	verifier := NewVerifier()
+	s.challenges["code1"] = S256Challenge(verifier)
+	token, err := c.Exchange("code1", verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.AccessToken != "access-code1" || token.RefreshToken != "refresh" || !token.Valid() ||
+		token.Expiry.Before(time.Now().Add(59*time.Minute)) {
+		t.Errorf("token=%+v", token)
+	}
+
+	s.challenges["code2"] = S256Challenge(verifier)
+	_, err = c.Exchange("code2", "wrong")
+	if e, ok := err.(*Error); !ok || e.Code != "invalid_grant" || e.Description != "bad code" {
+		t.Errorf("Exchange with wrong verifier returned %v", err)
+	}
+
+	token, err = c.Refresh("refresh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.AccessToken != "refreshed" || token.RefreshToken != "refresh" || token.Expiry.IsZero() {
+		t.Errorf("refreshed token=%+v", token)
+	}
+
+	c.ClientSecret = "wrong"
+	_, err = c.Refresh("refresh")
+	if e, ok := err.(*Error); !ok || e.Code != "invalid_client" {
+		t.Errorf("Refresh with wrong secret returned %v", err)
+	}
+}
+
+func TestExchangeTimeout(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	timeout := defaultClient.Timeout
+	defaultClient.Timeout = 50 * time.Millisecond
+	defer func() { defaultClient.Timeout = timeout }()
+
+	c := &Config{ClientID: "app", TokenURL: srv.URL}
+	done := make(chan error, 1)
+	go func() {
+		_, err := c.Exchange("code", "verifier")
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("Exchange did not return error")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Exchange did not time out")
+	}
+}
+
+func TestParseTokenResponse(t *testing.T) {
+	tests := []struct {
+		status int
+		body   string
+		ok     bool
+	}{
+		{200, `{"access_token": "a", "token_type": "Bearer"}`, true},
+		{200, `{"token_type": "Bearer"}`, false},
+		{200, `not json`, false},
+		{500, `<html>`, false},
+		{500, `{}`, false},
+		{400, `{"error": "invalid_request"}`, false},
+	}
+	for _, tt := range tests {
+		token, err := parseTokenResponse(tt.status, []byte(tt.body))
+		if tt.ok != (err == nil) {
+			t.Errorf("%d %s: err=%v", tt.status, tt.body, err)
+		}
+		if err == nil && !strings.EqualFold(token.TokenType, "bearer") {
+			t.Errorf("%d %s: token=%+v", tt.status, tt.body, token)
+		}
+	}
+}
@@ -0,0 +1,624 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+// Package provider implements an OAuth 2.0 (RFC 6749) authorization server.
+//
+// The server supports the authorization code grant with PKCE (RFC 7636), the
+// client credentials grant and refresh tokens. The server also implements
+// token revocation (RFC 7009) and token introspection (RFC 7662).
+//
+// Clients, grants and tokens are stored using the ClientStore, GrantStore
+// and TokenStore interfaces. The application authenticates the user and
+// renders the consent screen in the Consent function. The consent form must
+// be submitted with POST and include the parameters returned from
+// ar.Params(), which carry a CSRF token bound to the authorization endpoint:
+//
+//  s := &provider.Server{
+//      Clients: provider.ClientMap{"app": &provider.Client{...}},
+//      Grants:  store,
+//      Tokens:  store,
+//      CSRF:    &web.CSRFOptions{Secret: csrfSecret},
+//      Consent: func(req *web.Request, ar *provider.AuthorizeRequest) {
+//          user := currentUser(req)
+//          if user == "" {
+//              // Redirect to login.
+//              return
+//          }
+//          if req.Method != "POST" {
+//              // Render a form that posts ar.Params() and a consent
+//              // parameter of "allow" or "deny" to the authorization
+//              // endpoint.
+//              return
+//          }
+//          switch req.Param.Get("consent") {
+//          case "allow":
+//              ar.Approve(req, user, nil)
+//          default:
+//              ar.Deny(req)
+//          }
+//      },
+//  }
+//  s.Register(router, "/oauth")
+package provider
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"github.com/garyburd/twister/web"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// RFC 6749 error codes.
+const (
+	ErrorInvalidRequest          = "invalid_request"
+	ErrorInvalidClient           = "invalid_client"
+	ErrorInvalidGrant            = "invalid_grant"
+	ErrorUnauthorizedClient      = "unauthorized_client"
+	ErrorUnsupportedGrantType    = "unsupported_grant_type"
+	ErrorUnsupportedResponseType = "unsupported_response_type"
+	ErrorInvalidScope            = "invalid_scope"
+	ErrorAccessDenied            = "access_denied"
+	ErrorServerError             = "server_error"
+)
+
+// Error is an RFC 6749 error.
+type Error struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+}
+
+func (e *Error) Error() string {
+	s := "provider: " + e.Code
+	if e.Description != "" {
+		s += ": " + e.Description
+	}
+	return s
+}
+
+// Server is an OAuth 2.0 authorization server.
+type Server struct {
+	Clients ClientStore
+	Grants  GrantStore
+	Tokens  TokenStore
+
+	// Consent is called for valid authorization requests. The function
+	// authenticates the user and asks the user to approve the request. The
+	// function must respond to the request, typically by rendering a consent
+	// screen or by calling ar.Approve or ar.Deny.
+	Consent func(req *web.Request, ar *AuthorizeRequest)
+
+	// CSRF specifies the cross-site request forgery protection for the
+	// authorization endpoint. Register requires CSRF. Applications that
+	// call ServeAuthorize directly must dispatch the request through
+	// web.CSRFHandler with these options.
+	CSRF *web.CSRFOptions
+
+	// Lifetimes of authorization codes, access tokens and refresh tokens. If
+	// zero, then five minutes, one hour and thirty days are used.
+	CodeLifetime         time.Duration
+	AccessTokenLifetime  time.Duration
+	RefreshTokenLifetime time.Duration
+}
+
+// maxRequestBodyLen limits the size of form encoded request bodies.
+const maxRequestBodyLen = 64 * 1024
+
+func lifetime(d, def time.Duration) time.Duration {
+	if d == 0 {
+		return def
+	}
+	return d
+}
+
+// Register registers the authorization, token, revocation and introspection
+// endpoints with router at the paths prefix + "/authorize", prefix +
+// "/token", prefix + "/revoke" and prefix + "/introspect".
+// Register panics if s.CSRF is nil.
+func (s *Server) Register(router *web.Router, prefix string) *web.Router {
+	if s.CSRF == nil {
+		panic("twister: provider.Server.CSRF is required")
+	}
+	authorize := web.FormHandler(maxRequestBodyLen, false,
+		web.CSRFHandler(s.CSRF, web.HandlerFunc(s.ServeAuthorize)))
+	return router.
+		Register(prefix+"/authorize", "GET", authorize, "POST", authorize).
+		Register(prefix+"/token", "POST", s.ServeToken).
+		Register(prefix+"/revoke", "POST", s.ServeRevoke).
+		Register(prefix+"/introspect", "POST", s.ServeIntrospect)
+}
+
+func newToken() (string, error) {
+	p := make([]byte, 32)
+	if _, err := rand.Read(p); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(p), nil
+}
+
+// isSubset returns true if all elements of a are in b.
+func isSubset(a, b []string) bool {
+	for _, s := range a {
+		if !contains(b, s) {
+			return false
+		}
+	}
+	return true
+}
+
+// AuthorizeRequest is a validated authorization request.
+type AuthorizeRequest struct {
+	Client *Client
+
+	// RedirectURI is the URI where the response is sent.
+	RedirectURI string
+
+	// Scope is the requested scope.
+	Scope []string
+
+	State string
+
+	server           *Server
+	redirectURIParam string
+	challenge        string
+	challengeMethod  string
+	csrfToken        string
+}
+
+var errConsentNotConfirmed = errors.New("provider: consent not submitted with POST and a valid CSRF token")
+
+// csrfParamName returns the name of the request parameter for CSRF tokens.
+func (s *Server) csrfParamName() string {
+	if s.CSRF != nil && s.CSRF.ParamName != "" {
+		return s.CSRF.ParamName
+	}
+	return "csrf"
+}
+
+// Params returns the parameters of the authorization request. Include the
+// parameters in the consent form so the form submission is validated as an
+// authorization request. The parameters include a CSRF token that is only
+// valid for a POST to the authorization endpoint.
+func (ar *AuthorizeRequest) Params() url.Values {
+	v := url.Values{
+		"response_type": {"code"},
+		"client_id":     {ar.Client.ID},
+	}
+	v.Set(ar.server.csrfParamName(), ar.csrfToken)
+	if ar.redirectURIParam != "" {
+		v.Set("redirect_uri", ar.redirectURIParam)
+	}
+	if len(ar.Scope) > 0 {
+		v.Set("scope", strings.Join(ar.Scope, " "))
+	}
+	if ar.State != "" {
+		v.Set("state", ar.State)
+	}
+	if ar.challenge != "" {
+		v.Set("code_challenge", ar.challenge)
+		v.Set("code_challenge_method", ar.challengeMethod)
+	}
+	return v
+}
+
+// redirect responds to the request with a redirect to the client's redirect
+// URI with the given parameters and the state.
+func (ar *AuthorizeRequest) redirect(req *web.Request, v url.Values) {
+	u, err := url.Parse(ar.RedirectURI)
+	if err != nil {
+		req.Error(web.StatusInternalServerError, err)
+		return
+	}
+	if ar.State != "" {
+		v.Set("state", ar.State)
+	}
+	q := u.Query()
+	for k, values := range v {
+		q[k] = values
+	}
+	u.RawQuery = q.Encode()
+	req.Redirect(u.String(), false, web.HeaderCacheControl, "no-store")
+}
+
+func (ar *AuthorizeRequest) redirectError(req *web.Request, code, description string) {
+	v := url.Values{"error": {code}}
+	if description != "" {
+		v.Set("error_description", description)
+	}
+	ar.redirect(req, v)
+}
+
+// Approve issues an authorization code for subject and redirects to the
+// client. The subject identifies the user who approved the request. If scope
+// is nil, then the requested scope is granted. Otherwise, scope must be a
+// subset of the requested scope.
+//
+// Approve responds with HTTP status 403 unless the request is a POST with the
+// CSRF token from Params. Consent obtained with a GET or from a forged form
+// is not accepted.
+func (ar *AuthorizeRequest) Approve(req *web.Request, subject string, scope []string) {
+	if req.Method != "POST" ||
+		!hmac.Equal([]byte(req.Param.Get(ar.server.csrfParamName())), []byte(ar.csrfToken)) {
+		req.Error(web.StatusForbidden, errConsentNotConfirmed)
+		return
+	}
+	if scope == nil {
+		scope = ar.Scope
+	} else if !isSubset(scope, ar.Scope) {
+		ar.redirectError(req, ErrorInvalidScope, "granted scope exceeds requested scope")
+		return
+	}
+	code, err := newToken()
+	if err != nil {
+		ar.redirectError(req, ErrorServerError, "")
+		return
+	}
+	g := &Grant{
+		Code:            code,
+		ClientID:        ar.Client.ID,
+		RedirectURI:     ar.redirectURIParam,
+		Scope:           scope,
+		Subject:         subject,
+		Challenge:       ar.challenge,
+		ChallengeMethod: ar.challengeMethod,
+		Expires:         time.Now().Add(lifetime(ar.server.CodeLifetime, 5*time.Minute)),
+	}
+	if err := ar.server.Grants.SaveGrant(g); err != nil {
+		ar.redirectError(req, ErrorServerError, "")
+		return
+	}
+	ar.redirect(req, url.Values{"code": {code}})
+}
+
+// Deny redirects to the client with the access_denied error.
+func (ar *AuthorizeRequest) Deny(req *web.Request) {
+	ar.redirectError(req, ErrorAccessDenied, "")
+}
+
+// ServeAuthorize handles requests to the authorization endpoint. If the
+// client or redirect URI is not valid, then ServeAuthorize responds with HTTP
+// status 400 and an *Error. Other errors are returned to the client in the
+// redirect. Valid requests are passed to the Consent function.
+//
+// ServeAuthorize panics if the request was not dispatched through
+// web.CSRFHandler.
+func (s *Server) ServeAuthorize(req *web.Request) {
+	if err := req.ParseForm(maxRequestBodyLen); err != nil {
+		req.Error(web.StatusBadRequest, err)
+		return
+	}
+	p := req.Param
+	client, err := s.Clients.Client(p.Get("client_id"))
+	if err == ErrNotFound {
+		req.Error(web.StatusBadRequest, &Error{ErrorInvalidRequest, "unknown client"})
+		return
+	} else if err != nil {
+		req.Error(web.StatusInternalServerError, err)
+		return
+	}
+	ar := &AuthorizeRequest{
+		Client:           client,
+		State:            p.Get("state"),
+		Scope:            strings.Fields(p.Get("scope")),
+		server:           s,
+		redirectURIParam: p.Get("redirect_uri"),
+		challenge:        p.Get("code_challenge"),
+		challengeMethod:  p.Get("code_challenge_method"),
+		csrfToken:        web.CSRFActionToken(req, "POST", req.URL.Path),
+	}
+	switch {
+	case ar.redirectURIParam != "" && contains(client.RedirectURIs, ar.redirectURIParam):
+		ar.RedirectURI = ar.redirectURIParam
+	case ar.redirectURIParam == "" && len(client.RedirectURIs) == 1:
+		ar.RedirectURI = client.RedirectURIs[0]
+	default:
+		req.Error(web.StatusBadRequest, &Error{ErrorInvalidRequest, "redirect_uri not registered"})
+		return
+	}
+
+	switch {
+	case p.Get("response_type") != "code":
+		ar.redirectError(req, ErrorUnsupportedResponseType, "")
+	case !contains(client.GrantTypes, "authorization_code"):
+		ar.redirectError(req, ErrorUnauthorizedClient, "")
+	case !isSubset(ar.Scope, client.Scopes):
+		ar.redirectError(req, ErrorInvalidScope, "")
+	case ar.challenge == "" && client.Secret == "":
+		ar.redirectError(req, ErrorInvalidRequest, "code_challenge required")
+	case ar.challenge != "" && ar.challengeMethod != "S256":
+		ar.redirectError(req, ErrorInvalidRequest, "code_challenge_method must be S256")
+	default:
+		s.Consent(req, ar)
+	}
+}
+
+// tokenError responds to a token, revocation or introspection request with an
+// error.
+func tokenError(req *web.Request, code, description string) {
+	status := web.StatusBadRequest
+	var header []string
+	if code == ErrorInvalidClient {
+		status = web.StatusUnauthorized
+		header = []string{web.HeaderWWWAuthenticate, `Basic realm="oauth"`}
+	}
+	header = append(header, web.HeaderCacheControl, "no-store", web.HeaderPragma, "no-cache")
+	web.RespondJSON(req, status, &Error{code, description}, header...)
+}
+
+// authenticateClient returns the client authenticated by HTTP Basic
+// authentication or the client_id and client_secret parameters.
+func (s *Server) authenticateClient(req *web.Request) (*Client, error) {
+	id, secret, ok := basicAuth(req.Header.Get(web.HeaderAuthorization))
+	if !ok {
+		id = req.Param.Get("client_id")
+		secret = req.Param.Get("client_secret")
+	}
+	if id == "" {
+		return nil, &Error{ErrorInvalidClient, "client authentication required"}
+	}
+	client, err := s.Clients.Client(id)
+	if err == ErrNotFound {
+		return nil, &Error{ErrorInvalidClient, "unknown client"}
+	} else if err != nil {
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare([]byte(secret), []byte(client.Secret)) != 1 {
+		return nil, &Error{ErrorInvalidClient, "client authentication failed"}
+	}
+	return client, nil
+}
+
+// basicAuth returns the form decoded credentials from a Basic Authorization
+// header value.
+func basicAuth(s string) (id, secret string, ok bool) {
+	const prefix = "basic "
+	if len(s) < len(prefix) || !strings.EqualFold(s[:len(prefix)], prefix) {
+		return "", "", false
+	}
+	p, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s[len(prefix):]))
+	if err != nil {
+		return "", "", false
+	}
+	i := strings.IndexByte(string(p), ':')
+	if i < 0 {
+		return "", "", false
+	}
+	id, err1 := url.QueryUnescape(string(p[:i]))
+	secret, err2 := url.QueryUnescape(string(p[i+1:]))
+	if err1 != nil || err2 != nil {
+		return "", "", false
+	}
+	return id, secret, true
+}
+
+// beginTokenRequest parses the request and authenticates the client. If an
+// error occurs, then beginTokenRequest responds to the request and returns
+// nil.
+func (s *Server) beginTokenRequest(req *web.Request) *Client {
+	if err := req.ParseForm(maxRequestBodyLen); err != nil {
+		tokenError(req, ErrorInvalidRequest, err.Error())
+		return nil
+	}
+	client, err := s.authenticateClient(req)
+	if e, ok := err.(*Error); ok {
+		tokenError(req, e.Code, e.Description)
+		return nil
+	} else if err != nil {
+		req.Error(web.StatusInternalServerError, err)
+		return nil
+	}
+	return client
+}
+
+// ServeToken handles requests to the token endpoint.
+func (s *Server) ServeToken(req *web.Request) {
+	client := s.beginTokenRequest(req)
+	if client == nil {
+		return
+	}
+	grantType := req.Param.Get("grant_type")
+	switch grantType {
+	case "authorization_code", "client_credentials", "refresh_token":
+		if !contains(client.GrantTypes, grantType) {
+			tokenError(req, ErrorUnauthorizedClient, "")
+			return
+		}
+	default:
+		tokenError(req, ErrorUnsupportedGrantType, "")
+		return
+	}
+
+	var (
+		subject string
+		scope   []string
+	)
+	switch grantType {
+	case "authorization_code":
+		g, err := s.Grants.TakeGrant(req.Param.Get("code"))
+		if err == ErrNotFound {
+			tokenError(req, ErrorInvalidGrant, "unknown code")
+			return
+		} else if err != nil {
+			req.Error(web.StatusInternalServerError, err)
+			return
+		}
+		if g.ClientID != client.ID || time.Now().After(g.Expires) || req.Param.Get("redirect_uri") != g.RedirectURI {
+			tokenError(req, ErrorInvalidGrant, "")
+			return
+		}
+		verifier := req.Param.Get("code_verifier")
+		if g.Challenge != "" {
+			sum := sha256.Sum256([]byte(verifier))
+			if subtle.ConstantTimeCompare([]byte(base64.RawURLEncoding.EncodeToString(sum[:])), []byte(g.Challenge)) != 1 {
+				tokenError(req, ErrorInvalidGrant, "code_verifier does not match")
+				return
+			}
+		} else if verifier != "" {
+			tokenError(req, ErrorInvalidGrant, "code_verifier not expected")
+			return
+		}
+		subject, scope = g.Subject, g.Scope
+	case "client_credentials":
+		if client.Secret == "" {
+			tokenError(req, ErrorUnauthorizedClient, "public client")
+			return
+		}
+		scope = strings.Fields(req.Param.Get("scope"))
+		if !isSubset(scope, client.Scopes) {
+			tokenError(req, ErrorInvalidScope, "")
+			return
+		}
+	case "refresh_token":
+		// Take the refresh token to rotate it. Concurrent requests with the
+		// same refresh token cannot both succeed. The token is consumed even
+		// if the request fails.
+		t, err := s.Tokens.TakeToken(req.Param.Get("refresh_token"))
+		if err == ErrNotFound {
+			tokenError(req, ErrorInvalidGrant, "unknown refresh token")
+			return
+		} else if err != nil {
+			req.Error(web.StatusInternalServerError, err)
+			return
+		}
+		if t.Type != RefreshToken || t.ClientID != client.ID || (!t.Expires.IsZero() && time.Now().After(t.Expires)) {
+			tokenError(req, ErrorInvalidGrant, "")
+			return
+		}
+		scope = t.Scope
+		if requested := strings.Fields(req.Param.Get("scope")); len(requested) > 0 {
+			if !isSubset(requested, t.Scope) {
+				tokenError(req, ErrorInvalidScope, "")
+				return
+			}
+			scope = requested
+		}
+		subject = t.Subject
+	}
+
+	issueRefresh := grantType != "client_credentials" && contains(client.GrantTypes, "refresh_token")
+	s.issueTokens(req, client, subject, scope, issueRefresh)
+}
+
+// issueTokens creates tokens and writes the token response.
+func (s *Server) issueTokens(req *web.Request, client *Client, subject string, scope []string, issueRefresh bool) {
+	now := time.Now()
+	accessLifetime := lifetime(s.AccessTokenLifetime, time.Hour)
+	access := &TokenInfo{
+		Type:     AccessToken,
+		ClientID: client.ID,
+		Subject:  subject,
+		Scope:    scope,
+		Expires:  now.Add(accessLifetime),
+	}
+	var err error
+	if access.Token, err = newToken(); err != nil {
+		req.Error(web.StatusInternalServerError, err)
+		return
+	}
+	if err := s.Tokens.SaveToken(access); err != nil {
+		req.Error(web.StatusInternalServerError, err)
+		return
+	}
+	resp := map[string]interface{}{
+		"access_token": access.Token,
+		"token_type":   "Bearer",
+		"expires_in":   int(accessLifetime / time.Second),
+	}
+	if len(scope) > 0 {
+		resp["scope"] = strings.Join(scope, " ")
+	}
+	if issueRefresh {
+		refresh := &TokenInfo{
+			Type:     RefreshToken,
+			ClientID: client.ID,
+			Subject:  subject,
+			Scope:    scope,
+			Expires:  now.Add(lifetime(s.RefreshTokenLifetime, 30*24*time.Hour)),
+		}
+		if refresh.Token, err = newToken(); err != nil {
+			req.Error(web.StatusInternalServerError, err)
+			return
+		}
+		if err := s.Tokens.SaveToken(refresh); err != nil {
+			req.Error(web.StatusInternalServerError, err)
+			return
+		}
+		resp["refresh_token"] = refresh.Token
+	}
+	web.RespondJSON(req, web.StatusOK, resp, web.HeaderCacheControl, "no-store", web.HeaderPragma, "no-cache")
+}
+
+// ServeRevoke handles requests to the token revocation endpoint (RFC 7009).
+// Tokens issued to other clients and unknown tokens are ignored.
+func (s *Server) ServeRevoke(req *web.Request) {
+	client := s.beginTokenRequest(req)
+	if client == nil {
+		return
+	}
+	token := req.Param.Get("token")
+	if token == "" {
+		tokenError(req, ErrorInvalidRequest, "token required")
+		return
+	}
+	t, err := s.Tokens.Token(token)
+	if err == nil && t.ClientID == client.ID {
+		err = s.Tokens.DeleteToken(token)
+	}
+	if err != nil && err != ErrNotFound {
+		req.Error(web.StatusInternalServerError, err)
+		return
+	}
+	req.Respond(web.StatusOK, web.HeaderContentLength, "0")
+}
+
+// ServeIntrospect handles requests to the token introspection endpoint (RFC
+// 7662). Any authenticated confidential client can introspect tokens.
+func (s *Server) ServeIntrospect(req *web.Request) {
+	client := s.beginTokenRequest(req)
+	if client == nil {
+		return
+	}
+	if client.Secret == "" {
+		tokenError(req, ErrorUnauthorizedClient, "public client")
+		return
+	}
+	resp := map[string]interface{}{"active": false}
+	t, err := s.Tokens.Token(req.Param.Get("token"))
+	if err != nil && err != ErrNotFound {
+		req.Error(web.StatusInternalServerError, err)
+		return
+	}
+	if err == nil && (t.Expires.IsZero() || time.Now().Before(t.Expires)) {
+		resp["active"] = true
+		resp["client_id"] = t.ClientID
+		if t.Subject != "" {
+			resp["sub"] = t.Subject
+		}
+		if len(t.Scope) > 0 {
+			resp["scope"] = strings.Join(t.Scope, " ")
+		}
+		if !t.Expires.IsZero() {
+			resp["exp"] = t.Expires.Unix()
+		}
+		if t.Type == AccessToken {
+			resp["token_type"] = "Bearer"
+		}
+	}
+	web.RespondJSON(req, web.StatusOK, resp, web.HeaderCacheControl, "no-store")
+}
@@ -0,0 +1,355 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package provider
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/garyburd/twister/web"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func newTestServer() (*Server, web.Handler) {
+	store := NewMemoryStore()
+	s := &Server{
+		Clients: ClientMap{
+			"app": &Client{
+				ID:           "app",
+				Secret:       "se:cret",
+				RedirectURIs: []string{"https://app.example.com/cb?x=1"},
+				Scopes:       []string{"read", "write"},
+				GrantTypes:   []string{"authorization_code", "client_credentials", "refresh_token"},
+			},
+			"spa": &Client{
+				ID:           "spa",
+				RedirectURIs: []string{"https://spa.example.com/a", "https://spa.example.com/b"},
+				Scopes:       []string{"read"},
+				GrantTypes:   []string{"authorization_code"},
+			},
+		},
+		Grants: store,
+		Tokens: store,
+		CSRF:   &web.CSRFOptions{Secret: "csrf-secret"},
+		Consent: func(req *web.Request, ar *AuthorizeRequest) {
+			switch req.Param.Get("consent") {
+			case "allow":
+				ar.Approve(req, "gopher", nil)
+			case "deny":
+				ar.Deny(req)
+			default:
+				req.Respond(web.StatusOK).Write([]byte(ar.Params().Encode()))
+			}
+		},
+	}
+	return s, s.Register(web.NewRouter(), "/oauth")
+}
+
+func post(h web.Handler, path string, v url.Values, kvs ...string) (int, web.Header, map[string]interface{}) {
+	body := []byte(v.Encode())
+	header := web.NewHeader(kvs...)
+	header.Set(web.HeaderContentType, "application/x-www-form-urlencoded")
+	header.Set(web.HeaderContentLength, strconv.Itoa(len(body)))
+	status, respHeader, respBody := web.RunHandler("https://auth.example.com"+path, "POST", header, body, h)
+	var m map[string]interface{}
+	json.Unmarshal(respBody, &m)
+	return status, respHeader, m
+}
+
+func basic(id, secret string) string {
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(url.QueryEscape(id)+":"+url.QueryEscape(secret)))
+}
+
+func challenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// authorize runs an authorization request and returns the redirect
+// parameters.
+func authorize(t *testing.T, h web.Handler, v url.Values) (int, url.Values) {
+	status, header, _ := web.RunHandler("https://auth.example.com/oauth/authorize?"+v.Encode(), "GET", nil, nil, h)
+	if status != web.StatusFound {
+		return status, nil
+	}
+	u, err := url.Parse(header.Get(web.HeaderLocation))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return status, u.Query()
+}
+
+// consentForm runs an authorization request and returns the CSRF cookie and
+// the parameters of the consent form.
+func consentForm(t *testing.T, h web.Handler, v url.Values) (string, url.Values) {
+	status, header, body := web.RunHandler("https://auth.example.com/oauth/authorize?"+v.Encode(), "GET", nil, nil, h)
+	if status != web.StatusOK {
+		t.Fatalf("consent form status=%d", status)
+	}
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cookie := header.Get(web.HeaderSetCookie)
+	if i := strings.Index(cookie, ";"); i >= 0 {
+		cookie = cookie[:i]
+	}
+	return cookie, form
+}
+
+// approve runs an authorization request, submits the consent form with
+// consent=allow and returns the redirect parameters.
+func approve(t *testing.T, h web.Handler, v url.Values) (int, url.Values) {
+	cookie, form := consentForm(t, h, v)
+	form.Set("consent", "allow")
+	status, header, _ := post(h, "/oauth/authorize", form,
+		web.HeaderCookie, cookie, web.HeaderOrigin, "https://auth.example.com")
+	if status != web.StatusFound {
+		return status, nil
+	}
+	u, err := url.Parse(header.Get(web.HeaderLocation))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return status, u.Query()
+}
+
+func TestAuthorizationCode(t *testing.T) {
+	_, h := newTestServer()
+
+	status, q := approve(t, h, url.Values{"response_type": {"code"}, "client_id": {"spa"},
+		"redirect_uri": {"https://spa.example.com/b"}, "state": {"xyz"},
+		"code_challenge": {challenge("verifier")}, "code_challenge_method": {"S256"}})
+	if status != web.StatusFound || q.Get("state") != "xyz" || q.Get("code") == "" {
+		t.Fatalf("authorize status=%d, q=%v", status, q)
+	}
+	code := q.Get("code")
+
+	form := url.Values{"grant_type": {"authorization_code"}, "client_id": {"spa"}, "code": {code},
+		"redirect_uri": {"https://spa.example.com/b"}, "code_verifier": {"verifier"}}
+	status, header, m := post(h, "/oauth/token", form)
+	if status != web.StatusOK || m["access_token"] == nil || m["token_type"] != "Bearer" || m["scope"] != nil {
+		t.Fatalf("token status=%d, resp=%v", status, m)
+	}
+	if m["refresh_token"] != nil {
+		t.Errorf("refresh token issued to client without refresh_token grant")
+	}
+	if header.Get(web.HeaderCacheControl) != "no-store" {
+		t.Errorf("Cache-Control=%q", header.Get(web.HeaderCacheControl))
+	}
+
+	// Code reuse.
+	status, _, m = post(h, "/oauth/token", form)
+	if status != web.StatusBadRequest || m["error"] != ErrorInvalidGrant {
+		t.Errorf("reuse status=%d, resp=%v", status, m)
+	}
+
+	// Wrong verifier.
+	_, q = approve(t, h, url.Values{"response_type": {"code"}, "client_id": {"spa"},
+		"redirect_uri":   {"https://spa.example.com/b"},
+		"code_challenge": {challenge("verifier")}, "code_challenge_method": {"S256"}})
+	form.Set("code", q.Get("code"))
+	form.Set("code_verifier", "wrong")
+	status, _, m = post(h, "/oauth/token", form)
+	if status != web.StatusBadRequest || m["error"] != ErrorInvalidGrant {
+		t.Errorf("wrong verifier status=%d, resp=%v", status, m)
+	}
+}
+
+func TestAuthorizeErrors(t *testing.T) {
+	_, h := newTestServer()
+	tests := []struct {
+		params url.Values
+		status int
+		error  string
+	}{
+		{url.Values{"response_type": {"code"}, "client_id": {"unknown"}}, web.StatusBadRequest, ""},
+		{url.Values{"response_type": {"code"}, "client_id": {"app"}, "redirect_uri": {"https://evil.example.com/"}}, web.StatusBadRequest, ""},
+		// Redirect URI required when the client has more than one.
+		{url.Values{"response_type": {"code"}, "client_id": {"spa"}}, web.StatusBadRequest, ""},
+		{url.Values{"response_type": {"token"}, "client_id": {"app"}}, web.StatusFound, ErrorUnsupportedResponseType},
+		{url.Values{"response_type": {"code"}, "client_id": {"app"}, "scope": {"admin"}}, web.StatusFound, ErrorInvalidScope},
+		{url.Values{"response_type": {"code"}, "client_id": {"spa"}, "redirect_uri": {"https://spa.example.com/a"}}, web.StatusFound, ErrorInvalidRequest},
+		{url.Values{"response_type": {"code"}, "client_id": {"spa"}, "redirect_uri": {"https://spa.example.com/a"},
+			"code_challenge": {"abc"}, "code_challenge_method": {"plain"}}, web.StatusFound, ErrorInvalidRequest},
+		{url.Values{"response_type": {"code"}, "client_id": {"app"}, "consent": {"deny"}}, web.StatusFound, ErrorAccessDenied},
+		// Consent is only accepted with POST.
+		{url.Values{"response_type": {"code"}, "client_id": {"app"}, "consent": {"allow"}}, web.StatusForbidden, ""},
+		{url.Values{"response_type": {"code"}, "client_id": {"app"}, "scope": {"read"}}, web.StatusOK, ""},
+	}
+	for _, tt := range tests {
+		status, q := authorize(t, h, tt.params)
+		if status != tt.status || q.Get("error") != tt.error {
+			t.Errorf("%v: status=%d, error=%q, want %d, %q", tt.params, status, q.Get("error"), tt.status, tt.error)
+		}
+		if status == web.StatusFound && q.Get("x") != "1" && tt.params.Get("client_id") == "app" {
+			t.Errorf("%v: redirect URI query not preserved", tt.params)
+		}
+	}
+}
+
+func TestConsentForm(t *testing.T) {
+	_, h := newTestServer()
+	cookie, form := consentForm(t, h, url.Values{"response_type": {"code"}, "client_id": {"app"}, "scope": {"read"}, "state": {"s"}})
+	if form.Get("csrf") == "" {
+		t.Fatalf("form %v does not include CSRF token", form)
+	}
+	form.Set("consent", "allow")
+	token := form.Get("csrf")
+	tests := []struct {
+		token  string
+		kvs    []string
+		status int
+	}{
+		{token, []string{web.HeaderOrigin, "https://auth.example.com"}, web.StatusForbidden},
+		{token, []string{web.HeaderCookie, cookie, web.HeaderOrigin, "https://evil.example.com"}, web.StatusForbidden},
+		{"", []string{web.HeaderCookie, cookie, web.HeaderOrigin, "https://auth.example.com"}, web.StatusForbidden},
+		{token + "x", []string{web.HeaderCookie, cookie, web.HeaderOrigin, "https://auth.example.com"}, web.StatusForbidden},
+		{token, []string{web.HeaderCookie, cookie, web.HeaderOrigin, "https://auth.example.com"}, web.StatusFound},
+	}
+	for _, tt := range tests {
+		form.Set("csrf", tt.token)
+		status, header, _ := post(h, "/oauth/authorize", form, tt.kvs...)
+		if status != tt.status {
+			t.Errorf("token=%q, %v: status=%d, want %d", tt.token, tt.kvs, status, tt.status)
+			continue
+		}
+		if status != web.StatusFound {
+			continue
+		}
+		u, _ := url.Parse(header.Get(web.HeaderLocation))
+		if u.Query().Get("code") == "" || u.Query().Get("state") != "s" {
+			t.Errorf("location=%q", header.Get(web.HeaderLocation))
+		}
+	}
+}
+
+func TestRefreshAndIntrospect(t *testing.T) {
+	_, h := newTestServer()
+	_, q := approve(t, h, url.Values{"response_type": {"code"}, "client_id": {"app"}, "scope": {"read write"}})
+	auth := basic("app", "se:cret")
+	status, _, m := post(h, "/oauth/token", url.Values{"grant_type": {"authorization_code"}, "code": {q.Get("code")}},
+		web.HeaderAuthorization, auth)
+	if status != web.StatusOK || m["refresh_token"] == nil || m["scope"] != "read write" {
+		t.Fatalf("token status=%d, resp=%v", status, m)
+	}
+	access, _ := m["access_token"].(string)
+	refresh, _ := m["refresh_token"].(string)
+
+	_, _, m = post(h, "/oauth/introspect", url.Values{"token": {access}}, web.HeaderAuthorization, auth)
+	if m["active"] != true || m["sub"] != "gopher" || m["client_id"] != "app" || m["scope"] != "read write" {
+		t.Errorf("introspect=%v", m)
+	}
+
+	// Narrow the scope on refresh.
+	status, _, m = post(h, "/oauth/token", url.Values{"grant_type": {"refresh_token"}, "refresh_token": {refresh}, "scope": {"read"}},
+		web.HeaderAuthorization, auth)
+	if status != web.StatusOK || m["scope"] != "read" || m["refresh_token"] == refresh {
+		t.Fatalf("refresh status=%d, resp=%v", status, m)
+	}
+	newAccess, _ := m["access_token"].(string)
+
+	// Old refresh token is rotated out.
+	status, _, m = post(h, "/oauth/token", url.Values{"grant_type": {"refresh_token"}, "refresh_token": {refresh}},
+		web.HeaderAuthorization, auth)
+	if status != web.StatusBadRequest || m["error"] != ErrorInvalidGrant {
+		t.Errorf("rotated refresh status=%d, resp=%v", status, m)
+	}
+
+	status, _, _ = post(h, "/oauth/revoke", url.Values{"token": {newAccess}}, web.HeaderAuthorization, auth)
+	if status != web.StatusOK {
+		t.Errorf("revoke status=%d", status)
+	}
+	_, _, m = post(h, "/oauth/introspect", url.Values{"token": {newAccess}}, web.HeaderAuthorization, auth)
+	if m["active"] != false {
+		t.Errorf("introspect revoked=%v", m)
+	}
+}
+
+func TestConcurrentRefresh(t *testing.T) {
+	_, h := newTestServer()
+	_, q := approve(t, h, url.Values{"response_type": {"code"}, "client_id": {"app"}, "scope": {"read"}})
+	auth := basic("app", "se:cret")
+	_, _, m := post(h, "/oauth/token", url.Values{"grant_type": {"authorization_code"}, "code": {q.Get("code")}},
+		web.HeaderAuthorization, auth)
+	refresh, _ := m["refresh_token"].(string)
+	if refresh == "" {
+		t.Fatalf("token resp=%v", m)
+	}
+
+	const n = 10
+	statuses := make(chan int, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			status, _, _ := post(h, "/oauth/token", url.Values{"grant_type": {"refresh_token"}, "refresh_token": {refresh}},
+				web.HeaderAuthorization, auth)
+			statuses <- status
+		}()
+	}
+	wg.Wait()
+	close(statuses)
+	ok := 0
+	for status := range statuses {
+		if status == web.StatusOK {
+			ok++
+		}
+	}
+	if ok != 1 {
+		t.Errorf("%d of %d concurrent refreshes succeeded, want 1", ok, n)
+	}
+}
+
+func TestTokenErrors(t *testing.T) {
+	_, h := newTestServer()
+	tests := []struct {
+		params url.Values
+		auth   string
+		status int
+		error  string
+	}{
+		{url.Values{"grant_type": {"client_credentials"}}, "", web.StatusUnauthorized, ErrorInvalidClient},
+		{url.Values{"grant_type": {"client_credentials"}}, basic("app", "wrong"), web.StatusUnauthorized, ErrorInvalidClient},
+		{url.Values{"grant_type": {"client_credentials"}, "client_id": {"app"}, "client_secret": {"wrong"}}, "", web.StatusUnauthorized, ErrorInvalidClient},
+		{url.Values{"grant_type": {"password"}}, basic("app", "se:cret"), web.StatusBadRequest, ErrorUnsupportedGrantType},
+		{url.Values{"grant_type": {"client_credentials"}, "client_id": {"spa"}}, "", web.StatusBadRequest, ErrorUnauthorizedClient},
+		{url.Values{"grant_type": {"client_credentials"}, "scope": {"admin"}}, basic("app", "se:cret"), web.StatusBadRequest, ErrorInvalidScope},
+		{url.Values{"grant_type": {"authorization_code"}, "code": {"unknown"}}, basic("app", "se:cret"), web.StatusBadRequest, ErrorInvalidGrant},
+		{url.Values{"grant_type": {"client_credentials"}, "scope": {"read"}}, basic("app", "se:cret"), web.StatusOK, ""},
+		{url.Values{"grant_type": {"client_credentials"}, "client_id": {"app"}, "client_secret": {"se:cret"}}, "", web.StatusOK, ""},
+	}
+	for _, tt := range tests {
+		var kvs []string
+		if tt.auth != "" {
+			kvs = []string{web.HeaderAuthorization, tt.auth}
+		}
+		status, header, m := post(h, "/oauth/token", tt.params, kvs...)
+		if status != tt.status || (tt.error != "" && m["error"] != tt.error) {
+			t.Errorf("%v: status=%d, resp=%v, want %d, %s", tt.params, status, m, tt.status, tt.error)
+		}
+		if status == web.StatusUnauthorized && header.Get(web.HeaderWWWAuthenticate) == "" {
+			t.Errorf("%v: WWW-Authenticate missing", tt.params)
+		}
+		if status == web.StatusOK && m["refresh_token"] != nil {
+			t.Errorf("%v: refresh token issued for client credentials", tt.params)
+		}
+	}
+}
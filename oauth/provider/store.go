@@ -0,0 +1,267 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package provider
+
+import (
+	"container/heap"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrNotFound is returned by stores when the requested item does not exist.
+var ErrNotFound = errors.New("provider: not found")
+
+// Client is a registered client application.
+type Client struct {
+	ID string
+
+	// Secret is the client secret. Secret is "" for public clients. Public
+	// clients must use PKCE with the authorization code grant.
+	Secret string
+
+	// RedirectURIs is the list of allowed redirect URIs. The redirect_uri
+	// parameter must exactly match an entry in the list. If the list has one
+	// entry, then the redirect_uri parameter is optional.
+	RedirectURIs []string
+
+	// Scopes is the list of scopes the client is allowed to request.
+	Scopes []string
+
+	// GrantTypes is the list of allowed grant types: "authorization_code",
+	// "client_credentials" and "refresh_token".
+	GrantTypes []string
+}
+
+func contains(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientStore is the interface for looking up clients.
+type ClientStore interface {
+	// Client returns the client with the given ID or ErrNotFound.
+	Client(id string) (*Client, error)
+}
+
+// ClientMap is a ClientStore backed by a map from client ID to client.
+type ClientMap map[string]*Client
+
+// Client implements the ClientStore interface.
+func (m ClientMap) Client(id string) (*Client, error) {
+	c := m[id]
+	if c == nil {
+		return nil, ErrNotFound
+	}
+	return c, nil
+}
+
+// Grant is an authorization code grant.
+type Grant struct {
+	Code        string
+	ClientID    string
+	RedirectURI string
+	Scope       []string
+	Subject     string
+
+	// PKCE code challenge and method.
+	Challenge       string
+	ChallengeMethod string
+
+	Expires time.Time
+}
+
+// GrantStore is the interface for storing authorization code grants.
+type GrantStore interface {
+	// SaveGrant saves a grant.
+	SaveGrant(g *Grant) error
+
+	// TakeGrant deletes and returns the grant with the given code or returns
+	// ErrNotFound. A grant can be taken once only.
+	TakeGrant(code string) (*Grant, error)
+}
+
+// Token types stored in TokenInfo.Type.
+const (
+	AccessToken  = "access_token"
+	RefreshToken = "refresh_token"
+)
+
+// TokenInfo is information about an issued token.
+type TokenInfo struct {
+	Token    string
+	Type     string
+	ClientID string
+	Subject  string
+	Scope    []string
+	Expires  time.Time
+}
+
+// TokenStore is the interface for storing issued tokens.
+type TokenStore interface {
+	// SaveToken saves a token.
+	SaveToken(t *TokenInfo) error
+
+	// Token returns the information for the given token or ErrNotFound.
+	Token(token string) (*TokenInfo, error)
+
+	// TakeToken deletes and returns the information for the given token or
+	// returns ErrNotFound. A token can be taken once only.
+	TakeToken(token string) (*TokenInfo, error)
+
+	// DeleteToken deletes a token. Deleting a token that does not exist is not
+	// an error.
+	DeleteToken(token string) error
+}
+
+// MemoryStore is a GrantStore and TokenStore that stores grants and tokens
+// in memory. Expired grants and tokens are not returned and are removed in
+// expiration order when new items are saved.
+type MemoryStore struct {
+	mu     sync.Mutex
+	grants map[string]*Grant
+	tokens map[string]*TokenInfo
+	expiry expiryHeap
+}
+
+// expiryItem is a grant or token in the expiry heap. Exactly one of grant
+// and token is set.
+type expiryItem struct {
+	expires time.Time
+	grant   *Grant
+	token   *TokenInfo
+}
+
+// expiryHeap is a min-heap of items ordered by expiration time.
+type expiryHeap []expiryItem
+
+func (h expiryHeap) Len() int            { return len(h) }
+func (h expiryHeap) Less(i, j int) bool  { return h[i].expires.Before(h[j].expires) }
+func (h expiryHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *expiryHeap) Push(x interface{}) { *h = append(*h, x.(expiryItem)) }
+
+func (h *expiryHeap) Pop() interface{} {
+	old := *h
+	item := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return item
+}
+
+// NewMemoryStore returns a new memory store.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		grants: make(map[string]*Grant),
+		tokens: make(map[string]*TokenInfo),
+	}
+}
+
+// removeExpired pops expired items from the heap and removes them from the
+// maps. Items that were taken, deleted or replaced since they were pushed
+// are left alone. The caller must hold the lock.
+func (s *MemoryStore) removeExpired(now time.Time) {
+	for len(s.expiry) > 0 && now.After(s.expiry[0].expires) {
+		item := heap.Pop(&s.expiry).(expiryItem)
+		if item.grant != nil && s.grants[item.grant.Code] == item.grant {
+			delete(s.grants, item.grant.Code)
+		}
+		if item.token != nil && s.tokens[item.token.Token] == item.token {
+			delete(s.tokens, item.token.Token)
+		}
+	}
+}
+
+func (t *TokenInfo) expired(now time.Time) bool {
+	return !t.Expires.IsZero() && now.After(t.Expires)
+}
+
+// SaveGrant implements the GrantStore interface.
+func (s *MemoryStore) SaveGrant(g *Grant) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.removeExpired(time.Now())
+	s.grants[g.Code] = g
+	heap.Push(&s.expiry, expiryItem{expires: g.Expires, grant: g})
+	return nil
+}
+
+// TakeGrant implements the GrantStore interface.
+func (s *MemoryStore) TakeGrant(code string) (*Grant, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	g := s.grants[code]
+	if g == nil {
+		return nil, ErrNotFound
+	}
+	delete(s.grants, code)
+	if time.Now().After(g.Expires) {
+		return nil, ErrNotFound
+	}
+	return g, nil
+}
+
+// SaveToken implements the TokenStore interface. Tokens with a zero Expires
+// time do not expire.
+func (s *MemoryStore) SaveToken(t *TokenInfo) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.removeExpired(time.Now())
+	s.tokens[t.Token] = t
+	if !t.Expires.IsZero() {
+		heap.Push(&s.expiry, expiryItem{expires: t.Expires, token: t})
+	}
+	return nil
+}
+
+// Token implements the TokenStore interface.
+func (s *MemoryStore) Token(token string) (*TokenInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t := s.tokens[token]
+	if t == nil {
+		return nil, ErrNotFound
+	}
+	if t.expired(time.Now()) {
+		delete(s.tokens, token)
+		return nil, ErrNotFound
+	}
+	return t, nil
+}
+
+// TakeToken implements the TokenStore interface.
+func (s *MemoryStore) TakeToken(token string) (*TokenInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t := s.tokens[token]
+	if t == nil {
+		return nil, ErrNotFound
+	}
+	delete(s.tokens, token)
+	if t.expired(time.Now()) {
+		return nil, ErrNotFound
+	}
+	return t, nil
+}
+
+// DeleteToken implements the TokenStore interface.
+func (s *MemoryStore) DeleteToken(token string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.tokens, token)
+	return nil
+}
@@ -0,0 +1,80 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package provider
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMemoryStore(t *testing.T) {
+	s := NewMemoryStore()
+	s.SaveGrant(&Grant{Code: "c", Expires: time.Now().Add(time.Minute)})
+	if g, err := s.TakeGrant("c"); err != nil || g.Code != "c" {
+		t.Fatalf("TakeGrant = %v, %v", g, err)
+	}
+	if _, err := s.TakeGrant("c"); err != ErrNotFound {
+		t.Errorf("second TakeGrant returned %v", err)
+	}
+
+	s.SaveGrant(&Grant{Code: "expired", Expires: time.Now().Add(-time.Minute)})
+	if _, err := s.TakeGrant("expired"); err != ErrNotFound {
+		t.Errorf("expired grant returned")
+	}
+
+	s.SaveToken(&TokenInfo{Token: "expired", Expires: time.Now().Add(-time.Minute)})
+	if _, err := s.Token("expired"); err != ErrNotFound {
+		t.Errorf("expired token returned")
+	}
+	s.SaveToken(&TokenInfo{Token: "valid", Expires: time.Now().Add(time.Minute)})
+	s.SaveToken(&TokenInfo{Token: "forever"})
+	if _, err := s.Token("valid"); err != nil {
+		t.Errorf("Token(valid) returned %v", err)
+	}
+	if tok, err := s.TakeToken("valid"); err != nil || tok.Token != "valid" {
+		t.Fatalf("TakeToken = %v, %v", tok, err)
+	}
+	if _, err := s.TakeToken("valid"); err != ErrNotFound {
+		t.Errorf("second TakeToken returned %v", err)
+	}
+	s.SaveToken(&TokenInfo{Token: "valid", Expires: time.Now().Add(time.Minute)})
+	s.DeleteToken("valid")
+	if _, err := s.Token("valid"); err != ErrNotFound {
+		t.Errorf("deleted token found")
+	}
+	if _, err := s.Token("forever"); err != nil {
+		t.Errorf("Token(forever) returned %v", err)
+	}
+}
+
+func TestMemoryStoreRemovesExpired(t *testing.T) {
+	s := NewMemoryStore()
+	now := time.Now()
+	s.SaveGrant(&Grant{Code: "g", Expires: now.Add(-2 * time.Minute)})
+	s.SaveToken(&TokenInfo{Token: "t", Expires: now.Add(-time.Minute)})
+	s.SaveToken(&TokenInfo{Token: "valid", Expires: now.Add(time.Minute)})
+	// Save t again after the expired t was removed.
+	s.SaveToken(&TokenInfo{Token: "t"})
+	s.SaveToken(&TokenInfo{Token: "x"})
+	if _, ok := s.grants["g"]; ok {
+		t.Errorf("expired grant not removed")
+	}
+	if len(s.tokens) != 3 || len(s.expiry) != 1 {
+		t.Errorf("tokens=%d, expiry=%d, want 3, 1", len(s.tokens), len(s.expiry))
+	}
+	if _, err := s.Token("t"); err != nil {
+		t.Errorf("Token(t) returned %v", err)
+	}
+}
@@ -0,0 +1,403 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+// Package openapi generates OpenAPI 3 documents from the routes registered
+// with a web.Router.
+//
+// Path templates and path parameters are derived from the route patterns.
+// Summaries, descriptions, parameter descriptions and request and response
+// schemas are taken from the documentation attached to routes with
+// web.Router.Doc:
+//
+//  r.Register("/users/<id:[0-9]+>", "GET", serveUser).
+//      Doc("GET", &web.RouteDoc{
+//          Summary:  "Get a user",
+//          Params:   map[string]string{"id": "The user id"},
+//          Response: User{}})
+//  r.Register("/openapi.json", "GET", openapi.Handler(openapi.Info{Title: "Users", Version: "1"}, r))
+//
+// Request and response schemas are generated from the Go types of the
+// RouteDoc Request and Response values using the same rules as the
+// encoding/json package. Named struct types are placed in the document's
+// components section and referenced from the operations.
+//
+// Handlers registered with the method "*" are omitted from the document
+// because OpenAPI does not have a way to describe a wildcard method. Routes
+// with unnamed parameters or with parameters that can match '/' are omitted
+// because an OpenAPI path parameter is a single named path segment.
+package openapi
+
+import (
+	"encoding/json"
+	"github.com/garyburd/twister/web"
+	"reflect"
+	"regexp/syntax"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Version is the OpenAPI specification version used in generated documents.
+const Version = "3.0.3"
+
+// Info is the metadata about the API.
+type Info struct {
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+	Version     string `json:"version"`
+}
+
+// Document is an OpenAPI document.
+type Document struct {
+	OpenAPI    string              `json:"openapi"`
+	Info       Info                `json:"info"`
+	Paths      map[string]PathItem `json:"paths"`
+	Components *Components         `json:"components,omitempty"`
+}
+
+// Components holds reusable schemas.
+type Components struct {
+	Schemas map[string]*Schema `json:"schemas,omitempty"`
+}
+
+// PathItem maps lowercase method names to operations.
+type PathItem map[string]*Operation
+
+// Operation describes a single API operation on a path.
+type Operation struct {
+	OperationID string               `json:"operationId,omitempty"`
+	Summary     string               `json:"summary,omitempty"`
+	Description string               `json:"description,omitempty"`
+	Tags        []string             `json:"tags,omitempty"`
+	Parameters  []*Parameter         `json:"parameters,omitempty"`
+	RequestBody *RequestBody         `json:"requestBody,omitempty"`
+	Responses   map[string]*Response `json:"responses"`
+}
+
+// Parameter describes a single operation parameter.
+type Parameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required"`
+	Schema      *Schema `json:"schema"`
+}
+
+// RequestBody describes a request body.
+type RequestBody struct {
+	Required bool                  `json:"required"`
+	Content  map[string]*MediaType `json:"content"`
+}
+
+// Response describes a single response from an operation.
+type Response struct {
+	Description string                `json:"description"`
+	Content     map[string]*MediaType `json:"content,omitempty"`
+}
+
+// MediaType describes the schema for a media type.
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+// Schema is a subset of the OpenAPI schema object.
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Pattern              string             `json:"pattern,omitempty"`
+	Nullable             bool               `json:"nullable,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+}
+
+var methods = map[string]bool{
+	"GET": true, "PUT": true, "POST": true, "DELETE": true,
+	"OPTIONS": true, "HEAD": true, "PATCH": true, "TRACE": true,
+}
+
+// NewDocument returns a document describing the routes reported by router.
+func NewDocument(info Info, router web.RouteLister) *Document {
+	g := generator{schemas: make(map[string]*Schema), seen: make(map[reflect.Type]string), expanding: make(map[reflect.Type]bool)}
+	doc := &Document{OpenAPI: Version, Info: info, Paths: make(map[string]PathItem)}
+
+	for _, ri := range router.Routes() {
+		template, params := ri.PathTemplate()
+		if !describable(&ri, params) {
+			continue
+		}
+		for _, mi := range ri.Handlers {
+			if !methods[mi.Method] {
+				continue
+			}
+			op := &Operation{Responses: make(map[string]*Response)}
+			rd := mi.Doc
+			if rd == nil {
+				rd = &web.RouteDoc{}
+			}
+			if ri.Name != "" {
+				op.OperationID = ri.Name
+				if len(ri.Handlers) > 1 {
+					op.OperationID += "." + strings.ToLower(mi.Method)
+				}
+			}
+			op.Summary = rd.Summary
+			op.Description = rd.Description
+			op.Tags = rd.Tags
+			for _, p := range params {
+				op.Parameters = append(op.Parameters, &Parameter{
+					Name:        p.Name,
+					In:          "path",
+					Description: rd.Params[p.Name],
+					Required:    true,
+					Schema:      &Schema{Type: "string", Pattern: "^(?:" + p.Regexp + ")$"},
+				})
+			}
+			if rd.Request != nil {
+				op.RequestBody = &RequestBody{
+					Required: true,
+					Content:  map[string]*MediaType{"application/json": {g.schema(reflect.TypeOf(rd.Request))}},
+				}
+			}
+			resp := &Response{Description: web.StatusText(web.StatusOK)}
+			if rd.Response != nil {
+				resp.Content = map[string]*MediaType{"application/json": {g.schema(reflect.TypeOf(rd.Response))}}
+			}
+			op.Responses["200"] = resp
+
+			item := doc.Paths[template]
+			if item == nil {
+				item = make(PathItem)
+				doc.Paths[template] = item
+			}
+			if item[strings.ToLower(mi.Method)] == nil {
+				item[strings.ToLower(mi.Method)] = op
+			}
+		}
+	}
+
+	if len(g.schemas) > 0 {
+		doc.Components = &Components{Schemas: g.schemas}
+	}
+	return doc
+}
+
+// describable returns true if the route's parameters can be described as
+// OpenAPI path parameters. Unnamed parameters are not captured by the router
+// and are not included in ri.Params.
+func describable(ri *web.RouteInfo, params []web.RouteParam) bool {
+	if len(params) != len(ri.Params) {
+		return false
+	}
+	for _, p := range params {
+		if matchesSlash(p.Regexp) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchesSlash returns true if the regular expression can match a string
+// containing '/'. The result is conservative: an expression that cannot be
+// parsed is reported as matching '/'.
+func matchesSlash(expr string) bool {
+	re, err := syntax.Parse(expr, syntax.Perl)
+	if err != nil {
+		return true
+	}
+	return syntaxMatchesSlash(re)
+}
+
+func syntaxMatchesSlash(re *syntax.Regexp) bool {
+	switch re.Op {
+	case syntax.OpAnyChar, syntax.OpAnyCharNotNL:
+		return true
+	case syntax.OpLiteral:
+		for _, r := range re.Rune {
+			if r == '/' {
+				return true
+			}
+		}
+	case syntax.OpCharClass:
+		for i := 0; i+1 < len(re.Rune); i += 2 {
+			if re.Rune[i] <= '/' && '/' <= re.Rune[i+1] {
+				return true
+			}
+		}
+	}
+	for _, sub := range re.Sub {
+		if syntaxMatchesSlash(sub) {
+			return true
+		}
+	}
+	return false
+}
+
+// Handler returns a handler that responds with the JSON encoding of the
+// document for the router. The document is generated on each request so that
+// routes registered after the handler is created are included.
+func Handler(info Info, router web.RouteLister) web.Handler {
+	return web.HandlerFunc(func(req *web.Request) {
+		b, err := json.MarshalIndent(NewDocument(info, router), "", " ")
+		if err != nil {
+			req.Error(web.StatusInternalServerError, err)
+			return
+		}
+		req.Respond(web.StatusOK, web.HeaderContentType, "application/json; charset=utf-8").Write(b)
+	})
+}
+
+// generator generates schemas from Go types.
+type generator struct {
+	schemas map[string]*Schema
+	seen    map[reflect.Type]string
+
+	// expanding is the set of struct types with schemas in progress. An
+	// embedded struct that is already being expanded adds no properties.
+	expanding map[reflect.Type]bool
+}
+
+var (
+	timeType          = reflect.TypeOf(time.Time{})
+	jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+)
+
+func (g *generator) schema(t reflect.Type) *Schema {
+	if t.Kind() == reflect.Ptr {
+		s := g.schema(t.Elem())
+		if s.Ref == "" {
+			s.Nullable = true
+		}
+		return s
+	}
+
+	if t == timeType {
+		return &Schema{Type: "string", Format: "date-time"}
+	}
+
+	if t.Implements(jsonMarshalerType) {
+		// The encoding is not known.
+		return &Schema{}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Uint, reflect.Uint8, reflect.Uint16:
+		return &Schema{Type: "integer"}
+	case reflect.Int32, reflect.Uint32:
+		return &Schema{Type: "integer", Format: "int32"}
+	case reflect.Int64, reflect.Uint64:
+		return &Schema{Type: "integer", Format: "int64"}
+	case reflect.Float32:
+		return &Schema{Type: "number", Format: "float"}
+	case reflect.Float64:
+		return &Schema{Type: "number", Format: "double"}
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: "string", Format: "byte"}
+		}
+		return &Schema{Type: "array", Items: g.schema(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: g.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return g.structSchema(t)
+		}
+		name, ok := g.seen[t]
+		if !ok {
+			name = g.schemaName(t)
+			g.seen[t] = name
+			g.schemas[name] = g.structSchema(t)
+		}
+		return &Schema{Ref: "#/components/schemas/" + name}
+	}
+	return &Schema{}
+}
+
+// schemaName returns a unique component name for the named type t.
+func (g *generator) schemaName(t reflect.Type) string {
+	name := t.Name()
+	if _, found := g.schemas[name]; !found {
+		return name
+	}
+	qualified := strings.Replace(t.PkgPath(), "/", ".", -1) + "." + name
+	name = qualified
+	for n := 2; ; n++ {
+		if _, found := g.schemas[name]; !found {
+			return name
+		}
+		name = qualified + strconv.Itoa(n)
+	}
+}
+
+func (g *generator) structSchema(t reflect.Type) *Schema {
+	s := &Schema{Type: "object", Properties: make(map[string]*Schema)}
+	if g.expanding[t] {
+		return s
+	}
+	g.expanding[t] = true
+	defer delete(g.expanding, t)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" && !f.Anonymous {
+			continue
+		}
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name := f.Name
+		omitEmpty := false
+		if tag != "" {
+			parts := strings.Split(tag, ",")
+			if parts[0] != "" {
+				name = parts[0]
+			}
+			for _, opt := range parts[1:] {
+				if opt == "omitempty" {
+					omitEmpty = true
+				}
+			}
+		}
+		if f.Anonymous && tag == "" {
+			ft := f.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				embedded := g.structSchema(ft)
+				for k, v := range embedded.Properties {
+					if _, found := s.Properties[k]; !found {
+						s.Properties[k] = v
+					}
+				}
+				s.Required = append(s.Required, embedded.Required...)
+				continue
+			}
+		}
+		if f.PkgPath != "" {
+			continue
+		}
+		s.Properties[name] = g.schema(f.Type)
+		if !omitEmpty && f.Type.Kind() != reflect.Ptr {
+			s.Required = append(s.Required, name)
+		}
+	}
+	return s
+}
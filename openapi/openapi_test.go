@@ -0,0 +1,167 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package openapi
+
+import (
+	"encoding/json"
+	"github.com/garyburd/twister/web"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type testUser struct {
+	ID      int       `json:"id"`
+	Name    string    `json:"name"`
+	Email   string    `json:"email,omitempty"`
+	Created time.Time `json:"created"`
+	Friends []*testUser
+	secret  string
+}
+
+// testA and testB embed each other.
+type testA struct {
+	*testB
+	A int `json:"a"`
+}
+
+type testB struct {
+	*testA
+	B int `json:"b"`
+}
+
+func serveTest(req *web.Request) {}
+
+func TestDocument(t *testing.T) {
+	r := web.NewRouter()
+	r.Register("/users/<id:[0-9]+>", "GET", serveTest, "PUT", serveTest).
+		Name("user").
+		Doc("GET", &web.RouteDoc{
+			Summary:  "Get a user",
+			Params:   map[string]string{"id": "The user id"},
+			Response: testUser{}}).
+		Doc("PUT", &web.RouteDoc{Request: &testUser{}})
+	r.Register("/files/<:.*>", "GET", serveTest)
+	r.Register("/static/<path:.*>", "GET", serveTest)
+	r.Register("/tags/<tag>", "GET", serveTest)
+	r.Register("/colors/<color:red|green>", "GET", serveTest)
+	r.Register("/any", "*", serveTest)
+
+	doc := NewDocument(Info{Title: "Test", Version: "1"}, r)
+
+	if len(doc.Paths) != 3 {
+		t.Fatalf("paths=%v, want 3 paths", doc.Paths)
+	}
+
+	get := doc.Paths["/users/{id}"]["get"]
+	if get == nil {
+		t.Fatal("get operation not found")
+	}
+	if get.OperationID != "user.get" || get.Summary != "Get a user" {
+		t.Errorf("get=%+v", get)
+	}
+	if len(get.Parameters) != 1 {
+		t.Fatalf("parameters=%+v", get.Parameters)
+	}
+	p := get.Parameters[0]
+	if p.Name != "id" || p.In != "path" || !p.Required || p.Description != "The user id" || p.Schema.Pattern != "^(?:[0-9]+)$" {
+		t.Errorf("parameter=%+v, schema=%+v", p, p.Schema)
+	}
+	if ref := get.Responses["200"].Content["application/json"].Schema.Ref; ref != "#/components/schemas/testUser" {
+		t.Errorf("response ref=%q", ref)
+	}
+
+	put := doc.Paths["/users/{id}"]["put"]
+	if put == nil || put.RequestBody == nil {
+		t.Fatalf("put=%+v", put)
+	}
+
+	if _, ok := doc.Paths["/files/{_1}"]; ok {
+		t.Errorf("route with unnamed parameter included in document")
+	}
+	if _, ok := doc.Paths["/static/{path}"]; ok {
+		t.Errorf("route with parameter matching '/' included in document")
+	}
+	if tags := doc.Paths["/tags/{tag}"]["get"]; tags == nil || tags.Parameters[0].Schema.Pattern != "^(?:[^/]+)$" {
+		t.Errorf("tags=%+v", tags)
+	}
+	if colors := doc.Paths["/colors/{color}"]["get"]; colors == nil || colors.Parameters[0].Schema.Pattern != "^(?:red|green)$" {
+		t.Errorf("colors=%+v", colors)
+	}
+
+	s := doc.Components.Schemas["testUser"]
+	if s == nil {
+		t.Fatal("testUser schema not found")
+	}
+	if !reflect.DeepEqual(s.Required, []string{"id", "name", "created", "Friends"}) {
+		t.Errorf("required=%q", s.Required)
+	}
+	if s.Properties["created"].Format != "date-time" {
+		t.Errorf("created=%+v", s.Properties["created"])
+	}
+	if s.Properties["Friends"].Items.Ref != "#/components/schemas/testUser" {
+		t.Errorf("friends=%+v", s.Properties["Friends"])
+	}
+	if _, found := s.Properties["secret"]; found {
+		t.Error("unexported field in schema")
+	}
+}
+
+func TestEmbeddedCycle(t *testing.T) {
+	r := web.NewRouter()
+	r.Register("/a", "GET", serveTest).Doc("GET", &web.RouteDoc{Response: testA{}})
+	doc := NewDocument(Info{Title: "Test", Version: "1"}, r)
+	s := doc.Components.Schemas["testA"]
+	if s == nil || s.Properties["a"] == nil || s.Properties["b"] == nil {
+		t.Fatalf("testA schema=%+v", s)
+	}
+}
+
+func TestMatchesSlash(t *testing.T) {
+	for _, tt := range []struct {
+		expr string
+		want bool
+	}{
+		{"[^/]+", false},
+		{"[0-9]+", false},
+		{"[a-z]+(-[a-z]+)*", false},
+		{".*", true},
+		{"[a-z/]+", true},
+		{"[!-z]+", true},
+		{"a/b", true},
+		{"a|.+", true},
+		{"(", true},
+	} {
+		if got := matchesSlash(tt.expr); got != tt.want {
+			t.Errorf("matchesSlash(%q) = %v, want %v", tt.expr, got, tt.want)
+		}
+	}
+}
+
+func TestHandler(t *testing.T) {
+	r := web.NewRouter()
+	r.Register("/openapi.json", "GET", Handler(Info{Title: "Test", Version: "1"}, r))
+	status, _, body := web.RunHandler("/openapi.json", "GET", nil, nil, r)
+	if status != web.StatusOK {
+		t.Fatalf("status=%d", status)
+	}
+	var v map[string]interface{}
+	if err := json.Unmarshal(body, &v); err != nil {
+		t.Fatal(err)
+	}
+	if v["openapi"] != Version {
+		t.Errorf("openapi=%v", v["openapi"])
+	}
+}
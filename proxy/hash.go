@@ -0,0 +1,71 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+
+package proxy
+
+import (
+	"hash/fnv"
+	"sort"
+	"strconv"
+)
+
+// replicas is the number of points on the ring for each upstream.
+const replicas = 100
+
+// hashRing maps keys to upstreams using consistent hashing. When an upstream
+// is ejected, only the keys mapped to that upstream move to other upstreams.
+type hashRing struct {
+	hashes    []uint32
+	upstreams []*upstream
+}
+
+func hashKey(s string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(s))
+	return h.Sum32()
+}
+
+func newHashRing(upstreams []*upstream) hashRing {
+	var ring hashRing
+	points := make(map[uint32]*upstream)
+	for _, u := range upstreams {
+		for i := 0; i < replicas; i++ {
+			hash := hashKey(strconv.Itoa(i) + "-" + u.url.String())
+			if _, ok := points[hash]; !ok {
+				points[hash] = u
+				ring.hashes = append(ring.hashes, hash)
+			}
+		}
+	}
+	sort.Slice(ring.hashes, func(i, j int) bool { return ring.hashes[i] < ring.hashes[j] })
+	for _, hash := range ring.hashes {
+		ring.upstreams = append(ring.upstreams, points[hash])
+	}
+	return ring
+}
+
+// get returns the first healthy upstream at or after the hash of key on the
+// ring or nil if no upstream is healthy. The caller must hold Proxy.mu.
+func (ring hashRing) get(key string) *upstream {
+	n := len(ring.hashes)
+	hash := hashKey(key)
+	i := sort.Search(n, func(i int) bool { return ring.hashes[i] >= hash })
+	for j := 0; j < n; j++ {
+		if u := ring.upstreams[(i+j)%n]; u.healthy {
+			return u
+		}
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+
+package proxy
+
+import (
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+func (p *Proxy) healthCheckLoop() {
+	interval := p.options.HealthCheckInterval
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+	p.checkHealth()
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-t.C:
+			p.checkHealth()
+		}
+	}
+}
+
+// checkHealth checks all upstreams concurrently and updates the health state
+// of each upstream.
+func (p *Proxy) checkHealth() {
+	var wg sync.WaitGroup
+	for _, u := range p.upstreams {
+		wg.Add(1)
+		go func(u *upstream) {
+			defer wg.Done()
+			p.setHealth(u, p.check(u))
+		}(u)
+	}
+	wg.Wait()
+}
+
+// check returns true if the upstream responds to the health check request
+// with a 2xx or 3xx status.
+func (p *Proxy) check(u *upstream) bool {
+	timeout := p.options.HealthCheckTimeout
+	if timeout <= 0 {
+		timeout = 2 * time.Second
+	}
+	target := *u.url
+	target.Path = u.url.Path + p.options.HealthCheckPath
+	target.RawQuery = ""
+	client := &http.Client{
+		Transport: p.transport,
+		Timeout:   timeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	resp, err := client.Get(target.String())
+	if err != nil {
+		return false
+	}
+	// Read the body so that the connection is returned to the pool.
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 4096))
+	resp.Body.Close()
+	return resp.StatusCode >= 200 && resp.StatusCode < 400
+}
+
+// setHealth records the result of a check and ejects or restores the
+// upstream when the result crosses a threshold.
+func (p *Proxy) setHealth(u *upstream, ok bool) {
+	unhealthy := p.options.UnhealthyThreshold
+	if unhealthy <= 0 {
+		unhealthy = 2
+	}
+	healthy := p.options.HealthyThreshold
+	if healthy <= 0 {
+		healthy = 1
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if ok {
+		u.failures = 0
+		u.passes++
+		if !u.healthy && u.passes >= healthy {
+			u.healthy = true
+			log.Println("proxy: upstream", u.url, "restored")
+		}
+	} else {
+		u.passes = 0
+		u.failures++
+		if u.healthy && u.failures >= unhealthy {
+			u.healthy = false
+			log.Println("proxy: upstream", u.url, "ejected")
+		}
+	}
+}
@@ -0,0 +1,423 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+// Package proxy implements a reverse proxy handler.
+//
+// The proxy forwards requests to a set of upstream HTTP/1.1 servers over
+// pooled connections. Request and response bodies are streamed.
+//
+//  p, err := proxy.New(&proxy.Options{
+//      Upstreams:       []string{"http://10.0.0.1:8080", "http://10.0.0.2:8080"},
+//      Balancer:        proxy.LeastConnections,
+//      HealthCheckPath: "/healthz",
+//  })
+//  if err != nil {
+//      log.Fatal(err)
+//  }
+//  defer p.Close()
+//  router.Register("/api/<path:.*>", "*", p)
+package proxy
+
+import (
+	"context"
+	"errors"
+	"github.com/garyburd/twister/web"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Balancer specifies how the proxy selects an upstream server.
+type Balancer int
+
+const (
+	// RoundRobin selects the upstreams in turn.
+	RoundRobin Balancer = iota
+
+	// LeastConnections selects the upstream with the fewest active requests.
+	LeastConnections
+
+	// ConsistentHash selects the upstream by hashing the key returned from
+	// Options.HashKey. Requests with the same key are sent to the same
+	// upstream while the upstream is healthy.
+	ConsistentHash
+)
+
+// Options specifies the options for a proxy.
+type Options struct {
+	// Upstreams is the list of upstream base URLs such as
+	// "http://10.0.0.1:8080". The request path is appended to the path of
+	// the base URL.
+	Upstreams []string
+
+	Balancer Balancer
+
+	// HashKey returns the key for the ConsistentHash balancer. If nil, then
+	// the request's remote address is used.
+	HashKey func(req *web.Request) string
+
+	// PreserveHost sends the Host header from the request to the upstream.
+	// If false, then the host of the upstream URL is sent.
+	PreserveHost bool
+
+	// Transport is used for requests to the upstreams. If nil, then a
+	// transport with a pool of idle connections for each upstream is used.
+	Transport *http.Transport
+
+	// Timeout limits the time for a request to an upstream, including
+	// copying the response body to the client. If zero, then five minutes is
+	// used. If negative, then no limit is imposed. Use a negative timeout for
+	// long-lived streaming responses.
+	Timeout time.Duration
+
+	// HealthCheckPath is the path requested to check the health of an
+	// upstream. If "", then health checks are disabled and all upstreams are
+	// considered healthy. An upstream is healthy if it responds with a 2xx
+	// or 3xx status.
+	HealthCheckPath string
+
+	// HealthCheckInterval is the time between checks. If zero, then ten
+	// seconds is used.
+	HealthCheckInterval time.Duration
+
+	// HealthCheckTimeout is the timeout for a check. If zero, then two
+	// seconds is used.
+	HealthCheckTimeout time.Duration
+
+	// UnhealthyThreshold is the number of consecutive failed checks to eject
+	// an upstream. HealthyThreshold is the number of consecutive successful
+	// checks to restore an ejected upstream. If zero, then two and one are
+	// used.
+	UnhealthyThreshold int
+	HealthyThreshold   int
+}
+
+// upstream is an upstream server.
+type upstream struct {
+	url *url.URL
+
+	// Number of active requests. Accessed atomically.
+	active int64
+
+	// Health state. Accessed with Proxy.mu held.
+	healthy          bool
+	failures, passes int
+}
+
+// Proxy is a reverse proxy handler.
+type Proxy struct {
+	options   Options
+	upstreams []*upstream
+	transport *http.Transport
+	ring      hashRing
+	next      uint32
+
+	mu   sync.Mutex
+	done chan struct{}
+}
+
+var (
+	errNoUpstream      = errors.New("proxy: no healthy upstream")
+	errUpstream        = errors.New("proxy: upstream request failed")
+	errUpstreamTimeout = errors.New("proxy: upstream request timed out")
+)
+
+// New returns a new proxy. If health checks are enabled, then New starts a
+// goroutine to check the upstreams. Call Close to stop the health checks.
+func New(options *Options) (*Proxy, error) {
+	if len(options.Upstreams) == 0 {
+		return nil, errors.New("proxy: no upstreams")
+	}
+	p := &Proxy{options: *options, done: make(chan struct{})}
+	for _, s := range options.Upstreams {
+		u, err := url.Parse(s)
+		if err != nil {
+			return nil, err
+		}
+		if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, errors.New("proxy: bad upstream URL " + s)
+		}
+		u.Path = strings.TrimSuffix(u.Path, "/")
+		p.upstreams = append(p.upstreams, &upstream{url: u, healthy: true})
+	}
+	p.ring = newHashRing(p.upstreams)
+	p.transport = options.Transport
+	if p.transport == nil {
+		p.transport = &http.Transport{
+			DialContext: (&net.Dialer{
+				Timeout:   30 * time.Second,
+				KeepAlive: 30 * time.Second,
+			}).DialContext,
+			MaxIdleConnsPerHost:   32,
+			IdleConnTimeout:       90 * time.Second,
+			ResponseHeaderTimeout: 60 * time.Second,
+			DisableCompression:    true,
+		}
+	}
+	if p.options.HealthCheckPath != "" {
+		go p.healthCheckLoop()
+	}
+	return p, nil
+}
+
+// Close stops the health checks and closes idle connections.
+func (p *Proxy) Close() {
+	p.mu.Lock()
+	select {
+	case <-p.done:
+	default:
+		close(p.done)
+	}
+	p.mu.Unlock()
+	p.transport.CloseIdleConnections()
+}
+
+// Healthy returns the base URLs of the healthy upstreams.
+func (p *Proxy) Healthy() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var result []string
+	for _, u := range p.upstreams {
+		if u.healthy {
+			result = append(result, u.url.String())
+		}
+	}
+	return result
+}
+
+// healthy returns the healthy upstreams.
+func (p *Proxy) healthy() []*upstream {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	result := make([]*upstream, 0, len(p.upstreams))
+	for _, u := range p.upstreams {
+		if u.healthy {
+			result = append(result, u)
+		}
+	}
+	return result
+}
+
+// choose selects an upstream for the request or returns nil if no upstream
+// is healthy.
+func (p *Proxy) choose(req *web.Request) *upstream {
+	if p.options.Balancer == ConsistentHash {
+		key := stripPort(req.RemoteAddr)
+		if p.options.HashKey != nil {
+			key = p.options.HashKey(req)
+		}
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		return p.ring.get(key)
+	}
+	candidates := p.healthy()
+	if len(candidates) == 0 {
+		return nil
+	}
+	start := int(atomic.AddUint32(&p.next, 1) % uint32(len(candidates)))
+	if p.options.Balancer == RoundRobin {
+		return candidates[start]
+	}
+	best := candidates[start]
+	for i := 1; i < len(candidates); i++ {
+		u := candidates[(start+i)%len(candidates)]
+		if atomic.LoadInt64(&u.active) < atomic.LoadInt64(&best.active) {
+			best = u
+		}
+	}
+	return best
+}
+
+// hopHeaders are the hop-by-hop headers removed by the proxy.
+var hopHeaders = []string{
+	web.HeaderConnection,
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Proxy-Connection",
+	"Te",
+	"Trailer",
+	web.HeaderTransferEncoding,
+	web.HeaderUpgrade,
+}
+
+// removeHopHeaders removes the hop-by-hop headers and the headers named in
+// the Connection header from header.
+func removeHopHeaders(header web.Header) {
+	for _, name := range header.GetList(web.HeaderConnection) {
+		delete(header, web.HeaderName(name))
+	}
+	for _, name := range hopHeaders {
+		delete(header, name)
+	}
+}
+
+// stripPort returns the address without the port.
+func stripPort(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
+
+// forwardedNode formats an address for the Forwarded header.
+func forwardedNode(addr string) string {
+	if strings.Contains(addr, ":") {
+		return `"[` + addr + `]"`
+	}
+	return addr
+}
+
+// outgoingHeader returns the header for the upstream request.
+func outgoingHeader(req *web.Request) http.Header {
+	header := make(web.Header)
+	for k, v := range req.Header {
+		header[k] = append([]string(nil), v...)
+	}
+	removeHopHeaders(header)
+
+	clientIP := stripPort(req.RemoteAddr)
+	if prior := header.GetList(web.HeaderXForwardedFor); len(prior) > 0 {
+		header.Set(web.HeaderXForwardedFor, strings.Join(prior, ", ")+", "+clientIP)
+	} else {
+		header.Set(web.HeaderXForwardedFor, clientIP)
+	}
+	header.Set(web.HeaderXForwardedProto, req.URL.Scheme)
+	header.Set(web.HeaderXForwardedHost, req.URL.Host)
+
+	forwarded := "for=" + forwardedNode(clientIP)
+	if req.URL.Host != "" {
+		forwarded += ";host=" + web.QuoteHeaderValueOrToken(req.URL.Host)
+	}
+	if req.URL.Scheme != "" {
+		forwarded += ";proto=" + req.URL.Scheme
+	}
+	header.Add(web.HeaderForwarded, forwarded)
+	return http.Header(header)
+}
+
+// ServeWeb forwards the request to an upstream selected by the balancer.
+func (p *Proxy) ServeWeb(req *web.Request) {
+	u := p.choose(req)
+	if u == nil {
+		req.Error(web.StatusServiceUnavailable, errNoUpstream)
+		return
+	}
+	atomic.AddInt64(&u.active, 1)
+	defer atomic.AddInt64(&u.active, -1)
+
+	target := *u.url
+	target.Path = u.url.Path + req.URL.Path
+	target.RawPath = ""
+	target.RawQuery = req.URL.RawQuery
+
+	var body io.Reader
+	if req.ContentLength != 0 && req.Body != nil {
+		body = req.Body
+	}
+	// The upstream request is canceled when the timeout expires or when
+	// ServeWeb returns, including when writing to the client fails.
+	timeout := p.options.Timeout
+	if timeout == 0 {
+		timeout = 5 * time.Minute
+	}
+	var (
+		ctx    context.Context
+		cancel context.CancelFunc
+	)
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeout(context.Background(), timeout)
+	} else {
+		ctx, cancel = context.WithCancel(context.Background())
+	}
+	defer cancel()
+
+	outReq, err := http.NewRequestWithContext(ctx, req.Method, target.String(), body)
+	if err != nil {
+		req.Error(web.StatusBadGateway, err)
+		return
+	}
+	if body != nil {
+		outReq.ContentLength = int64(req.ContentLength)
+	}
+	outReq.Header = outgoingHeader(req)
+	if p.options.PreserveHost {
+		outReq.Host = req.URL.Host
+	}
+
+	resp, err := p.transport.RoundTrip(outReq)
+	if err != nil {
+		log.Println("proxy: upstream", u.url, "request failed:", err)
+		if ctx.Err() == context.DeadlineExceeded {
+			req.Error(web.StatusGatewayTimeout, errUpstreamTimeout)
+		} else {
+			req.Error(web.StatusBadGateway, errUpstream)
+		}
+		return
+	}
+	defer resp.Body.Close()
+
+	header := make(web.Header)
+	for k, v := range resp.Header {
+		header[web.HeaderName(k)] = v
+	}
+	removeHopHeaders(header)
+	if resp.ContentLength >= 0 {
+		header.Set(web.HeaderContentLength, strconv.FormatInt(resp.ContentLength, 10))
+	}
+	w := req.Respond(resp.StatusCode, flattenHeader(header)...)
+	copyBody(w, resp.Body, resp.ContentLength < 0)
+}
+
+// flattenHeader returns the header as a list of keys and values.
+func flattenHeader(header web.Header) []string {
+	var kvs []string
+	for k, values := range header {
+		for _, v := range values {
+			kvs = append(kvs, k, v)
+		}
+	}
+	return kvs
+}
+
+// copyBody copies the response body to w. If flush is true, then the
+// body is flushed after each read so that streaming responses are not
+// delayed. Copying stops at the first error reading from r or writing to w.
+func copyBody(w io.Writer, r io.Reader, flush bool) {
+	f, _ := w.(web.Flusher)
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			if _, werr := w.Write(buf[:n]); werr != nil {
+				return
+			}
+			if flush && f != nil {
+				if f.Flush() != nil {
+					return
+				}
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
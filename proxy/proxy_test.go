@@ -0,0 +1,363 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+
+package proxy
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"github.com/garyburd/twister/web"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// nameServer returns a test server that responds with name.
+func nameServer(name string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, name)
+	}))
+}
+
+func newTestProxy(t *testing.T, options *Options) *Proxy {
+	p, err := New(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestForward(t *testing.T) {
+	var got *http.Request
+	var gotBody string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p, _ := ioutil.ReadAll(r.Body)
+		got, gotBody = r, string(p)
+		w.Header().Set("Connection", "X-Hop")
+		w.Header().Set("X-Hop", "1")
+		w.Header().Set("X-Upstream", "1")
+		w.WriteHeader(201)
+		fmt.Fprint(w, "created")
+	}))
+	defer srv.Close()
+	p := newTestProxy(t, &Options{Upstreams: []string{srv.URL + "/base/"}})
+	defer p.Close()
+
+	reqHeader := web.NewHeader(
+		web.HeaderContentLength, "5",
+		web.HeaderConnection, "X-Secret",
+		"X-Secret", "s",
+		"Keep-Alive", "timeout=5",
+		web.HeaderXForwardedFor, "5.6.7.8",
+		"X-Custom", "c")
+	status, header, body := web.RunHandler("http://example.com/a/b?x=1", "POST", reqHeader, []byte("hello"), p)
+
+	if status != 201 || string(body) != "created" {
+		t.Errorf("status, body = %d, %q, want 201, %q", status, body, "created")
+	}
+	if header.Get("X-Upstream") != "1" {
+		t.Errorf("X-Upstream not forwarded")
+	}
+	if header.Get("X-Hop") != "" || header.Get(web.HeaderConnection) != "" {
+		t.Errorf("hop-by-hop response headers forwarded: %v", header)
+	}
+	if got == nil {
+		t.Fatal("upstream not called")
+	}
+	if got.Method != "POST" || got.URL.Path != "/base/a/b" || got.URL.RawQuery != "x=1" || gotBody != "hello" {
+		t.Errorf("upstream request = %s %s?%s %q", got.Method, got.URL.Path, got.URL.RawQuery, gotBody)
+	}
+	if got.Header.Get("X-Custom") != "c" {
+		t.Errorf("X-Custom not forwarded")
+	}
+	if got.Header.Get("X-Secret") != "" || got.Header.Get("Keep-Alive") != "" {
+		t.Errorf("hop-by-hop request headers forwarded: %v", got.Header)
+	}
+	for _, tt := range []struct {
+		name, want string
+	}{
+		{web.HeaderXForwardedFor, "5.6.7.8, 1.2.3.4"},
+		{web.HeaderXForwardedProto, "http"},
+		{web.HeaderXForwardedHost, "example.com"},
+		{web.HeaderForwarded, "for=1.2.3.4;host=example.com;proto=http"},
+	} {
+		if v := got.Header.Get(tt.name); v != tt.want {
+			t.Errorf("%s = %q, want %q", tt.name, v, tt.want)
+		}
+	}
+	if got.Host == "example.com" {
+		t.Errorf("Host = %q, want upstream host", got.Host)
+	}
+}
+
+func TestPreserveHost(t *testing.T) {
+	var host string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host = r.Host
+	}))
+	defer srv.Close()
+	p := newTestProxy(t, &Options{Upstreams: []string{srv.URL}, PreserveHost: true})
+	defer p.Close()
+	web.RunHandler("http://example.com/", "GET", nil, nil, p)
+	if host != "example.com" {
+		t.Errorf("Host = %q, want example.com", host)
+	}
+}
+
+func TestForwardedNode(t *testing.T) {
+	for _, tt := range []struct {
+		addr, want string
+	}{
+		{"1.2.3.4", "1.2.3.4"},
+		{"2001:db8::1", `"[2001:db8::1]"`},
+	} {
+		if got := forwardedNode(tt.addr); got != tt.want {
+			t.Errorf("forwardedNode(%q) = %q, want %q", tt.addr, got, tt.want)
+		}
+	}
+}
+
+func TestRoundRobin(t *testing.T) {
+	a, b := nameServer("a"), nameServer("b")
+	defer a.Close()
+	defer b.Close()
+	p := newTestProxy(t, &Options{Upstreams: []string{a.URL, b.URL}})
+	defer p.Close()
+	counts := make(map[string]int)
+	for i := 0; i < 6; i++ {
+		_, _, body := web.RunHandler("http://example.com/", "GET", nil, nil, p)
+		counts[string(body)]++
+	}
+	if counts["a"] != 3 || counts["b"] != 3 {
+		t.Errorf("counts = %v, want 3 each", counts)
+	}
+}
+
+func TestLeastConnections(t *testing.T) {
+	a, b := nameServer("a"), nameServer("b")
+	defer a.Close()
+	defer b.Close()
+	p := newTestProxy(t, &Options{Upstreams: []string{a.URL, b.URL}, Balancer: LeastConnections})
+	defer p.Close()
+	atomic.StoreInt64(&p.upstreams[0].active, 5)
+	for i := 0; i < 4; i++ {
+		if _, _, body := web.RunHandler("http://example.com/", "GET", nil, nil, p); string(body) != "b" {
+			t.Errorf("request %d sent to %q, want b", i, body)
+		}
+	}
+}
+
+func TestConsistentHash(t *testing.T) {
+	names := []string{"a", "b", "c"}
+	var upstreams []string
+	for _, name := range names {
+		srv := nameServer(name)
+		defer srv.Close()
+		upstreams = append(upstreams, srv.URL)
+	}
+	p := newTestProxy(t, &Options{
+		Upstreams: upstreams,
+		Balancer:  ConsistentHash,
+		HashKey:   func(req *web.Request) string { return req.Header.Get("X-Key") },
+	})
+	defer p.Close()
+
+	get := func(key string) string {
+		_, _, body := web.RunHandler("http://example.com/", "GET", web.NewHeader("X-Key", key), nil, p)
+		return string(body)
+	}
+
+	before := make(map[string]string)
+	counts := make(map[string]int)
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("key%d", i)
+		before[key] = get(key)
+		counts[before[key]]++
+		if again := get(key); again != before[key] {
+			t.Errorf("key %s sent to %s and %s", key, before[key], again)
+		}
+	}
+	for _, name := range names {
+		if counts[name] == 0 {
+			t.Errorf("no keys sent to %s, counts = %v", name, counts)
+		}
+	}
+
+	// Eject a; only keys from a should move.
+	p.setHealth(p.upstreams[0], false)
+	p.setHealth(p.upstreams[0], false)
+	for key, name := range before {
+		after := get(key)
+		if after == "a" {
+			t.Errorf("key %s sent to ejected upstream", key)
+		}
+		if name != "a" && after != name {
+			t.Errorf("key %s moved from %s to %s", key, name, after)
+		}
+	}
+}
+
+func TestHealthCheck(t *testing.T) {
+	var failing int32 = 1
+	a := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/health" && atomic.LoadInt32(&failing) != 0 {
+			w.WriteHeader(500)
+			return
+		}
+		fmt.Fprint(w, "a")
+	}))
+	defer a.Close()
+	b := nameServer("b")
+	defer b.Close()
+	p := newTestProxy(t, &Options{Upstreams: []string{a.URL, b.URL}})
+	defer p.Close()
+	// Enable checks without starting the background loop.
+	p.options.HealthCheckPath = "/health"
+
+	p.checkHealth()
+	if got := p.Healthy(); len(got) != 2 {
+		t.Fatalf("after one failure, healthy = %v, want both", got)
+	}
+	p.checkHealth()
+	if got := p.Healthy(); len(got) != 1 || got[0] != b.URL {
+		t.Fatalf("after two failures, healthy = %v, want [%s]", got, b.URL)
+	}
+	for i := 0; i < 4; i++ {
+		if _, _, body := web.RunHandler("http://example.com/", "GET", nil, nil, p); string(body) != "b" {
+			t.Errorf("request %d sent to %q, want b", i, body)
+		}
+	}
+
+	atomic.StoreInt32(&failing, 0)
+	p.checkHealth()
+	if got := p.Healthy(); len(got) != 2 {
+		t.Errorf("after recovery, healthy = %v, want both", got)
+	}
+}
+
+func TestNoUpstream(t *testing.T) {
+	a := nameServer("a")
+	defer a.Close()
+	p := newTestProxy(t, &Options{Upstreams: []string{a.URL}, UnhealthyThreshold: 1})
+	defer p.Close()
+	p.setHealth(p.upstreams[0], false)
+	if status, _, _ := web.RunHandler("http://example.com/", "GET", nil, nil, p); status != web.StatusServiceUnavailable {
+		t.Errorf("status = %d, want %d", status, web.StatusServiceUnavailable)
+	}
+}
+
+func TestBadGateway(t *testing.T) {
+	a := nameServer("a")
+	a.Close()
+	p := newTestProxy(t, &Options{Upstreams: []string{a.URL}})
+	defer p.Close()
+	if status, _, _ := web.RunHandler("http://example.com/", "GET", nil, nil, p); status != web.StatusBadGateway {
+		t.Errorf("status = %d, want %d", status, web.StatusBadGateway)
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer srv.Close()
+	p := newTestProxy(t, &Options{Upstreams: []string{srv.URL}, Timeout: 50 * time.Millisecond})
+	defer p.Close()
+	if status, _, _ := web.RunHandler("http://example.com/", "GET", nil, nil, p); status != web.StatusGatewayTimeout {
+		t.Errorf("status = %d, want %d", status, web.StatusGatewayTimeout)
+	}
+	if n := atomic.LoadInt64(&p.upstreams[0].active); n != 0 {
+		t.Errorf("active = %d, want 0", n)
+	}
+}
+
+// failingResponder is a responder with a writer that always fails.
+type failingResponder struct{}
+
+func (failingResponder) Respond(status int, header web.Header) io.Writer { return failingWriter{} }
+
+func (failingResponder) Hijack() (net.Conn, *bufio.Reader, error) {
+	return nil, nil, errors.New("hijack not supported")
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) { return 0, errors.New("client gone") }
+
+func TestClientWriteError(t *testing.T) {
+	canceled := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Stream until the proxy cancels the request.
+		for {
+			select {
+			case <-r.Context().Done():
+				close(canceled)
+				return
+			default:
+			}
+			fmt.Fprint(w, "data\n")
+			w.(http.Flusher).Flush()
+			time.Sleep(time.Millisecond)
+		}
+	}))
+	defer srv.Close()
+	p := newTestProxy(t, &Options{Upstreams: []string{srv.URL}, Timeout: -1})
+	defer p.Close()
+
+	u, _ := url.Parse("http://example.com/")
+	req, err := web.NewRequest("1.2.3.4", "GET", "/", web.ProtocolVersion11, u, make(web.Header))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Responder = failingResponder{}
+	done := make(chan struct{})
+	go func() {
+		p.ServeWeb(req)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("ServeWeb did not return after the client write failed")
+	}
+	select {
+	case <-canceled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("upstream request not canceled")
+	}
+	if n := atomic.LoadInt64(&p.upstreams[0].active); n != 0 {
+		t.Errorf("active = %d, want 0", n)
+	}
+}
+
+func TestNew(t *testing.T) {
+	for _, upstreams := range [][]string{
+		nil,
+		{"example.com:80"},
+		{"ftp://example.com/"},
+		{"http://"},
+	} {
+		if _, err := New(&Options{Upstreams: upstreams}); err == nil {
+			t.Errorf("New(%v) did not return error", upstreams)
+		}
+	}
+}
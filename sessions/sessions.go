@@ -0,0 +1,274 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+// Package sessions implements server-side sessions for Twister applications.
+//
+// The Handler middleware loads the session for a request from a Store and
+// saves the session when the application responds to the request:
+//
+//  store := sessions.NewMemoryStore(time.Hour)
+//  h := sessions.Handler(store, &sessions.Options{IdleTimeout: time.Hour}, router)
+//
+//  func login(req *web.Request) {
+//      s := sessions.Get(req)
+//      s.Rotate()
+//      s.Set("uid", uid)
+//      s.AddFlash("Welcome back!")
+//      req.Redirect("/", false)
+//  }
+//
+// Session values are encoded with encoding/gob. Types other than the basic
+// types must be registered with gob.Register.
+//
+// The session is saved from a web.FilterRespond filter. Changes made to the
+// session after the handler calls Respond are not saved. Handlers that do not
+// call Respond, for example handlers that hijack the connection, do not save
+// the session.
+package sessions
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"github.com/garyburd/twister/web"
+	"log"
+	"time"
+)
+
+// timeNow is replaced in tests.
+var timeNow = time.Now
+
+// Session holds the values for a session.
+type Session struct {
+	// ID is the session identifier or "" if the session has not been saved.
+	ID string
+
+	// Values stored in the session.
+	Values map[string]interface{}
+
+	// Created is the time the session was created.
+	Created time.Time
+
+	// Accessed is the time of the last request in the session.
+	Accessed time.Time
+
+	modified  bool
+	destroyed bool
+	rotatedID string
+}
+
+func newSession() *Session {
+	now := timeNow()
+	return &Session{Values: make(map[string]interface{}), Created: now, Accessed: now}
+}
+
+// Get returns the value for key or nil if the key is not found.
+func (s *Session) Get(key string) interface{} {
+	return s.Values[key]
+}
+
+// Set sets the value for key.
+func (s *Session) Set(key string, value interface{}) {
+	s.Values[key] = value
+	s.modified = true
+}
+
+// Delete deletes the value for key.
+func (s *Session) Delete(key string) {
+	if _, found := s.Values[key]; found {
+		delete(s.Values, key)
+		s.modified = true
+	}
+}
+
+// Rotate assigns a new identifier to the session when the session is saved
+// and deletes the session with the old identifier from the store. Call Rotate
+// when the privilege level of the session changes, for example on login, to
+// prevent session fixation.
+func (s *Session) Rotate() {
+	if s.ID != "" {
+		s.rotatedID = s.ID
+		s.ID = ""
+	}
+	s.modified = true
+}
+
+// Destroy deletes all values from the session, deletes the session from the
+// store and deletes the session cookie.
+func (s *Session) Destroy() {
+	s.Values = make(map[string]interface{})
+	s.destroyed = true
+}
+
+const flashKey = "_flash"
+
+// AddFlash adds a flash message to the session. Flash messages are deleted
+// from the session when read with Flashes.
+func (s *Session) AddFlash(value interface{}) {
+	flashes, _ := s.Values[flashKey].([]interface{})
+	s.Set(flashKey, append(flashes, value))
+}
+
+// Flashes returns the flash messages and deletes them from the session.
+func (s *Session) Flashes() []interface{} {
+	flashes, _ := s.Values[flashKey].([]interface{})
+	s.Delete(flashKey)
+	return flashes
+}
+
+// newID returns a new random session identifier.
+func newID() (string, error) {
+	p := make([]byte, 24)
+	if _, err := rand.Read(p); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(p), nil
+}
+
+// Options specifies the session cookie and expiration.
+type Options struct {
+	// Name of the session cookie. If empty, then "session" is used.
+	CookieName string
+
+	// Path and domain attributes of the session cookie. If Path is "", then
+	// "/" is used.
+	Path   string
+	Domain string
+
+	// Secure attribute of the session cookie.
+	Secure bool
+
+	// IdleTimeout is the time after the last request in the session that
+	// the session expires. If zero, then the session does not expire from
+	// inactivity.
+	IdleTimeout time.Duration
+
+	// MaxLifetime is the time after the session is created that the session
+	// expires. If zero, then the session does not have an absolute
+	// expiration and the session cookie is deleted when the browser exits.
+	MaxLifetime time.Duration
+}
+
+var defaultOptions Options
+
+func (o *Options) cookieName() string {
+	if o.CookieName == "" {
+		return "session"
+	}
+	return o.CookieName
+}
+
+func (o *Options) expired(s *Session, now time.Time) bool {
+	return (o.IdleTimeout > 0 && now.Sub(s.Accessed) > o.IdleTimeout) ||
+		(o.MaxLifetime > 0 && now.Sub(s.Created) > o.MaxLifetime)
+}
+
+func (o *Options) cookie(value string) *web.Cookie {
+	c := web.NewCookie(o.cookieName(), value).Domain(o.Domain).Secure(o.Secure)
+	if o.Path != "" {
+		c.Path(o.Path)
+	}
+	return c
+}
+
+const envKey = "twister.sessions.session"
+
+// Get returns the session for the request. The function panics if the request
+// was not dispatched through Handler.
+func Get(req *web.Request) *Session {
+	s, ok := req.Env[envKey].(*Session)
+	if !ok {
+		panic("twister: sessions.Handler not installed")
+	}
+	return s
+}
+
+// Handler returns a handler that loads the session from store, stores the
+// session in the request Env and calls h. The session is saved to the store
+// when the application responds to the request. New sessions are not saved
+// until a value is set.
+func Handler(store Store, options *Options, h web.Handler) web.Handler {
+	if options == nil {
+		options = &defaultOptions
+	}
+	return &handler{store: store, options: options, h: h}
+}
+
+type handler struct {
+	store   Store
+	options *Options
+	h       web.Handler
+}
+
+func (h *handler) ServeWeb(req *web.Request) {
+	now := timeNow()
+	var s *Session
+	if cookie := req.Cookie.Get(h.options.cookieName()); cookie != "" {
+		var err error
+		s, err = h.store.Load(cookie)
+		if err != nil && err != ErrNotFound {
+			log.Println("ERROR", req.URL, "sessions: load", err)
+		}
+		if s != nil && h.options.expired(s, now) {
+			if err := h.store.Delete(s.ID); err != nil {
+				log.Println("ERROR", req.URL, "sessions: delete", err)
+			}
+			s = nil
+		}
+	}
+	if s == nil {
+		s = newSession()
+	} else {
+		s.Accessed = now
+	}
+	req.Env[envKey] = s
+	web.FilterRespond(req, func(status int, header web.Header) (int, web.Header) {
+		h.save(req, s, header)
+		return status, header
+	})
+	h.h.ServeWeb(req)
+}
+
+func (h *handler) save(req *web.Request, s *Session, header web.Header) {
+	if s.rotatedID != "" {
+		if err := h.store.Delete(s.rotatedID); err != nil {
+			log.Println("ERROR", req.URL, "sessions: delete", err)
+		}
+		s.rotatedID = ""
+	}
+	if s.destroyed {
+		if s.ID != "" {
+			if err := h.store.Delete(s.ID); err != nil {
+				log.Println("ERROR", req.URL, "sessions: delete", err)
+			}
+		}
+		if req.Cookie.Get(h.options.cookieName()) != "" {
+			header.Add(web.HeaderSetCookie, h.options.cookie("").Delete().String())
+		}
+		return
+	}
+	if s.ID == "" && !s.modified {
+		// Do not save new sessions without values.
+		return
+	}
+	value, err := h.store.Save(s)
+	if err != nil {
+		log.Println("ERROR", req.URL, "sessions: save", err)
+		return
+	}
+	c := h.options.cookie(value)
+	if h.options.MaxLifetime > 0 {
+		c.MaxAge(s.Created.Add(h.options.MaxLifetime).Sub(timeNow()))
+	}
+	header.Add(web.HeaderSetCookie, c.String())
+}
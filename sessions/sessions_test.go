@@ -0,0 +1,206 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package sessions
+
+import (
+	"fmt"
+	"github.com/garyburd/twister/web"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testClient runs requests through a handler and keeps the session cookie.
+type testClient struct {
+	cookie string
+}
+
+func (c *testClient) run(t *testing.T, path string, h web.Handler) string {
+	header := web.NewHeader()
+	if c.cookie != "" {
+		header.Set(web.HeaderCookie, "session="+c.cookie)
+	}
+	_, respHeader, body := web.RunHandler("http://example.com"+path, "GET", header, nil, h)
+	for _, s := range respHeader[web.HeaderSetCookie] {
+		if !strings.HasPrefix(s, "session=") {
+			t.Fatalf("unexpected cookie %q", s)
+		}
+		value := s[len("session="):strings.Index(s, ";")]
+		if strings.Contains(s, "max-age=-") {
+			value = ""
+		}
+		c.cookie = value
+	}
+	return string(body)
+}
+
+func testHandler(store Store, options *Options) web.Handler {
+	return Handler(store, options, web.HandlerFunc(func(req *web.Request) {
+		s := Get(req)
+		switch req.URL.Path {
+		case "/set":
+			s.Set("v", req.Param.Get("v"))
+		case "/login":
+			s.Rotate()
+		case "/flash":
+			s.AddFlash("hello")
+			req.Respond(web.StatusOK)
+			return
+		case "/logout":
+			s.Destroy()
+		}
+		flashes := s.Flashes()
+		w := req.Respond(web.StatusOK)
+		fmt.Fprintf(w, "v=%v flashes=%v", s.Get("v"), flashes)
+	}))
+}
+
+func testStore(t *testing.T, store Store) {
+	defer func() { timeNow = time.Now }()
+	now := time.Now()
+	timeNow = func() time.Time { return now }
+
+	h := testHandler(store, &Options{IdleTimeout: time.Hour, MaxLifetime: 24 * time.Hour})
+	var c testClient
+
+	if body := c.run(t, "/", h); body != "v=<nil> flashes=[]" || c.cookie != "" {
+		t.Errorf("empty session: body=%q, cookie=%q", body, c.cookie)
+	}
+	c.run(t, "/set?v=1", h)
+	if c.cookie == "" {
+		t.Fatal("session not saved")
+	}
+	if body := c.run(t, "/", h); body != "v=1 flashes=[]" {
+		t.Errorf("load: body=%q", body)
+	}
+
+	c.run(t, "/flash", h)
+	if body := c.run(t, "/", h); body != "v=1 flashes=[hello]" {
+		t.Errorf("flash: body=%q", body)
+	}
+	if body := c.run(t, "/", h); body != "v=1 flashes=[]" {
+		t.Errorf("flash read twice: body=%q", body)
+	}
+
+	before := c.cookie
+	c.run(t, "/login", h)
+	if c.cookie == before {
+		t.Error("cookie not changed after rotate")
+	}
+	if body := c.run(t, "/", h); body != "v=1 flashes=[]" {
+		t.Errorf("rotate: body=%q", body)
+	}
+	if _, isCookieStore := store.(*CookieStore); !isCookieStore {
+		if _, err := store.Load(before); err != ErrNotFound {
+			t.Errorf("old session after rotate: err=%v", err)
+		}
+	}
+
+	now = now.Add(59 * time.Minute)
+	if body := c.run(t, "/", h); body != "v=1 flashes=[]" {
+		t.Errorf("before idle timeout: body=%q", body)
+	}
+	now = now.Add(61 * time.Minute)
+	if body := c.run(t, "/", h); body != "v=<nil> flashes=[]" {
+		t.Errorf("after idle timeout: body=%q", body)
+	}
+
+	c.run(t, "/set?v=2", h)
+	for i := 0; i < 25; i++ {
+		now = now.Add(time.Hour - time.Minute)
+		c.run(t, "/", h)
+	}
+	if body := c.run(t, "/", h); body != "v=<nil> flashes=[]" {
+		t.Errorf("after max lifetime: body=%q", body)
+	}
+
+	c.run(t, "/set?v=3", h)
+	c.run(t, "/logout", h)
+	if c.cookie != "" {
+		t.Errorf("cookie %q not deleted after logout", c.cookie)
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	testStore(t, NewMemoryStore(time.Hour))
+}
+
+func TestMemoryStoreExpires(t *testing.T) {
+	defer func() { timeNow = time.Now }()
+	now := time.Now()
+	timeNow = func() time.Time { return now }
+
+	ms := NewMemoryStore(time.Hour)
+	save := func() string {
+		id, err := ms.Save(newSession())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+	a := save()
+	now = now.Add(50 * time.Minute)
+	b := save()
+	now = now.Add(11 * time.Minute)
+	if _, err := ms.Load(a); err != ErrNotFound {
+		t.Errorf("load expired session: err=%v", err)
+	}
+	if _, err := ms.Load(b); err != nil {
+		t.Errorf("load session: err=%v", err)
+	}
+	now = now.Add(time.Hour)
+	save()
+	if len(ms.m) != 1 || ms.order.Len() != 1 {
+		t.Errorf("len(m)=%d, order.Len()=%d, want 1", len(ms.m), ms.order.Len())
+	}
+}
+
+func TestCookieStore(t *testing.T) {
+	testStore(t, NewCookieStore("secret", 30*24*time.Hour))
+
+	s := newSession()
+	s.Set("v", "1")
+	cookie, err := NewCookieStore("secret", time.Hour).Save(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewCookieStore("other", time.Hour).Load(cookie); err != ErrNotFound {
+		t.Errorf("load with wrong secret: err=%v", err)
+	}
+}
+
+func TestSecureCookieStore(t *testing.T) {
+	codec, err := web.NewSecureCookie(30*24*time.Hour, web.CookieKey{ID: 1, Secret: []byte("0123456789abcdef")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	testStore(t, NewSecureCookieStore(codec))
+}
+
+func TestFileStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sessions")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	store := NewFileStore(dir)
+	testStore(t, store)
+
+	if _, err := store.Load("../etc/passwd"); err != ErrNotFound {
+		t.Errorf("load with bad id: err=%v", err)
+	}
+}
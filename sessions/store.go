@@ -0,0 +1,361 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package sessions
+
+import (
+	"bytes"
+	"container/list"
+	"encoding/base64"
+	"encoding/gob"
+	"errors"
+	"github.com/garyburd/twister/web"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrNotFound is returned from Store.Load when the session does not exist.
+var ErrNotFound = errors.New("sessions: not found")
+
+// Store is the interface for session storage.
+type Store interface {
+	// Load returns the session for the session cookie value.
+	Load(cookie string) (*Session, error)
+
+	// Save saves the session and returns the session cookie value. If
+	// s.ID is "", then Save assigns a new identifier to the session.
+	Save(s *Session) (cookie string, err error)
+
+	// Delete deletes the session with the given identifier.
+	Delete(id string) error
+}
+
+func init() {
+	// Flash messages are stored as a []interface{}.
+	gob.Register([]interface{}{})
+}
+
+// record is the gob encoding of a session.
+type record struct {
+	ID       string
+	Values   map[string]interface{}
+	Created  time.Time
+	Accessed time.Time
+}
+
+func encode(s *Session) ([]byte, error) {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(&record{s.ID, s.Values, s.Created, s.Accessed})
+	return buf.Bytes(), err
+}
+
+func decode(p []byte) (*Session, error) {
+	var r record
+	if err := gob.NewDecoder(bytes.NewReader(p)).Decode(&r); err != nil {
+		return nil, err
+	}
+	if r.Values == nil {
+		r.Values = make(map[string]interface{})
+	}
+	return &Session{ID: r.ID, Values: r.Values, Created: r.Created, Accessed: r.Accessed}, nil
+}
+
+// assignID sets a new identifier on the session if the session does not have
+// an identifier.
+func assignID(s *Session) error {
+	if s.ID != "" {
+		return nil
+	}
+	id, err := newID()
+	if err != nil {
+		return err
+	}
+	s.ID = id
+	return nil
+}
+
+// MemoryStore stores sessions in memory. Sessions are lost when the process
+// exits.
+type MemoryStore struct {
+	maxAge time.Duration
+	mu     sync.Mutex
+	m      map[string]*list.Element
+
+	// Entries ordered by the time saved, oldest first.
+	order *list.List
+}
+
+type memoryEntry struct {
+	id    string
+	data  []byte
+	saved time.Time
+}
+
+// NewMemoryStore returns a new memory store. Sessions not saved for maxAge
+// are removed from the store. If maxAge is zero, then sessions are not
+// removed until deleted.
+func NewMemoryStore(maxAge time.Duration) *MemoryStore {
+	return &MemoryStore{maxAge: maxAge, m: make(map[string]*list.Element), order: list.New()}
+}
+
+func (ms *MemoryStore) expired(e *memoryEntry, now time.Time) bool {
+	return ms.maxAge > 0 && now.Sub(e.saved) > ms.maxAge
+}
+
+// removeExpired removes the expired sessions from the front of the save
+// order. Each session is removed at most once, so the cost is amortized over
+// the calls to Save. The caller must hold the lock.
+func (ms *MemoryStore) removeExpired(now time.Time) {
+	for {
+		elem := ms.order.Front()
+		if elem == nil || !ms.expired(elem.Value.(*memoryEntry), now) {
+			return
+		}
+		ms.order.Remove(elem)
+		delete(ms.m, elem.Value.(*memoryEntry).id)
+	}
+}
+
+// Load implements the Store interface.
+func (ms *MemoryStore) Load(cookie string) (*Session, error) {
+	ms.mu.Lock()
+	elem, found := ms.m[cookie]
+	if found && ms.expired(elem.Value.(*memoryEntry), timeNow()) {
+		ms.order.Remove(elem)
+		delete(ms.m, cookie)
+		found = false
+	}
+	ms.mu.Unlock()
+	if !found {
+		return nil, ErrNotFound
+	}
+	return decode(elem.Value.(*memoryEntry).data)
+}
+
+// Save implements the Store interface.
+func (ms *MemoryStore) Save(s *Session) (string, error) {
+	if err := assignID(s); err != nil {
+		return "", err
+	}
+	p, err := encode(s)
+	if err != nil {
+		return "", err
+	}
+	now := timeNow()
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	if elem, found := ms.m[s.ID]; found {
+		ms.order.Remove(elem)
+	}
+	ms.m[s.ID] = ms.order.PushBack(&memoryEntry{s.ID, p, now})
+	ms.removeExpired(now)
+	return s.ID, nil
+}
+
+// Delete implements the Store interface.
+func (ms *MemoryStore) Delete(id string) error {
+	ms.mu.Lock()
+	if elem, found := ms.m[id]; found {
+		ms.order.Remove(elem)
+		delete(ms.m, id)
+	}
+	ms.mu.Unlock()
+	return nil
+}
+
+// CookieStore stores sessions in the session cookie. The cookie value is
+// signed with web.SignValue or encrypted with a web.SecureCookie. Values
+// signed with web.SignValue are not encrypted and can be read by the client.
+//
+// Because the session is stored in the cookie, deleting a session does not
+// invalidate copies of the cookie held by the client before the session was
+// deleted.
+type CookieStore struct {
+	secret string
+	maxAge time.Duration
+	codec  *web.SecureCookie
+}
+
+// maxCookieSize is the maximum size of a cookie value supported by browsers.
+const maxCookieSize = 4000
+
+var errCookieTooLarge = errors.New("sessions: encoded session exceeds cookie size limit")
+
+// NewCookieStore returns a new cookie store. The cookie value is signed with
+// secret and expires after maxAge.
+func NewCookieStore(secret string, maxAge time.Duration) *CookieStore {
+	return &CookieStore{secret: secret, maxAge: maxAge}
+}
+
+// NewSecureCookieStore returns a new cookie store that encrypts the cookie
+// value with codec.
+func NewSecureCookieStore(codec *web.SecureCookie) *CookieStore {
+	return &CookieStore{codec: codec}
+}
+
+func (cs *CookieStore) decode(cookie string) (string, error) {
+	if cs.codec != nil {
+		return cs.codec.Decode("session", cookie)
+	}
+	return web.VerifyValue(cs.secret, "session", cookie)
+}
+
+func (cs *CookieStore) encode(value string) (string, error) {
+	if cs.codec != nil {
+		return cs.codec.Encode("session", value)
+	}
+	return web.SignValue(cs.secret, "session", cs.maxAge, value), nil
+}
+
+// Load implements the Store interface.
+func (cs *CookieStore) Load(cookie string) (*Session, error) {
+	value, err := cs.decode(cookie)
+	if err != nil {
+		return nil, ErrNotFound
+	}
+	p, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, ErrNotFound
+	}
+	return decode(p)
+}
+
+// Save implements the Store interface.
+func (cs *CookieStore) Save(s *Session) (string, error) {
+	if err := assignID(s); err != nil {
+		return "", err
+	}
+	p, err := encode(s)
+	if err != nil {
+		return "", err
+	}
+	cookie, err := cs.encode(base64.RawURLEncoding.EncodeToString(p))
+	if err != nil {
+		return "", err
+	}
+	if len(cookie) > maxCookieSize {
+		return "", errCookieTooLarge
+	}
+	return cookie, nil
+}
+
+// Delete implements the Store interface.
+func (cs *CookieStore) Delete(id string) error {
+	return nil
+}
+
+// FileStore stores sessions in files in a directory.
+type FileStore struct {
+	dir string
+}
+
+// NewFileStore returns a new file store for the directory dir. The directory
+// must exist.
+func NewFileStore(dir string) *FileStore {
+	return &FileStore{dir: dir}
+}
+
+const filePrefix = "session_"
+
+// filename returns the file name for a session identifier. An error is
+// returned if the identifier contains characters not in a generated
+// identifier.
+func (fs *FileStore) filename(id string) (string, error) {
+	if id == "" || strings.IndexFunc(id, func(r rune) bool {
+		return !('a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || '0' <= r && r <= '9' || r == '-' || r == '_')
+	}) >= 0 {
+		return "", ErrNotFound
+	}
+	return filepath.Join(fs.dir, filePrefix+id), nil
+}
+
+// Load implements the Store interface.
+func (fs *FileStore) Load(cookie string) (*Session, error) {
+	filename, err := fs.filename(cookie)
+	if err != nil {
+		return nil, err
+	}
+	p, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	return decode(p)
+}
+
+// Save implements the Store interface.
+func (fs *FileStore) Save(s *Session) (string, error) {
+	if err := assignID(s); err != nil {
+		return "", err
+	}
+	filename, err := fs.filename(s.ID)
+	if err != nil {
+		return "", err
+	}
+	p, err := encode(s)
+	if err != nil {
+		return "", err
+	}
+	f, err := ioutil.TempFile(fs.dir, "tmp_")
+	if err != nil {
+		return "", err
+	}
+	_, err = f.Write(p)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	if err := os.Rename(f.Name(), filename); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return s.ID, nil
+}
+
+// Delete implements the Store interface.
+func (fs *FileStore) Delete(id string) error {
+	filename, err := fs.filename(id)
+	if err != nil {
+		return nil
+	}
+	err = os.Remove(filename)
+	if os.IsNotExist(err) {
+		err = nil
+	}
+	return err
+}
+
+// Cleanup deletes sessions that have not been saved for maxAge. Applications
+// should call Cleanup periodically.
+func (fs *FileStore) Cleanup(maxAge time.Duration) error {
+	infos, err := ioutil.ReadDir(fs.dir)
+	if err != nil {
+		return err
+	}
+	now := timeNow()
+	for _, info := range infos {
+		if strings.HasPrefix(info.Name(), filePrefix) && now.Sub(info.ModTime()) > maxAge {
+			os.Remove(filepath.Join(fs.dir, info.Name()))
+		}
+	}
+	return nil
+}
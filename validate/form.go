@@ -0,0 +1,74 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package validate
+
+import (
+	"github.com/garyburd/twister/web"
+)
+
+// RespondProblem responds to the request with HTTP status 422 and an RFC 7807
+// problem details object. The object's "errors" member maps field names to
+// error messages.
+func RespondProblem(req *web.Request, errs web.FieldErrors) {
+	p := web.NewProblem(web.StatusUnprocessableEntity, "The request has invalid fields.")
+	p.Extensions = map[string]interface{}{"errors": errs}
+	web.RespondProblem(req, p)
+}
+
+// Form holds request parameters and errors for redisplaying a form in an
+// HTML template. A template can display the original value and error for a
+// field with:
+//
+//  <input name="email" value="{{.Form.Value "email"}}">
+//  {{with .Form.Error "email"}}<span class="error">{{.}}</span>{{end}}
+type Form struct {
+	// Values submitted with the request.
+	Values web.Values
+
+	// Errors for the submitted values.
+	Errors web.FieldErrors
+}
+
+// NewForm returns a form for the request parameters and the error returned by
+// Bind or Struct. If err is not a web.FieldErrors, then the form does not
+// have field errors.
+func NewForm(req *web.Request, err error) *Form {
+	errs, _ := err.(web.FieldErrors)
+	if errs == nil {
+		errs = make(web.FieldErrors)
+	}
+	return &Form{Values: req.Param, Errors: errs}
+}
+
+// Value returns the first submitted value for the named field.
+func (f *Form) Value(name string) string {
+	return f.Values.Get(name)
+}
+
+// Error returns the first error message for the named field or "" if the
+// field does not have an error.
+func (f *Form) Error(name string) string {
+	return f.Errors.Get(name)
+}
+
+// HasError returns true if the named field has an error.
+func (f *Form) HasError(name string) bool {
+	return len(f.Errors[name]) > 0
+}
+
+// Valid returns true if the form does not have errors.
+func (f *Form) Valid() bool {
+	return len(f.Errors) == 0
+}
@@ -0,0 +1,474 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+// Package validate implements declarative validation for structs filled by
+// web.Bind.
+//
+// Rules are specified in the "validate" struct field tag as a comma separated
+// list:
+//
+//  required      the value must not be the zero value
+//  min=n         minimum length of a string or slice or minimum number
+//  max=n         maximum length of a string or slice or maximum number
+//  len=n         exact length of a string or slice
+//  email         the string must be an email address
+//  oneof=a b c   the value must be one of the space separated values
+//  eqfield=F     the value must equal the value of field F
+//  nefield=F     the value must not equal the value of field F
+//
+// A regular expression that a string must match is specified with the
+// separate "pattern" tag:
+//
+//  Code string `form:"code" validate:"required" pattern:"^[A-Z]{3}$"`
+//
+// Rules other than required are not checked for zero values. Nested structs
+// and structs in slices are validated. Rules involving more than one field
+// can be implemented with the Checker interface.
+//
+// The tags for a struct type and the struct types nested in the type are
+// parsed and checked the first time that the type is validated. Struct and
+// Bind panic if a tag has an unknown rule or a rule that does not apply to the
+// field's type, independent of the field values.
+//
+// Errors are reported in a web.FieldErrors using the names from the form,
+// query, cookie and url tags used by web.Bind so that errors can be matched
+// with the request parameters.
+package validate
+
+import (
+	"fmt"
+	"github.com/garyburd/twister/web"
+	"net/mail"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Checker is implemented by structs with rules that involve more than one
+// field. The Check method adds errors to errs using the names of the fields
+// relative to the struct.
+type Checker interface {
+	Check(errs web.FieldErrors)
+}
+
+// Struct validates the struct pointed to by v. If the struct is not valid,
+// then Struct returns a web.FieldErrors. Otherwise, nil is returned.
+func Struct(v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Ptr {
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		panic("twister: validate.Struct requires a struct or pointer to a struct")
+	}
+	errs := make(web.FieldErrors)
+	validateStruct(rv, "", errs)
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// Bind fills the struct pointed to by v using web.Bind and validates the
+// struct. If the values cannot be bound or the struct is not valid, then Bind
+// returns a web.FieldErrors. Rules are not checked for fields that could not
+// be bound.
+func Bind(req *web.Request, v interface{}) error {
+	bindErr := web.Bind(req, v)
+	validateErr := Struct(v)
+	if bindErr == nil {
+		return validateErr
+	}
+	errs, ok := bindErr.(web.FieldErrors)
+	if !ok {
+		return bindErr
+	}
+	if validateErr != nil {
+		for name, messages := range validateErr.(web.FieldErrors) {
+			if _, found := errs[name]; !found {
+				errs[name] = messages
+			}
+		}
+	}
+	return errs
+}
+
+// rule is a parsed rule from a validate tag.
+type rule struct {
+	name string
+	arg  string
+
+	// Limit for min, max and len.
+	limit float64
+
+	// Values for oneof.
+	options []string
+
+	// Other field for eqfield and nefield.
+	other reflect.StructField
+}
+
+// field is the validation plan for a struct field.
+type field struct {
+	index   int
+	name    string
+	rules   []rule
+	pattern *regexp.Regexp
+
+	// Plan for embedded struct fields.
+	embedded *plan
+}
+
+// plan is the validation plan for a struct type.
+type plan struct {
+	fields []field
+}
+
+var (
+	planMutex sync.Mutex
+	plans     = map[reflect.Type]*plan{}
+)
+
+// planFor returns the validation plan for struct type t. The plan for a
+// type and the types nested in the type are compiled on first use. The
+// function panics if a validate or pattern tag is not valid so that errors
+// in the tags are found the first time that a type is validated instead of
+// when a field has a particular value.
+func planFor(t reflect.Type) *plan {
+	planMutex.Lock()
+	defer planMutex.Unlock()
+	if p := plans[t]; p != nil {
+		return p
+	}
+	building := make(map[reflect.Type]*plan)
+	p := compileStruct(t, building)
+	for t, p := range building {
+		plans[t] = p
+	}
+	return p
+}
+
+func compileStruct(t reflect.Type, building map[reflect.Type]*plan) *plan {
+	if p := plans[t]; p != nil {
+		return p
+	}
+	if p := building[t]; p != nil {
+		return p
+	}
+	p := &plan{}
+	building[t] = p
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name := web.BindName(f)
+		switch name {
+		case "-":
+			continue
+		case "":
+			p.fields = append(p.fields, field{index: i, embedded: compileStruct(f.Type, building)})
+			continue
+		}
+		pf := field{index: i, name: name}
+		if tag := f.Tag.Get("validate"); tag != "" {
+			pf.rules = compileRules(t, f, tag)
+		}
+		if pattern := f.Tag.Get("pattern"); pattern != "" {
+			if f.Type.Kind() != reflect.String {
+				panic("twister: pattern tag on non-string field " + t.String() + "." + f.Name)
+			}
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				panic("twister: bad pattern for " + t.String() + "." + f.Name + ": " + err.Error())
+			}
+			pf.pattern = re
+		}
+		if nt := nestedType(f.Type); nt != nil {
+			compileStruct(nt, building)
+		}
+		p.fields = append(p.fields, pf)
+	}
+	return p
+}
+
+// nestedType returns the struct type validated by validateNested for a field
+// of type t or nil if the field does not contain a struct.
+func nestedType(t reflect.Type) reflect.Type {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() == reflect.Slice {
+		t = t.Elem()
+		for t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+	}
+	if t.Kind() != reflect.Struct || t == timeType {
+		return nil
+	}
+	return t
+}
+
+func compileRules(parent reflect.Type, f reflect.StructField, tag string) []rule {
+	t := f.Type
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	var rules []rule
+	for _, s := range strings.Split(tag, ",") {
+		r := rule{name: strings.TrimSpace(s)}
+		if i := strings.Index(r.name, "="); i >= 0 {
+			r.name, r.arg = r.name[:i], r.name[i+1:]
+		}
+		bad := func(reason string) {
+			panic("twister: bad validate rule " + strings.TrimSpace(s) + " for " + parent.String() + "." + f.Name + ": " + reason)
+		}
+		switch r.name {
+		case "required", "email":
+		case "min", "max", "len":
+			if !hasSize(t) || (r.name == "len" && !isLength(t)) {
+				bad("rule not supported for type " + t.String())
+			}
+			var err error
+			r.limit, err = strconv.ParseFloat(r.arg, 64)
+			if err != nil {
+				bad("limit is not a number")
+			}
+		case "oneof":
+			r.options = strings.Fields(r.arg)
+			if len(r.options) == 0 {
+				bad("no values")
+			}
+		case "eqfield", "nefield":
+			var ok bool
+			r.other, ok = parent.FieldByName(r.arg)
+			if !ok {
+				bad("unknown field")
+			}
+		default:
+			bad("unknown rule")
+		}
+		rules = append(rules, r)
+	}
+	return rules
+}
+
+func validateStruct(v reflect.Value, prefix string, errs web.FieldErrors) {
+	validatePlan(planFor(v.Type()), v, prefix, errs)
+}
+
+func validatePlan(p *plan, v reflect.Value, prefix string, errs web.FieldErrors) {
+	for _, f := range p.fields {
+		fv := v.Field(f.index)
+		if f.embedded != nil {
+			validatePlan(f.embedded, fv, prefix, errs)
+			continue
+		}
+		if f.rules != nil {
+			checkRules(v, fv, prefix+f.name, f.rules, errs)
+		}
+		if f.pattern != nil && fv.Len() > 0 && !f.pattern.MatchString(fv.String()) {
+			errs.Add(prefix+f.name, "is not in the correct format")
+		}
+		validateNested(fv, prefix+f.name, errs)
+	}
+	if c, ok := addr(v).(Checker); ok {
+		local := make(web.FieldErrors)
+		c.Check(local)
+		for name, messages := range local {
+			for _, message := range messages {
+				errs.Add(prefix+name, message)
+			}
+		}
+	}
+}
+
+// addr returns the address of v as an interface if v is addressable.
+// Otherwise, v is returned as an interface.
+func addr(v reflect.Value) interface{} {
+	if v.CanAddr() {
+		return v.Addr().Interface()
+	}
+	return v.Interface()
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+func validateNested(v reflect.Value, name string, errs web.FieldErrors) {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if !v.IsNil() {
+			validateNested(v.Elem(), name, errs)
+		}
+	case reflect.Struct:
+		if v.Type() != timeType {
+			validateStruct(v, name+".", errs)
+		}
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			e := v.Index(i)
+			for e.Kind() == reflect.Ptr && !e.IsNil() {
+				e = e.Elem()
+			}
+			if e.Kind() == reflect.Struct && e.Type() != timeType {
+				validateStruct(e, name+"["+strconv.Itoa(i)+"].", errs)
+			}
+		}
+	}
+}
+
+// isZero returns true if v is the zero value for its type.
+func isZero(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.String, reflect.Slice, reflect.Map, reflect.Array:
+		return v.Len() == 0
+	case reflect.Ptr, reflect.Interface:
+		return v.IsNil()
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Struct:
+		if v.Type() == timeType {
+			return v.Interface().(time.Time).IsZero()
+		}
+	}
+	return false
+}
+
+// hasSize returns true if the min and max rules apply to type t.
+func hasSize(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.String, reflect.Slice, reflect.Map, reflect.Array,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+// size returns the number used by the min, max and len rules.
+func size(v reflect.Value) float64 {
+	switch v.Kind() {
+	case reflect.String:
+		return float64(utf8.RuneCountInString(v.String()))
+	case reflect.Slice, reflect.Map, reflect.Array:
+		return float64(v.Len())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint())
+	case reflect.Float32, reflect.Float64:
+		return v.Float()
+	}
+	return 0
+}
+
+// isLength returns true if the min, max and len rules apply to the length of
+// values of type t.
+func isLength(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.String, reflect.Slice, reflect.Map, reflect.Array:
+		return true
+	}
+	return false
+}
+
+func plural(n string, unit string) string {
+	if n == "1" {
+		return n + " " + unit
+	}
+	return n + " " + unit + "s"
+}
+
+func lengthUnit(v reflect.Value) string {
+	if v.Kind() == reflect.String {
+		return "character"
+	}
+	return "item"
+}
+
+func checkRules(parent reflect.Value, v reflect.Value, name string, rules []rule, errs web.FieldErrors) {
+	for v.Kind() == reflect.Ptr && !v.IsNil() {
+		v = v.Elem()
+	}
+	zero := isZero(v)
+	for _, r := range rules {
+		if r.name == "required" {
+			if zero {
+				errs.Add(name, "is required")
+				return
+			}
+			continue
+		}
+		if zero {
+			continue
+		}
+		switch r.name {
+		case "min", "max", "len":
+			n := size(v)
+			switch {
+			case r.name == "min" && n < r.limit:
+				if isLength(v.Type()) {
+					errs.Add(name, "must have at least "+plural(r.arg, lengthUnit(v)))
+				} else {
+					errs.Add(name, "must be at least "+r.arg)
+				}
+			case r.name == "max" && n > r.limit:
+				if isLength(v.Type()) {
+					errs.Add(name, "must have at most "+plural(r.arg, lengthUnit(v)))
+				} else {
+					errs.Add(name, "must be at most "+r.arg)
+				}
+			case r.name == "len" && n != r.limit:
+				errs.Add(name, "must have exactly "+plural(r.arg, lengthUnit(v)))
+			}
+		case "email":
+			s := fmt.Sprint(v.Interface())
+			if a, err := mail.ParseAddress(s); err != nil || a.Address != s {
+				errs.Add(name, "must be an email address")
+			}
+		case "oneof":
+			s := fmt.Sprint(v.Interface())
+			found := false
+			for _, option := range r.options {
+				if s == option {
+					found = true
+					break
+				}
+			}
+			if !found {
+				errs.Add(name, "must be one of "+strings.Join(r.options, ", "))
+			}
+		case "eqfield", "nefield":
+			other := parent.FieldByIndex(r.other.Index)
+			for other.Kind() == reflect.Ptr && !other.IsNil() {
+				other = other.Elem()
+			}
+			equal := reflect.DeepEqual(v.Interface(), other.Interface())
+			if r.name == "eqfield" && !equal {
+				errs.Add(name, "must match "+web.BindName(r.other))
+			} else if r.name == "nefield" && equal {
+				errs.Add(name, "must not match "+web.BindName(r.other))
+			}
+		}
+	}
+}
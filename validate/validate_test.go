@@ -0,0 +1,171 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package validate
+
+import (
+	"encoding/json"
+	"github.com/garyburd/twister/web"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type testItem struct {
+	Name     string `form:"name" validate:"required"`
+	Quantity int    `form:"qty" validate:"min=1,max=10"`
+}
+
+type testSignup struct {
+	Name     string     `form:"name" validate:"required,min=2,max=8"`
+	Email    string     `form:"email" validate:"required,email"`
+	Code     string     `form:"code" pattern:"^[A-Z]{3}$"`
+	Plan     string     `form:"plan" validate:"oneof=free pro"`
+	Password string     `form:"password" validate:"required,min=4"`
+	Confirm  string     `form:"confirm" validate:"eqfield=Password"`
+	Age      *int       `form:"age" validate:"min=18"`
+	Tags     []string   `form:"tags" validate:"max=2"`
+	Items    []testItem `form:"items"`
+	Start    int        `form:"start"`
+	End      int        `form:"end"`
+}
+
+func (s *testSignup) Check(errs web.FieldErrors) {
+	if s.End < s.Start {
+		errs.Add("end", "must not be before start")
+	}
+}
+
+func TestStruct(t *testing.T) {
+	age := 17
+	v := testSignup{
+		Name:     "G",
+		Email:    "not an email",
+		Code:     "abc",
+		Plan:     "gold",
+		Password: "secret",
+		Confirm:  "secrets",
+		Age:      &age,
+		Tags:     []string{"a", "b", "c"},
+		Items:    []testItem{{Name: "x", Quantity: 1}, {Quantity: 11}},
+		Start:    2,
+		End:      1,
+	}
+	err := Struct(&v)
+	expect := web.FieldErrors{
+		"name":          {"must have at least 2 characters"},
+		"email":         {"must be an email address"},
+		"code":          {"is not in the correct format"},
+		"plan":          {"must be one of free, pro"},
+		"confirm":       {"must match password"},
+		"age":           {"must be at least 18"},
+		"tags":          {"must have at most 2 items"},
+		"items[1].name": {"is required"},
+		"items[1].qty":  {"must be at most 10"},
+		"end":           {"must not be before start"},
+	}
+	if !reflect.DeepEqual(err, expect) {
+		t.Errorf("err=%v,\n want %v", err, expect)
+	}
+
+	v = testSignup{Name: "Gopher", Email: "gopher@example.com", Password: "secret", Confirm: "secret"}
+	if err := Struct(&v); err != nil {
+		t.Errorf("valid struct returned %v", err)
+	}
+}
+
+type badRule struct {
+	Name string `form:"name" validate:"requird"`
+}
+
+type badMinTime struct {
+	When time.Time `form:"when" validate:"min=1"`
+}
+
+type badLimit struct {
+	Name string `form:"name" validate:"max=ten"`
+}
+
+type badPattern struct {
+	Code string `form:"code" pattern:"[A-Z"`
+}
+
+type badEqField struct {
+	Confirm string `form:"confirm" validate:"eqfield=Pasword"`
+}
+
+type badNested struct {
+	Items []*badRule `form:"items"`
+}
+
+func TestBadTags(t *testing.T) {
+	for _, v := range []interface{}{
+		&badRule{},
+		&badMinTime{},
+		&badLimit{},
+		&badPattern{},
+		&badEqField{},
+		&badNested{},
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("Struct(%T) did not panic", v)
+				}
+			}()
+			// The zero value is used to check that tags are checked
+			// independent of the field values.
+			Struct(v)
+		}()
+	}
+}
+
+func TestBindAndRespond(t *testing.T) {
+	h := web.FormHandler(-1, false, web.HandlerFunc(func(req *web.Request) {
+		var v testSignup
+		err := Bind(req, &v)
+		if errs, ok := err.(web.FieldErrors); ok {
+			form := NewForm(req, err)
+			if form.Value("name") != "Gopher" || form.HasError("name") || !form.HasError("start") || form.Valid() {
+				t.Errorf("form=%+v", form)
+			}
+			RespondProblem(req, errs)
+			return
+		}
+		req.Respond(web.StatusOK)
+	}))
+	status, header, body := web.RunHandler("/", "POST",
+		web.NewHeader(web.HeaderContentType, "application/x-www-form-urlencoded"),
+		[]byte("name=Gopher&password=secret&confirm=secret&start=x"), h)
+	if status != web.StatusUnprocessableEntity {
+		t.Fatalf("status=%d, want %d", status, web.StatusUnprocessableEntity)
+	}
+	if ct := header.Get(web.HeaderContentType); ct != web.ContentTypeProblem {
+		t.Errorf("content-type=%q", ct)
+	}
+	var v struct {
+		Status int
+		Errors map[string][]string
+	}
+	if err := json.Unmarshal(body, &v); err != nil {
+		t.Fatal(err)
+	}
+	expect := map[string][]string{
+		"email": {"is required"},
+		"start": {"must be an integer"},
+	}
+	if v.Status != web.StatusUnprocessableEntity || !reflect.DeepEqual(v.Errors, expect) {
+		t.Errorf("body=%s", body)
+	}
+}
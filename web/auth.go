@@ -0,0 +1,333 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package web
+
+import (
+	"container/list"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PasswordVerifier is the interface for verifying passwords.
+type PasswordVerifier interface {
+	// VerifyPassword returns true if the password is correct for the user.
+	VerifyPassword(user, password string) bool
+}
+
+// PasswordVerifierFunc is a type adapter to allow the use of ordinary
+// functions as password verifiers.
+type PasswordVerifierFunc func(user, password string) bool
+
+// VerifyPassword calls f(user, password).
+func (f PasswordVerifierFunc) VerifyPassword(user, password string) bool {
+	return f(user, password)
+}
+
+// DigestCredentials is the interface for looking up credentials for digest
+// authentication.
+type DigestCredentials interface {
+	// DigestHA1 returns the hex encoded SHA-256 hash of
+	// user ":" realm ":" password. The second return value is false if the
+	// user is not known.
+	DigestHA1(user, realm string) (string, bool)
+}
+
+// DigestHA1 returns the hex encoded SHA-256 hash of
+// user ":" realm ":" password. Use this function to create the values
+// returned from DigestCredentials.
+func DigestHA1(user, realm, password string) string {
+	return sha256Hex(user + ":" + realm + ":" + password)
+}
+
+func sha256Hex(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+const authUserEnvKey = "twister.web.user"
+
+// AuthUser returns the user authenticated by BasicAuthHandler or
+// DigestAuthHandler or "" if the request is not authenticated.
+func AuthUser(req *Request) string {
+	s, _ := req.Env[authUserEnvKey].(string)
+	return s
+}
+
+var (
+	errAuthMissing = errors.New("twister: authorization required")
+	errAuthInvalid = errors.New("twister: invalid credentials")
+)
+
+// BasicAuthHandler returns a handler that authenticates requests using HTTP
+// Basic authentication (RFC 7617). If the request does not have valid
+// credentials, then the handler responds with HTTP status 401 and a
+// challenge for realm. Otherwise, the handler stores the user in the request
+// Env and calls h. Use AuthUser to get the user.
+//
+// Basic authentication sends the password in the clear. Use it only with
+// HTTPS.
+func BasicAuthHandler(realm string, verifier PasswordVerifier, h Handler) Handler {
+	return &basicAuthHandler{
+		challenge: "Basic realm=" + QuoteHeaderValue(realm) + `, charset="UTF-8"`,
+		verifier:  verifier,
+		h:         h,
+	}
+}
+
+type basicAuthHandler struct {
+	challenge string
+	verifier  PasswordVerifier
+	h         Handler
+}
+
+func (h *basicAuthHandler) ServeWeb(req *Request) {
+	scheme, credentials := splitAuthorization(req.Header.Get(HeaderAuthorization))
+	if scheme != "basic" {
+		req.Error(StatusUnauthorized, errAuthMissing, HeaderWWWAuthenticate, h.challenge)
+		return
+	}
+	p, err := base64.StdEncoding.DecodeString(credentials)
+	if err != nil {
+		req.Error(StatusUnauthorized, errAuthInvalid, HeaderWWWAuthenticate, h.challenge)
+		return
+	}
+	i := strings.IndexByte(string(p), ':')
+	if i < 0 || !h.verifier.VerifyPassword(string(p[:i]), string(p[i+1:])) {
+		req.Error(StatusUnauthorized, errAuthInvalid, HeaderWWWAuthenticate, h.challenge)
+		return
+	}
+	req.Env[authUserEnvKey] = string(p[:i])
+	h.h.ServeWeb(req)
+}
+
+// splitAuthorization splits the Authorization header value into the lower
+// case scheme and the credentials.
+func splitAuthorization(s string) (scheme, credentials string) {
+	scheme, s = splitToken(skipSpace(s))
+	return toLowerToken(scheme), strings.TrimSpace(s)
+}
+
+// parseAuthParams parses a comma separated list of RFC 7235 auth-params.
+func parseAuthParams(s string) map[string]string {
+	m := make(map[string]string)
+	for {
+		var name, value string
+		name, s = splitToken(skipSpace(s))
+		if name == "" {
+			return m
+		}
+		s = skipSpace(s)
+		if !strings.HasPrefix(s, "=") {
+			return m
+		}
+		value, s = splitTokenOrQuoted(skipSpace(s[1:]))
+		m[toLowerToken(name)] = value
+		s = skipSpace(s)
+		if !strings.HasPrefix(s, ",") {
+			return m
+		}
+		s = s[1:]
+	}
+}
+
+// digestNonceMaxAge is the lifetime of a digest authentication nonce.
+const digestNonceMaxAge = 5 * time.Minute
+
+// DigestAuthHandler returns a handler that authenticates requests using HTTP
+// Digest authentication (RFC 7616) with the SHA-256 algorithm and the "auth"
+// quality of protection. If the request does not have valid credentials,
+// then the handler responds with HTTP status 401 and a challenge for realm.
+// Otherwise, the handler stores the user in the request Env and calls h. Use
+// AuthUser to get the user.
+//
+// Nonces expire after five minutes. A nonce is a timestamp signed with a
+// secret generated by the handler, so issuing a challenge does not store any
+// state. The handler records the nonce count for nonces used in authenticated
+// requests and rejects requests that reuse a nonce count to prevent replay of
+// captured requests.
+func DigestAuthHandler(realm string, credentials DigestCredentials, h Handler) Handler {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		panic("twister: could not generate digest nonce secret: " + err.Error())
+	}
+	return &digestAuthHandler{
+		realm:       realm,
+		credentials: credentials,
+		h:           h,
+		secret:      secret,
+		now:         time.Now,
+		nonces:      make(map[string]*list.Element),
+		order:       list.New(),
+	}
+}
+
+type digestAuthHandler struct {
+	realm       string
+	credentials DigestCredentials
+	h           Handler
+	secret      []byte
+	now         func() time.Time
+
+	// Nonce counts for nonces used in authenticated requests. The list is
+	// ordered by first use so that expired nonces are removed from the front.
+	mu     sync.Mutex
+	nonces map[string]*list.Element
+	order  *list.List
+}
+
+type digestNonce struct {
+	nonce string
+	used  time.Time
+	nc    uint64
+}
+
+const (
+	digestNonceTimeLen = 8
+	digestNonceRandLen = 8
+	digestNonceMACLen  = 16
+)
+
+// newNonce returns a nonce containing the current time, random bytes and a
+// MAC of the time and random bytes.
+func (h *digestAuthHandler) newNonce() (string, error) {
+	p := make([]byte, digestNonceTimeLen+digestNonceRandLen)
+	binary.BigEndian.PutUint64(p, uint64(h.now().UnixNano()))
+	if _, err := rand.Read(p[digestNonceTimeLen:]); err != nil {
+		return "", err
+	}
+	mac := hmac.New(sha256.New, h.secret)
+	mac.Write(p)
+	p = append(p, mac.Sum(nil)[:digestNonceMACLen]...)
+	return base64.RawURLEncoding.EncodeToString(p), nil
+}
+
+// nonceTime returns the time that the nonce was created or false if the
+// nonce was not created by the handler.
+func (h *digestAuthHandler) nonceTime(nonce string) (time.Time, bool) {
+	p, err := base64.RawURLEncoding.DecodeString(nonce)
+	if err != nil || len(p) != digestNonceTimeLen+digestNonceRandLen+digestNonceMACLen {
+		return time.Time{}, false
+	}
+	n := digestNonceTimeLen + digestNonceRandLen
+	mac := hmac.New(sha256.New, h.secret)
+	mac.Write(p[:n])
+	if !hmac.Equal(mac.Sum(nil)[:digestNonceMACLen], p[n:]) {
+		return time.Time{}, false
+	}
+	return time.Unix(0, int64(binary.BigEndian.Uint64(p))), true
+}
+
+// challenge responds to the request with a new nonce.
+func (h *digestAuthHandler) challenge(req *Request, reason error, stale bool) {
+	nonce, err := h.newNonce()
+	if err != nil {
+		req.Error(StatusInternalServerError, err)
+		return
+	}
+	challenge := "Digest realm=" + QuoteHeaderValue(h.realm) +
+		`, qop="auth", algorithm=SHA-256, nonce="` + nonce + `"`
+	if stale {
+		challenge += ", stale=true"
+	}
+	req.Error(StatusUnauthorized, reason, HeaderWWWAuthenticate, challenge)
+}
+
+// checkNonce returns true if the nonce is valid and the nonce count has not
+// been used. The stale result is true if the nonce has expired or was not
+// created by the handler.
+func (h *digestAuthHandler) checkNonce(nonce string, nc uint64) (ok, stale bool) {
+	now := h.now()
+	created, valid := h.nonceTime(nonce)
+	if !valid || now.Sub(created) > digestNonceMaxAge || created.After(now) {
+		return false, true
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	// Remove the nonces that have been in use for longer than the nonce
+	// lifetime. Because a nonce is first used after the nonce is created,
+	// the removed nonces have expired.
+	for {
+		e := h.order.Front()
+		if e == nil || now.Sub(e.Value.(*digestNonce).used) <= digestNonceMaxAge {
+			break
+		}
+		h.order.Remove(e)
+		delete(h.nonces, e.Value.(*digestNonce).nonce)
+	}
+
+	if e := h.nonces[nonce]; e != nil {
+		dn := e.Value.(*digestNonce)
+		if nc <= dn.nc {
+			return false, false
+		}
+		dn.nc = nc
+		return true, false
+	}
+	h.nonces[nonce] = h.order.PushBack(&digestNonce{nonce: nonce, used: now, nc: nc})
+	return true, false
+}
+
+func (h *digestAuthHandler) ServeWeb(req *Request) {
+	scheme, s := splitAuthorization(req.Header.Get(HeaderAuthorization))
+	if scheme != "digest" {
+		h.challenge(req, errAuthMissing, false)
+		return
+	}
+	params := parseAuthParams(s)
+	user := params["username"]
+	if params["realm"] != h.realm ||
+		params["qop"] != "auth" ||
+		!strings.EqualFold(params["algorithm"], "SHA-256") ||
+		params["uri"] != req.URL.RequestURI() ||
+		params["cnonce"] == "" ||
+		user == "" {
+		h.challenge(req, errAuthInvalid, false)
+		return
+	}
+	ha1, found := h.credentials.DigestHA1(user, h.realm)
+	if !found {
+		h.challenge(req, errAuthInvalid, false)
+		return
+	}
+	ha2 := sha256Hex(req.Method + ":" + params["uri"])
+	expected := sha256Hex(ha1 + ":" + params["nonce"] + ":" + params["nc"] + ":" + params["cnonce"] + ":auth:" + ha2)
+	if subtle.ConstantTimeCompare([]byte(expected), []byte(params["response"])) != 1 {
+		h.challenge(req, errAuthInvalid, false)
+		return
+	}
+	nc, err := strconv.ParseUint(params["nc"], 16, 64)
+	if err != nil {
+		h.challenge(req, errAuthInvalid, false)
+		return
+	}
+	if ok, stale := h.checkNonce(params["nonce"], nc); !ok {
+		h.challenge(req, errAuthInvalid, stale)
+		return
+	}
+	req.Env[authUserEnvKey] = user
+	h.h.ServeWeb(req)
+}
@@ -0,0 +1,177 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package web
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+)
+
+var authTestHandler = HandlerFunc(func(req *Request) {
+	io.WriteString(req.Respond(StatusOK), AuthUser(req))
+})
+
+func TestBasicAuthHandler(t *testing.T) {
+	h := BasicAuthHandler("test", PasswordVerifierFunc(func(user, password string) bool {
+		return user == "gopher" && password == "se:cret"
+	}), authTestHandler)
+	tests := []struct {
+		authorization string
+		status        int
+	}{
+		{"", StatusUnauthorized},
+		{"Basic " + base64.StdEncoding.EncodeToString([]byte("gopher:se:cret")), StatusOK},
+		{"basic  " + base64.StdEncoding.EncodeToString([]byte("gopher:se:cret")), StatusOK},
+		{"Basic " + base64.StdEncoding.EncodeToString([]byte("gopher:wrong")), StatusUnauthorized},
+		{"Basic " + base64.StdEncoding.EncodeToString([]byte("gopher")), StatusUnauthorized},
+		{"Basic !!!", StatusUnauthorized},
+		{"Bearer abc", StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		header := NewHeader()
+		if tt.authorization != "" {
+			header.Set(HeaderAuthorization, tt.authorization)
+		}
+		status, respHeader, body := RunHandler("/", "GET", header, nil, h)
+		if status != tt.status {
+			t.Errorf("%q status=%d, want %d", tt.authorization, status, tt.status)
+		}
+		if status == StatusOK && string(body) != "gopher" {
+			t.Errorf("%q user=%q", tt.authorization, body)
+		}
+		if status == StatusUnauthorized && respHeader.Get(HeaderWWWAuthenticate) != `Basic realm="test", charset="UTF-8"` {
+			t.Errorf("%q challenge=%q", tt.authorization, respHeader.Get(HeaderWWWAuthenticate))
+		}
+	}
+}
+
+type testDigestCredentials map[string]string
+
+func (c testDigestCredentials) DigestHA1(user, realm string) (string, bool) {
+	password, ok := c[user]
+	return DigestHA1(user, realm, password), ok
+}
+
+func digestAuthorization(user, password, realm, nonce, method, uri, nc string) string {
+	ha1 := DigestHA1(user, realm, password)
+	ha2 := sha256Hex(method + ":" + uri)
+	response := sha256Hex(ha1 + ":" + nonce + ":" + nc + ":abc:auth:" + ha2)
+	return fmt.Sprintf(`Digest username="%s", realm="%s", uri="%s", algorithm=SHA-256, nonce="%s", nc=%s, cnonce="abc", qop=auth, response="%s"`,
+		user, realm, uri, nonce, nc, response)
+}
+
+func TestDigestAuthHandler(t *testing.T) {
+	h := DigestAuthHandler("test", testDigestCredentials{"gopher": "secret"}, authTestHandler)
+
+	status, header, _ := RunHandler("/a?b", "GET", nil, nil, h)
+	if status != StatusUnauthorized {
+		t.Fatalf("status=%d, want %d", status, StatusUnauthorized)
+	}
+	scheme, s := splitAuthorization(header.Get(HeaderWWWAuthenticate))
+	params := parseAuthParams(s)
+	if scheme != "digest" || params["realm"] != "test" || params["qop"] != "auth" || params["algorithm"] != "SHA-256" {
+		t.Fatalf("challenge=%q", header.Get(HeaderWWWAuthenticate))
+	}
+	nonce := params["nonce"]
+
+	tests := []struct {
+		authorization string
+		status        int
+	}{
+		{digestAuthorization("gopher", "secret", "test", nonce, "GET", "/a?b", "00000001"), StatusOK},
+		// Replay.
+		{digestAuthorization("gopher", "secret", "test", nonce, "GET", "/a?b", "00000001"), StatusUnauthorized},
+		{digestAuthorization("gopher", "secret", "test", nonce, "GET", "/a?b", "00000002"), StatusOK},
+		{digestAuthorization("gopher", "wrong", "test", nonce, "GET", "/a?b", "00000003"), StatusUnauthorized},
+		{digestAuthorization("other", "secret", "test", nonce, "GET", "/a?b", "00000004"), StatusUnauthorized},
+		{digestAuthorization("gopher", "secret", "test", nonce, "GET", "/other", "00000005"), StatusUnauthorized},
+		{digestAuthorization("gopher", "secret", "test", "unknown", "GET", "/a?b", "00000001"), StatusUnauthorized},
+	}
+	for i, tt := range tests {
+		status, header, body := RunHandler("/a?b", "GET", NewHeader(HeaderAuthorization, tt.authorization), nil, h)
+		if status != tt.status {
+			t.Errorf("%d: status=%d, want %d", i, status, tt.status)
+		}
+		if status == StatusOK && string(body) != "gopher" {
+			t.Errorf("%d: user=%q", i, body)
+		}
+		if status == StatusUnauthorized && header.Get(HeaderWWWAuthenticate) == "" {
+			t.Errorf("%d: challenge missing", i)
+		}
+	}
+
+	_, header, _ = RunHandler("/a?b", "GET",
+		NewHeader(HeaderAuthorization, digestAuthorization("gopher", "secret", "test", "unknown", "GET", "/a?b", "00000001")), nil, h)
+	if _, s := splitAuthorization(header.Get(HeaderWWWAuthenticate)); parseAuthParams(s)["stale"] != "true" {
+		t.Errorf("stale not set for unknown nonce: %q", header.Get(HeaderWWWAuthenticate))
+	}
+}
+
+func TestDigestNonceExpires(t *testing.T) {
+	dh := DigestAuthHandler("test", testDigestCredentials{"gopher": "secret"}, authTestHandler).(*digestAuthHandler)
+	now := time.Now()
+	dh.now = func() time.Time { return now }
+
+	nonce, err := dh.newNonce()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		RunHandler("/", "GET", nil, nil, dh)
+	}
+	if len(dh.nonces) != 0 {
+		t.Errorf("challenges stored %d nonces", len(dh.nonces))
+	}
+
+	run := func(nc string) (int, Header) {
+		status, header, _ := RunHandler("/", "GET",
+			NewHeader(HeaderAuthorization, digestAuthorization("gopher", "secret", "test", nonce, "GET", "/", nc)), nil, dh)
+		return status, header
+	}
+	if status, _ := run("00000001"); status != StatusOK {
+		t.Fatalf("status=%d, want %d", status, StatusOK)
+	}
+	if len(dh.nonces) != 1 {
+		t.Errorf("len(nonces)=%d, want 1", len(dh.nonces))
+	}
+
+	now = now.Add(digestNonceMaxAge + time.Second)
+	status, header := run("00000002")
+	if status != StatusUnauthorized {
+		t.Errorf("expired nonce: status=%d, want %d", status, StatusUnauthorized)
+	}
+	if _, s := splitAuthorization(header.Get(HeaderWWWAuthenticate)); parseAuthParams(s)["stale"] != "true" {
+		t.Errorf("stale not set for expired nonce: %q", header.Get(HeaderWWWAuthenticate))
+	}
+
+	// Using a new nonce removes the expired nonce.
+	nonce, _ = dh.newNonce()
+	if status, _ := run("00000001"); status != StatusOK {
+		t.Fatalf("status=%d, want %d", status, StatusOK)
+	}
+	if len(dh.nonces) != 1 || dh.order.Len() != 1 {
+		t.Errorf("len(nonces)=%d, order.Len()=%d, want 1", len(dh.nonces), dh.order.Len())
+	}
+
+	// A nonce with a modified timestamp is rejected.
+	p, _ := base64.RawURLEncoding.DecodeString(nonce)
+	p[0] ^= 1
+	if _, ok := dh.nonceTime(base64.RawURLEncoding.EncodeToString(p)); ok {
+		t.Error("modified nonce accepted")
+	}
+}
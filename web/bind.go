@@ -0,0 +1,447 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package web
+
+import (
+	"bytes"
+	"encoding"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FieldErrors maps field names to error messages. The field names are the
+// names used in the request, for example "items[0].name".
+type FieldErrors map[string][]string
+
+// Add appends message to the messages for the given field.
+func (e FieldErrors) Add(name string, message string) {
+	e[name] = append(e[name], message)
+}
+
+// Get returns the first message for the given field or "" if the field does
+// not have an error.
+func (e FieldErrors) Get(name string) string {
+	if messages := e[name]; len(messages) > 0 {
+		return messages[0]
+	}
+	return ""
+}
+
+// Error returns the errors in field name order.
+func (e FieldErrors) Error() string {
+	names := make([]string, 0, len(e))
+	for name := range e {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var buf bytes.Buffer
+	for _, name := range names {
+		for _, message := range e[name] {
+			if buf.Len() > 0 {
+				buf.WriteString("; ")
+			}
+			buf.WriteString(name)
+			buf.WriteString(": ")
+			buf.WriteString(message)
+		}
+	}
+	return buf.String()
+}
+
+// StatusCode returns StatusUnprocessableEntity.
+func (e FieldErrors) StatusCode() int {
+	return StatusUnprocessableEntity
+}
+
+// Tag keys for specifying the source of a bound value.
+var bindSources = []string{"form", "query", "cookie", "url"}
+
+// maxBindIndex is the maximum index accepted in an indexed name.
+const maxBindIndex = 1000
+
+// Bind fills the struct pointed to by v with values from the request.
+//
+// The struct field tag specifies the source and name of the value:
+//
+//  form:"name"    request parameter from Request.Param
+//  query:"name"   parameter from the URL query only
+//  cookie:"name"  cookie from Request.Cookie
+//  url:"name"     parameter from Request.URLParam
+//
+// Fields without one of these tags are bound from the request parameter with
+// the field's name. Fields with the name "-" are ignored. Fields of nested
+// structs use the tag of the nested struct's field as the source unless the
+// field has its own tag.
+//
+// The names of nested struct fields are joined with '.'. Elements of slices
+// are named with an index, for example "items[0].name". Slices of basic types
+// are also filled from repeated values with the slice name.
+//
+// Bind supports fields of the basic types, time.Time, types that implement
+// encoding.TextUnmarshaler, pointers to these types, structs and slices. Empty
+// values are ignored for fields that are not strings. The layout for
+// time.Time fields can be specified with the tag layout:"2006-01-02".
+// Otherwise, RFC 3339 and the formats used by HTML date inputs are accepted.
+//
+// Bind attempts to set all fields. If any value cannot be converted, then
+// Bind returns a FieldErrors with an entry for each failed field.
+func Bind(req *Request, v interface{}) error {
+	query := make(Values)
+	query.ParseFormEncodedBytes([]byte(req.URL.RawQuery))
+	url := make(Values, len(req.URLParam))
+	for k, s := range req.URLParam {
+		url.Set(k, s)
+	}
+	return bind(map[string]Values{
+		"form":   req.Param,
+		"query":  query,
+		"cookie": req.Cookie,
+		"url":    url,
+	}, v)
+}
+
+// BindValues fills the struct pointed to by v with values from m using the
+// rules described for Bind. All fields are bound from m.
+func BindValues(m Values, v interface{}) error {
+	sources := make(map[string]Values, len(bindSources))
+	for _, source := range bindSources {
+		sources[source] = m
+	}
+	return bind(sources, v)
+}
+
+func bind(sources map[string]Values, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		panic("twister: bind requires a pointer to a struct")
+	}
+	b := binder{sources: sources, errs: make(FieldErrors)}
+	b.bindStruct(rv.Elem(), "", "form")
+	if len(b.errs) > 0 {
+		return b.errs
+	}
+	return nil
+}
+
+type binder struct {
+	sources map[string]Values
+	errs    FieldErrors
+	index   map[string]*sourceIndex
+}
+
+// sourceIndex indexes the names in a source so that lookups by field do not
+// scan every name in the source.
+type sourceIndex struct {
+	// names is the set of names in the source and the names that the names
+	// are nested under. For "a[1].b", the set is "a", "a[1]" and "a[1].b".
+	names map[string]bool
+
+	// indexes maps a name to the sorted indexes used with the name.
+	indexes map[string][]int
+
+	// outOfRange maps a name to the names in the source that use the name
+	// with an index greater than maxBindIndex.
+	outOfRange map[string][]string
+}
+
+// sourceIndex returns the index for the source.
+func (b *binder) sourceIndex(source string) *sourceIndex {
+	if si := b.index[source]; si != nil {
+		return si
+	}
+	si := &sourceIndex{
+		names:      make(map[string]bool),
+		indexes:    make(map[string][]int),
+		outOfRange: make(map[string][]string),
+	}
+	seen := make(map[string]map[int]bool)
+	for k := range b.sources[source] {
+		si.names[k] = true
+		for i := 0; i < len(k); i++ {
+			if k[i] != '.' && k[i] != '[' {
+				continue
+			}
+			prefix := k[:i]
+			si.names[prefix] = true
+			if k[i] != '[' {
+				continue
+			}
+			j := strings.IndexByte(k[i+1:], ']')
+			if j < 0 {
+				continue
+			}
+			n, err := strconv.Atoi(k[i+1 : i+1+j])
+			if err != nil || n < 0 {
+				continue
+			}
+			if n > maxBindIndex {
+				si.outOfRange[prefix] = append(si.outOfRange[prefix], k)
+				continue
+			}
+			if seen[prefix] == nil {
+				seen[prefix] = make(map[int]bool)
+			}
+			if !seen[prefix][n] {
+				seen[prefix][n] = true
+				si.indexes[prefix] = append(si.indexes[prefix], n)
+			}
+		}
+	}
+	for _, indexes := range si.indexes {
+		sort.Ints(indexes)
+	}
+	if b.index == nil {
+		b.index = make(map[string]*sourceIndex)
+	}
+	b.index[source] = si
+	return si
+}
+
+// fieldSource returns the source and name for a struct field.
+func fieldSource(f reflect.StructField, source string) (string, string) {
+	for _, s := range bindSources {
+		if name := f.Tag.Get(s); name != "" {
+			return s, name
+		}
+	}
+	return source, f.Name
+}
+
+// BindName returns the name that Bind uses for the struct field. The name
+// "-" is returned for fields ignored by Bind. The name of an embedded struct
+// without a tag is "". Packages that report errors for bound structs use this
+// function to report errors with the names used in the request.
+func BindName(f reflect.StructField) string {
+	if f.PkgPath != "" && !f.Anonymous {
+		return "-"
+	}
+	_, name := fieldSource(f, "")
+	if f.Anonymous && name == f.Name {
+		if f.Type.Kind() == reflect.Struct {
+			return ""
+		}
+		return "-"
+	}
+	if f.PkgPath != "" {
+		return "-"
+	}
+	return name
+}
+
+func (b *binder) bindStruct(v reflect.Value, prefix string, source string) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" && !f.Anonymous {
+			continue
+		}
+		src, name := fieldSource(f, source)
+		if name == "-" {
+			continue
+		}
+		fv := v.Field(i)
+		if f.Anonymous && name == f.Name {
+			if fv.Kind() == reflect.Struct {
+				b.bindStruct(fv, prefix, src)
+			}
+			continue
+		}
+		if f.PkgPath != "" {
+			continue
+		}
+		b.bindValue(fv, prefix+name, src, f.Tag.Get("layout"))
+	}
+}
+
+var (
+	timeType            = reflect.TypeOf(time.Time{})
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// isScalar returns true if values of type t are bound from a single string.
+func isScalar(t reflect.Type) bool {
+	if t == timeType || reflect.PtrTo(t).Implements(textUnmarshalerType) {
+		return true
+	}
+	switch t.Kind() {
+	case reflect.Bool, reflect.String,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+// has returns true if the source has a value for key or for a name nested
+// under key.
+func (b *binder) has(key string, source string) bool {
+	return b.sourceIndex(source).names[key]
+}
+
+// indexes returns the sorted indexes used with key in the source.
+func (b *binder) indexes(key string, source string) []int {
+	si := b.sourceIndex(source)
+	for _, k := range si.outOfRange[key] {
+		b.errs.Add(k, "index out of range")
+	}
+	return si.indexes[key]
+}
+
+func (b *binder) bindValue(v reflect.Value, key string, source string, layout string) {
+	t := v.Type()
+
+	if t.Kind() == reflect.Ptr {
+		if !b.has(key, source) {
+			return
+		}
+		if isScalar(t.Elem()) {
+			values := b.sources[source][key]
+			if len(values) == 0 || (values[0] == "" && t.Elem().Kind() != reflect.String) {
+				return
+			}
+		}
+		if v.IsNil() {
+			v.Set(reflect.New(t.Elem()))
+		}
+		b.bindValue(v.Elem(), key, source, layout)
+		return
+	}
+
+	if isScalar(t) {
+		values := b.sources[source][key]
+		if len(values) == 0 {
+			return
+		}
+		if err := setScalar(v, values[0], layout); err != "" {
+			b.errs.Add(key, err)
+		}
+		return
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		b.bindStruct(v, key+".", source)
+	case reflect.Slice:
+		if isScalar(t.Elem()) {
+			values := b.sources[source][key]
+			for _, i := range b.indexes(key, source) {
+				if s := b.sources[source][key+"["+strconv.Itoa(i)+"]"]; len(s) > 0 {
+					values = append(values, s[0])
+				}
+			}
+			if len(values) == 0 {
+				return
+			}
+			s := reflect.MakeSlice(t, len(values), len(values))
+			for i, value := range values {
+				if err := setScalar(s.Index(i), value, layout); err != "" {
+					b.errs.Add(key, err)
+				}
+			}
+			v.Set(s)
+			return
+		}
+		indexes := b.indexes(key, source)
+		if len(indexes) == 0 {
+			return
+		}
+		n := indexes[len(indexes)-1] + 1
+		if v.Len() < n {
+			s := reflect.MakeSlice(t, n, n)
+			reflect.Copy(s, v)
+			v.Set(s)
+		}
+		for _, i := range indexes {
+			b.bindValue(v.Index(i), key+"["+strconv.Itoa(i)+"]", source, layout)
+		}
+	}
+}
+
+// timeLayouts are the layouts tried for time.Time fields without a layout tag.
+var timeLayouts = []string{time.RFC3339Nano, "2006-01-02T15:04:05", "2006-01-02T15:04", "2006-01-02"}
+
+// setScalar sets v to the value in s. A message is returned on failure.
+func setScalar(v reflect.Value, s string, layout string) string {
+	t := v.Type()
+	if t.Kind() != reflect.String && s == "" {
+		return ""
+	}
+
+	if t == timeType {
+		if layout != "" {
+			tm, err := time.Parse(layout, s)
+			if err != nil {
+				return "must be a time in the format " + layout
+			}
+			v.Set(reflect.ValueOf(tm))
+			return ""
+		}
+		for _, layout := range timeLayouts {
+			if tm, err := time.Parse(layout, s); err == nil {
+				v.Set(reflect.ValueOf(tm))
+				return ""
+			}
+		}
+		return "must be a time"
+	}
+
+	if reflect.PtrTo(t).Implements(textUnmarshalerType) {
+		if err := v.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(s)); err != nil {
+			return err.Error()
+		}
+		return ""
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		switch s {
+		case "on", "yes":
+			v.SetBool(true)
+		case "off", "no":
+			v.SetBool(false)
+		default:
+			x, err := strconv.ParseBool(s)
+			if err != nil {
+				return "must be a boolean"
+			}
+			v.SetBool(x)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		x, err := strconv.ParseInt(s, 10, t.Bits())
+		if err != nil {
+			return "must be an integer"
+		}
+		v.SetInt(x)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		x, err := strconv.ParseUint(s, 10, t.Bits())
+		if err != nil {
+			return "must be a non-negative integer"
+		}
+		v.SetUint(x)
+	case reflect.Float32, reflect.Float64:
+		x, err := strconv.ParseFloat(s, t.Bits())
+		if err != nil {
+			return "must be a number"
+		}
+		v.SetFloat(x)
+	}
+	return ""
+}
@@ -0,0 +1,175 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package web
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type bindTestColor int
+
+func (c *bindTestColor) UnmarshalText(p []byte) error {
+	switch string(p) {
+	case "red":
+		*c = 1
+	case "blue":
+		*c = 2
+	default:
+		return errors.New("unknown color")
+	}
+	return nil
+}
+
+type bindTestItem struct {
+	Name     string `form:"name"`
+	Quantity int    `form:"qty"`
+}
+
+type bindTestAddress struct {
+	City string `form:"city"`
+	Zip  string `form:"zip"`
+}
+
+type bindTestBase struct {
+	ID int `url:"id"`
+}
+
+type bindTestForm struct {
+	bindTestBase
+	Name     string           `form:"name"`
+	Age      int              `form:"age"`
+	Score    *float64         `form:"score"`
+	Agree    bool             `form:"agree"`
+	Born     time.Time        `form:"born" layout:"2006-01-02"`
+	Seen     time.Time        `form:"seen"`
+	Color    bindTestColor    `form:"color"`
+	Tags     []string         `form:"tags"`
+	Ranks    []int            `form:"ranks"`
+	Address  bindTestAddress  `form:"address"`
+	Billing  *bindTestAddress `form:"billing"`
+	Items    []bindTestItem   `form:"items"`
+	Session  string           `cookie:"session"`
+	Page     int              `query:"page"`
+	Ignored  string           `form:"-"`
+	Untagged string
+	hidden   string
+}
+
+func TestBind(t *testing.T) {
+	body := "name=Gopher&age=7&score=9.5&agree=on&born=2009-11-10&seen=2011-01-02T03:04:05Z&color=blue" +
+		"&tags=a&tags=b&ranks[1]=20&ranks[0]=10&address.city=Paris&address.zip=75001" +
+		"&items[1].name=y&items[1].qty=2&items[0].name=x&items[0].qty=1&page=9&Ignored=x&Untagged=u&hidden=h"
+	header := NewHeader(
+		HeaderContentType, "application/x-www-form-urlencoded",
+		HeaderCookie, "session=abc")
+	var v bindTestForm
+	var err error
+	h := FormHandler(-1, false, HandlerFunc(func(req *Request) {
+		req.URLParam = map[string]string{"id": "42"}
+		err = Bind(req, &v)
+	}))
+	RunHandler("/?page=3", "POST", header, []byte(body), h)
+	if err != nil {
+		t.Fatalf("Bind returned %v", err)
+	}
+	score := 9.5
+	expect := bindTestForm{
+		bindTestBase: bindTestBase{ID: 42},
+		Name:         "Gopher",
+		Age:          7,
+		Score:        &score,
+		Agree:        true,
+		Born:         time.Date(2009, 11, 10, 0, 0, 0, 0, time.UTC),
+		Seen:         time.Date(2011, 1, 2, 3, 4, 5, 0, time.UTC),
+		Color:        2,
+		Tags:         []string{"a", "b"},
+		Ranks:        []int{10, 20},
+		Address:      bindTestAddress{City: "Paris", Zip: "75001"},
+		Items:        []bindTestItem{{"x", 1}, {"y", 2}},
+		Session:      "abc",
+		Page:         3,
+		Untagged:     "u",
+	}
+	if !reflect.DeepEqual(v, expect) {
+		t.Errorf("v=%+v,\n want %+v", v, expect)
+	}
+}
+
+func TestBindErrors(t *testing.T) {
+	var v bindTestForm
+	err := BindValues(NewValues(
+		"name", "ok",
+		"age", "old",
+		"color", "green",
+		"items[0].qty", "many",
+		"items[1].qty", "3",
+		"billing.zip", "12345",
+		"born", "10/11/2009"), &v)
+	errs, ok := err.(FieldErrors)
+	if !ok {
+		t.Fatalf("err=%v, want FieldErrors", err)
+	}
+	expect := FieldErrors{
+		"age":          {"must be an integer"},
+		"color":        {"unknown color"},
+		"items[0].qty": {"must be an integer"},
+		"born":         {"must be a time in the format 2006-01-02"},
+	}
+	if !reflect.DeepEqual(errs, expect) {
+		t.Errorf("errs=%v, want %v", errs, expect)
+	}
+	if !strings.HasPrefix(errs.Error(), "age: must be an integer; born: ") {
+		t.Errorf("errs.Error()=%q", errs.Error())
+	}
+	if v.Name != "ok" || len(v.Items) != 2 || v.Items[1].Quantity != 3 || v.Billing == nil || v.Billing.Zip != "12345" {
+		t.Errorf("v=%+v", v)
+	}
+}
+
+func TestBindSourceIndex(t *testing.T) {
+	b := binder{sources: map[string]Values{"form": NewValues(
+		"a[1].b[2]", "x",
+		"a[0].c", "x",
+		"a[1].c", "x",
+		"a[5000]", "x",
+		"d.e", "x")}}
+	si := b.sourceIndex("form")
+	for _, name := range []string{"a", "a[1]", "a[1].b", "a[1].b[2]", "a[0]", "a[0].c", "d", "d.e"} {
+		if !si.names[name] {
+			t.Errorf("name %q not in index", name)
+		}
+	}
+	for _, name := range []string{"a[1].b[2].c", "a[0].b", "e", "d.", "a[2]"} {
+		if si.names[name] {
+			t.Errorf("name %q in index", name)
+		}
+	}
+	if indexes := si.indexes["a"]; !reflect.DeepEqual(indexes, []int{0, 1}) {
+		t.Errorf("indexes[a]=%v, want [0 1]", indexes)
+	}
+	if indexes := si.indexes["a[1].b"]; !reflect.DeepEqual(indexes, []int{2}) {
+		t.Errorf("indexes[a[1].b]=%v, want [2]", indexes)
+	}
+	if keys := si.outOfRange["a"]; !reflect.DeepEqual(keys, []string{"a[5000]"}) {
+		t.Errorf("outOfRange[a]=%v", keys)
+	}
+	if b.sourceIndex("form") != si {
+		t.Error("index not reused")
+	}
+}
@@ -0,0 +1,145 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package web
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+)
+
+// BufferedResponder is a responder that captures the response status, header
+// and body so that middleware can inspect or rewrite the response before the
+// response is sent. If the body is larger than MaxLen or if the handler
+// flushes the body, then the responder sends the captured response and
+// streams the remainder of the body to the underlying responder.
+//
+// Use BufferedResponder in middleware as follows:
+//
+//  br := web.NewBufferedResponder(req.Responder, 1<<20)
+//  req.Responder = br
+//  h.ServeWeb(req)
+//  if br.Buffered() {
+//      // Inspect or modify br.Status, br.Header and br.Body.
+//  }
+//  br.Commit()
+type BufferedResponder struct {
+	// Responder is the underlying responder.
+	Responder Responder
+
+	// MaxLen is the maximum number of bytes to buffer.
+	MaxLen int
+
+	// Status, Header and Body are the captured response. The fields are valid
+	// when Buffered returns true.
+	Status int
+	Header Header
+	Body   bytes.Buffer
+
+	responded bool
+	hijacked  bool
+	committed bool
+	w         io.Writer
+}
+
+// NewBufferedResponder returns a responder that buffers responses up to
+// maxLen bytes and sends responses to r.
+func NewBufferedResponder(r Responder, maxLen int) *BufferedResponder {
+	return &BufferedResponder{Responder: r, MaxLen: maxLen}
+}
+
+// Respond captures the status and header and returns a writer for the
+// response body. If the response is buffered, then Respond discards the
+// buffered response. Middleware can replace a buffered response by calling
+// Respond again, for example by calling req.Error to respond with an error
+// page.
+func (br *BufferedResponder) Respond(status int, header Header) io.Writer {
+	br.responded = true
+	br.Status = status
+	br.Header = header
+	br.Body.Reset()
+	return bufferedWriter{br}
+}
+
+// Hijack hijacks the connection from the underlying responder.
+func (br *BufferedResponder) Hijack() (net.Conn, *bufio.Reader, error) {
+	br.hijacked = true
+	return br.Responder.Hijack()
+}
+
+// Buffered returns true if the response is held in the buffer. Buffered
+// returns false if the handler did not respond, hijacked the connection or
+// if the response is streaming to the underlying responder.
+func (br *BufferedResponder) Buffered() bool {
+	return br.responded && !br.hijacked && !br.committed
+}
+
+// Commit sends the buffered response to the underlying responder. Commit
+// does nothing if the response is not buffered.
+func (br *BufferedResponder) Commit() error {
+	if !br.Buffered() {
+		return nil
+	}
+	br.committed = true
+	br.w = br.Responder.Respond(br.Status, br.Header)
+	_, err := br.w.Write(br.Body.Bytes())
+	br.Body.Reset()
+	return err
+}
+
+type bufferedWriter struct {
+	br *BufferedResponder
+}
+
+func (w bufferedWriter) Write(p []byte) (int, error) {
+	br := w.br
+	if !br.committed && br.Body.Len()+len(p) <= br.MaxLen {
+		return br.Body.Write(p)
+	}
+	if err := br.Commit(); err != nil {
+		return 0, err
+	}
+	return br.w.Write(p)
+}
+
+func (w bufferedWriter) Flush() error {
+	br := w.br
+	if err := br.Commit(); err != nil {
+		return err
+	}
+	if f, ok := br.w.(Flusher); ok {
+		return f.Flush()
+	}
+	return nil
+}
+
+// BufferedHandler returns a handler that buffers responses from h up to
+// maxLen bytes. The filter function is called with buffered responses before
+// the response is sent. The filter function can modify the fields of br or
+// replace the response by responding to req. The filter function is not
+// called for responses that are larger than maxLen or flushed by h.
+func BufferedHandler(maxLen int, filter func(req *Request, br *BufferedResponder), h Handler) Handler {
+	return HandlerFunc(func(req *Request) {
+		br := NewBufferedResponder(req.Responder, maxLen)
+		req.Responder = br
+		h.ServeWeb(req)
+		if br.Buffered() {
+			filter(req, br)
+		}
+		br.Commit()
+		req.Responder = br.Responder
+	})
+}
@@ -0,0 +1,92 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package web
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestBufferedHandler(t *testing.T) {
+	tests := []struct {
+		body     string
+		flush    bool
+		filtered bool
+	}{
+		{"", false, true},
+		{"hello", false, true},
+		{"0123456789", false, true},
+		{"0123456789a", false, false},
+		{"hello", true, false},
+	}
+	for _, tt := range tests {
+		filtered := false
+		h := BufferedHandler(10, func(req *Request, br *BufferedResponder) {
+			filtered = true
+			if br.Status != StatusOK || br.Body.String() != tt.body {
+				t.Errorf("%q: status=%d, body=%q", tt.body, br.Status, br.Body.String())
+			}
+			br.Header.Set("X-Filtered", "yes")
+			br.Status = StatusCreated
+		}, HandlerFunc(func(req *Request) {
+			w := req.Respond(StatusOK)
+			for i := 0; i < len(tt.body); i += 3 {
+				j := i + 3
+				if j > len(tt.body) {
+					j = len(tt.body)
+				}
+				io.WriteString(w, tt.body[i:j])
+			}
+			if tt.flush {
+				w.(Flusher).Flush()
+			}
+		}))
+		status, header, body := RunHandler("/", "GET", nil, nil, h)
+		if filtered != tt.filtered {
+			t.Errorf("%q: filtered=%v, want %v", tt.body, filtered, tt.filtered)
+		}
+		if string(body) != tt.body {
+			t.Errorf("%q: body=%q", tt.body, body)
+		}
+		if filtered && (status != StatusCreated || header.Get("X-Filtered") != "yes") {
+			t.Errorf("%q: status=%d, header=%v", tt.body, status, header)
+		}
+	}
+}
+
+func TestBufferedHandlerReplace(t *testing.T) {
+	h := BufferedHandler(100, func(req *Request, br *BufferedResponder) {
+		if strings.Contains(br.Body.String(), "secret") {
+			req.Error(StatusInternalServerError, errors.New("leak"))
+		}
+	}, HandlerFunc(func(req *Request) {
+		io.WriteString(req.Respond(StatusOK), "the secret is 42")
+	}))
+	status, _, body := RunHandler("/", "GET", nil, nil, h)
+	if status != StatusInternalServerError || string(body) != StatusText(StatusInternalServerError) {
+		t.Errorf("status=%d, body=%q", status, body)
+	}
+}
+
+func TestBufferedHandlerNoResponse(t *testing.T) {
+	h := BufferedHandler(100, func(req *Request, br *BufferedResponder) {
+		t.Error("filter called")
+	}, HandlerFunc(func(req *Request) {}))
+	if status, _, _ := RunHandler("/", "GET", nil, nil, h); status != 0 {
+		t.Errorf("status=%d", status)
+	}
+}
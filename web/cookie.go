@@ -16,71 +16,95 @@ package web
 
 import (
 	"bytes"
+	"errors"
 	"strconv"
+	"strings"
 	"time"
 )
 
 // parseCookieValues parses cookies from values and adds them to m. The
-// function supports the Netscape draft specification for cookies
-// (http://goo.gl/1WSx3). 
+// function parses the Cookie header as specified in RFC 6265. Quotes are
+// removed from quoted values. Pairs with an invalid name or value are ignored.
 func parseCookieValues(values []string, m Values) error {
 	for _, s := range values {
-		key := ""
-		begin := 0
-		end := 0
-		for i := 0; i < len(s); i++ {
-			switch s[i] {
-			case ' ', '\t':
-				// leading whitespace?
-				if begin == end {
-					begin = i + 1
-					end = begin
-				}
-			case '=':
-				if key == "" {
-					key = s[begin:end]
-					begin = i + 1
-					end = begin
-				} else {
-					end += 1
-				}
-			case ';':
-				if len(key) > 0 && begin < end {
-					value := s[begin:end]
-					m.Add(key, value)
-				}
-				key = ""
-				begin = i + 1
-				end = begin
-			default:
-				end = i + 1
+		for _, pair := range strings.Split(s, ";") {
+			pair = strings.Trim(pair, " \t")
+			i := strings.Index(pair, "=")
+			if i <= 0 {
+				continue
 			}
-		}
-		if len(key) > 0 && begin < end {
-			m.Add(key, s[begin:end])
+			name, value := pair[:i], pair[i+1:]
+			if !isCookieName(name) {
+				continue
+			}
+			if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+				value = value[1 : len(value)-1]
+			}
+			if !isCookieValue(value) {
+				continue
+			}
+			m.Add(name, value)
 		}
 	}
 	return nil
 }
 
-// Cookie is a helper for constructing Set-Cookie header values. 
-// 
-// Cookie supports the ancient Netscape draft specification for cookies
-// (http://goo.gl/1WSx3) and the modern HttpOnly attribute
-// (http://www.owasp.org/index.php/HttpOnly). Cookie does not attempt to
-// support any RFC for cookies because the RFCs are not supported by popular
-// browsers.
+// isCookieName returns true if s is an RFC 6265 cookie name.
+func isCookieName(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if !isToken[s[i]] {
+			return false
+		}
+	}
+	return true
+}
+
+// isCookieValue returns true if all bytes in s are RFC 6265 cookie-octets.
+func isCookieValue(s string) bool {
+	for i := 0; i < len(s); i++ {
+		b := s[i]
+		if b <= ' ' || b >= 0x7f || b == '"' || b == ',' || b == ';' || b == '\\' {
+			return false
+		}
+	}
+	return true
+}
+
+// SameSite is the value of the Set-Cookie SameSite attribute.
+type SameSite int
+
+const (
+	SameSiteDefault SameSite = iota // the attribute is not included
+	SameSiteLax
+	SameSiteStrict
+	SameSiteNone
+)
+
+var sameSiteNames = []string{"", "Lax", "Strict", "None"}
+
+// Cookie is a helper for constructing Set-Cookie header values.
+//
+// Cookie supports the attributes specified in RFC 6265, the HttpOnly
+// attribute, the SameSite attribute and the Partitioned attribute. The
+// Validate method checks the requirements for the __Secure- and __Host- cookie
+// name prefixes.
 //
 // As a convenience, the NewCookie function returns a cookie with the path
 // attribute set to "/" and the httponly attribute set to true. 
 type Cookie struct {
-	name     string
-	value    string
-	path     string
-	domain   string
-	maxAge   time.Duration
-	secure   bool
-	httpOnly bool
+	name        string
+	value       string
+	path        string
+	domain      string
+	maxAge      time.Duration
+	expires     time.Time
+	secure      bool
+	httpOnly    bool
+	sameSite    SameSite
+	partitioned bool
 }
 
 // NewCookie returns a new cookie with the given name and value, the path
@@ -104,8 +128,15 @@ func (c *Cookie) Domain(domain string) *Cookie { c.domain = domain; return c }
 // maximum age is also rendered as an absolute expiration time.
 func (c *Cookie) MaxAge(maxAge time.Duration) *Cookie { c.maxAge = maxAge; return c }
 
+// Expires sets the expires attribute to an absolute time. If the time is not
+// the zero time, then the time is used for the expires attribute instead of
+// the time computed from the maximum age.
+func (c *Cookie) Expires(expires time.Time) *Cookie { c.expires = expires; return c }
+
 // Delete sets the expiration date to a time in the past. 
-func (c *Cookie) Delete() *Cookie { return c.MaxAge(-30 * 24 * time.Hour).HTTPOnly(false) }
+func (c *Cookie) Delete() *Cookie {
+	return c.MaxAge(-30 * 24 * time.Hour).Expires(time.Time{}).HTTPOnly(false)
+}
 
 // Secure sets the secure attribute. 
 func (c *Cookie) Secure(secure bool) *Cookie { c.secure = secure; return c }
@@ -117,6 +148,35 @@ func (c *Cookie) HTTPOnly(httpOnly bool) *Cookie {
 	return c
 }
 
+// SameSite sets the SameSite attribute. Browsers require the secure attribute
+// for SameSiteNone.
+func (c *Cookie) SameSite(sameSite SameSite) *Cookie { c.sameSite = sameSite; return c }
+
+// Partitioned sets the Partitioned attribute. Browsers require the secure
+// attribute for partitioned cookies.
+func (c *Cookie) Partitioned(partitioned bool) *Cookie { c.partitioned = partitioned; return c }
+
+// Validate returns an error if the cookie name or value contains invalid
+// characters or if the attributes do not meet the requirements for the cookie
+// name prefix and the SameSite and Partitioned attributes.
+func (c *Cookie) Validate() error {
+	switch {
+	case !isCookieName(c.name):
+		return errors.New("twister: invalid cookie name " + strconv.Quote(c.name))
+	case !isCookieValue(c.value):
+		return errors.New("twister: invalid value for cookie " + c.name)
+	case strings.HasPrefix(c.name, "__Secure-") && !c.secure:
+		return errors.New("twister: __Secure- cookie " + c.name + " requires secure attribute")
+	case strings.HasPrefix(c.name, "__Host-") && (!c.secure || c.path != "/" || c.domain != ""):
+		return errors.New("twister: __Host- cookie " + c.name + " requires secure attribute, path / and no domain")
+	case c.sameSite == SameSiteNone && !c.secure:
+		return errors.New("twister: SameSite=None cookie " + c.name + " requires secure attribute")
+	case c.partitioned && !c.secure:
+		return errors.New("twister: partitioned cookie " + c.name + " requires secure attribute")
+	}
+	return nil
+}
+
 // String renders the Set-Cookie header value as a string.
 func (c *Cookie) String() string {
 	var buf bytes.Buffer
@@ -138,6 +198,12 @@ func (c *Cookie) String() string {
 	if c.maxAge != 0 {
 		buf.WriteString("; max-age=")
 		buf.WriteString(strconv.Itoa(int(c.maxAge / time.Second)))
+	}
+
+	if !c.expires.IsZero() {
+		buf.WriteString("; expires=")
+		buf.WriteString(c.expires.UTC().Format(timeLayout))
+	} else if c.maxAge != 0 {
 		buf.WriteString("; expires=")
 		buf.WriteString(formatExpiration(c.maxAge))
 	}
@@ -150,5 +216,45 @@ func (c *Cookie) String() string {
 		buf.WriteString("; HttpOnly")
 	}
 
+	if c.sameSite != SameSiteDefault {
+		buf.WriteString("; SameSite=")
+		buf.WriteString(sameSiteNames[c.sameSite])
+	}
+
+	if c.partitioned {
+		buf.WriteString("; Partitioned")
+	}
+
 	return buf.String()
 }
+
+const cookiesEnvKey = "twister.web.cookies"
+
+// SetCookie adds a Set-Cookie header for c to the response. The cookies
+// added with SetCookie are collected in the request Env and added to the
+// response header when the response is started, so middleware and handlers
+// can set cookies without replacing cookies set by others. A cookie set with
+// SetCookie replaces an earlier cookie with the same name, path and domain.
+// SetCookie returns the error from c.Validate().
+func SetCookie(req *Request, c *Cookie) error {
+	if err := c.Validate(); err != nil {
+		return err
+	}
+	cookies, ok := req.Env[cookiesEnvKey].([]*Cookie)
+	if !ok {
+		FilterRespond(req, func(status int, header Header) (int, Header) {
+			for _, c := range req.Env[cookiesEnvKey].([]*Cookie) {
+				header.Add(HeaderSetCookie, c.String())
+			}
+			return status, header
+		})
+	}
+	for i, other := range cookies {
+		if other.name == c.name && other.path == c.path && other.domain == c.domain {
+			cookies = append(cookies[:i], cookies[i+1:]...)
+			break
+		}
+	}
+	req.Env[cookiesEnvKey] = append(cookies, c)
+	return nil
+}
@@ -16,7 +16,9 @@ package web
 
 import (
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 )
 
 var ParseCookieValuesTests = []struct {
@@ -33,6 +35,10 @@ var ParseCookieValuesTests = []struct {
 	{[]string{" a=b;c=d "}, Values{"a": []string{"b"}, "c": []string{"d"}}},
 	{[]string{"a=b", "c=d"}, Values{"a": []string{"b"}, "c": []string{"d"}}},
 	{[]string{"a=b", "c=x=y"}, Values{"a": []string{"b"}, "c": []string{"x=y"}}},
+	{[]string{`a="b"; c=""`}, Values{"a": []string{"b"}, "c": []string{""}}},
+	{[]string{`a=b c; d="e; f=g,h; i=\j; k=l`}, Values{"k": []string{"l"}}},
+	{[]string{"a b=c; d@=e; f=g"}, Values{"f": []string{"g"}}},
+	{[]string{"a=\x80; b=c"}, Values{"b": []string{"c"}}},
 }
 
 func TestParseCookieValues(t *testing.T) {
@@ -46,3 +52,64 @@ func TestParseCookieValues(t *testing.T) {
 		}
 	}
 }
+
+var cookieStringTests = []struct {
+	c      *Cookie
+	expect string
+}{
+	{NewCookie("a", "b"), "a=b; path=/; HttpOnly"},
+	{NewCookie("a", "b").Path("").HTTPOnly(false).Domain("example.com").Secure(true), "a=b; domain=example.com; secure"},
+	{NewCookie("a", "b").SameSite(SameSiteStrict), "a=b; path=/; HttpOnly; SameSite=Strict"},
+	{NewCookie("a", "b").SameSite(SameSiteNone).Secure(true).Partitioned(true), "a=b; path=/; secure; HttpOnly; SameSite=None; Partitioned"},
+	{NewCookie("a", "b").Expires(time.Date(2011, 11, 12, 13, 14, 15, 0, time.UTC)), "a=b; path=/; expires=Sat, 12 Nov 2011 13:14:15 GMT; HttpOnly"},
+	{NewCookie("a", "b").MaxAge(time.Hour).Expires(time.Date(2011, 11, 12, 13, 14, 15, 0, time.UTC)), "a=b; path=/; max-age=3600; expires=Sat, 12 Nov 2011 13:14:15 GMT; HttpOnly"},
+}
+
+func TestCookieString(t *testing.T) {
+	for _, tt := range cookieStringTests {
+		if s := tt.c.String(); s != tt.expect {
+			t.Errorf("String() = %q, want %q", s, tt.expect)
+		}
+	}
+}
+
+var cookieValidateTests = []struct {
+	c  *Cookie
+	ok bool
+}{
+	{NewCookie("a", "b"), true},
+	{NewCookie("a b", "c"), false},
+	{NewCookie("a", "b;c"), false},
+	{NewCookie("__Secure-a", "b"), false},
+	{NewCookie("__Secure-a", "b").Secure(true).Domain("example.com"), true},
+	{NewCookie("__Host-a", "b").Secure(true), true},
+	{NewCookie("__Host-a", "b").Secure(true).Path("/x"), false},
+	{NewCookie("__Host-a", "b").Secure(true).Domain("example.com"), false},
+	{NewCookie("a", "b").SameSite(SameSiteNone), false},
+	{NewCookie("a", "b").Partitioned(true), false},
+}
+
+func TestCookieValidate(t *testing.T) {
+	for _, tt := range cookieValidateTests {
+		if err := tt.c.Validate(); (err == nil) != tt.ok {
+			t.Errorf("%q.Validate() = %v, want ok=%v", tt.c.String(), err, tt.ok)
+		}
+	}
+}
+
+func TestSetCookie(t *testing.T) {
+	h := HandlerFunc(func(req *Request) {
+		SetCookie(req, NewCookie("a", "1"))
+		SetCookie(req, NewCookie("b", "2"))
+		SetCookie(req, NewCookie("a", "3"))
+		if err := SetCookie(req, NewCookie("__Host-c", "4")); err == nil {
+			t.Error("SetCookie accepted invalid cookie")
+		}
+		req.Respond(StatusOK, HeaderSetCookie, "c=5")
+	})
+	_, header, _ := RunHandler("/", "GET", nil, nil, h)
+	expect := []string{"c=5", "b=2; path=/; HttpOnly", "a=3; path=/; HttpOnly"}
+	if actual := header[HeaderSetCookie]; strings.Join(actual, "|") != strings.Join(expect, "|") {
+		t.Errorf("Set-Cookie = %q, want %q", actual, expect)
+	}
+}
@@ -0,0 +1,212 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package web
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CORSOptions specifies the options for CORSHandler.
+type CORSOptions struct {
+	// AllowedOrigins is a list of origins allowed to make cross-origin
+	// requests. An origin has the form "https://example.com". The entry
+	// "https://*.example.com" allows all subdomains of example.com. The entry
+	// "*" allows all origins and cannot be used with AllowCredentials.
+	AllowedOrigins []string
+
+	// AllowOrigin returns true if the origin is allowed. AllowOrigin is called
+	// for origins not in AllowedOrigins.
+	AllowOrigin func(origin string) bool
+
+	// AllowedMethods is the list of methods allowed in preflight requests. If
+	// AllowedMethods is nil and the handler implements MethodLister, then
+	// the methods supported by the handler for the request path are
+	// allowed. Otherwise, if AllowedMethods is nil, then GET, HEAD and POST
+	// are allowed.
+	AllowedMethods []string
+
+	// AllowedHeaders is the list of request headers allowed in preflight
+	// requests. The entry "*" allows all requested headers.
+	AllowedHeaders []string
+
+	// ExposedHeaders is the list of response headers that browsers expose to
+	// scripts.
+	ExposedHeaders []string
+
+	// AllowCredentials allows requests with cookies and HTTP authentication.
+	// CORSHandler panics if AllowCredentials is set with the "*" origin
+	// because any site could then read responses with the user's
+	// credentials.
+	AllowCredentials bool
+
+	// MaxAge is the time that browsers can cache the preflight response. If
+	// zero, then the Access-Control-Max-Age header is not sent.
+	MaxAge time.Duration
+}
+
+// CORSHandler returns a handler that implements cross-origin resource sharing
+// for h.
+//
+// The handler responds to preflight requests, OPTIONS requests with the Origin
+// and Access-Control-Request-Method headers, without calling h. Preflight
+// requests from origins that are not allowed are rejected with HTTP status
+// 403. For other requests from allowed origins, the handler adds the CORS
+// response headers when h responds.
+//
+// Use CORSHandler with a Router to allow the methods registered for each
+// path:
+//
+//  h := web.CORSHandler(&web.CORSOptions{AllowedOrigins: []string{"https://app.example.com"}}, router)
+func CORSHandler(options *CORSOptions, h Handler) Handler {
+	ch := &corsHandler{options: options, h: h}
+	for _, origin := range options.AllowedOrigins {
+		if origin == "*" {
+			ch.allowAll = true
+		}
+	}
+	if ch.allowAll && options.AllowCredentials {
+		panic("twister: CORSHandler does not allow credentials with the \"*\" origin")
+	}
+	ch.methodLister, _ = h.(MethodLister)
+	return ch
+}
+
+type corsHandler struct {
+	options      *CORSOptions
+	h            Handler
+	allowAll     bool
+	methodLister MethodLister
+}
+
+var defaultCORSMethods = []string{"GET", "HEAD", "POST"}
+
+func (h *corsHandler) allowed(origin string) bool {
+	if h.allowAll {
+		return true
+	}
+	for _, o := range h.options.AllowedOrigins {
+		if strings.EqualFold(o, origin) {
+			return true
+		}
+		if i := strings.Index(o, "://*."); i >= 0 {
+			scheme := o[:i+3]
+			suffix := o[i+4:]
+			if len(origin) > len(scheme)+len(suffix) &&
+				strings.EqualFold(origin[:len(scheme)], scheme) &&
+				strings.EqualFold(origin[len(origin)-len(suffix):], suffix) &&
+				!strings.ContainsAny(origin[len(scheme):len(origin)-len(suffix)], "/:@") {
+				return true
+			}
+		}
+	}
+	return h.options.AllowOrigin != nil && h.options.AllowOrigin(origin)
+}
+
+// setOrigin sets the Access-Control-Allow-Origin and credential headers.
+func (h *corsHandler) setOrigin(header Header, origin string) {
+	if h.allowAll {
+		header.Set(HeaderAccessControlAllowOrigin, "*")
+	} else {
+		header.Set(HeaderAccessControlAllowOrigin, origin)
+	}
+	if h.options.AllowCredentials {
+		header.Set(HeaderAccessControlAllowCredentials, "true")
+	}
+}
+
+// varyOrigin returns true if the response depends on the Origin header.
+func (h *corsHandler) varyOrigin() bool {
+	return !h.allowAll
+}
+
+func (h *corsHandler) ServeWeb(req *Request) {
+	origin := req.Header.Get(HeaderOrigin)
+	if origin != "" && req.Method == "OPTIONS" && req.Header.Get(HeaderAccessControlRequestMethod) != "" {
+		h.preflight(req, origin)
+		return
+	}
+	allowed := origin != "" && h.allowed(origin)
+	if allowed || h.varyOrigin() {
+		FilterRespond(req, func(status int, header Header) (int, Header) {
+			if h.varyOrigin() {
+				addVary(header, HeaderOrigin)
+			}
+			if allowed {
+				h.setOrigin(header, origin)
+				if len(h.options.ExposedHeaders) > 0 {
+					header.Set(HeaderAccessControlExposeHeaders, strings.Join(h.options.ExposedHeaders, ", "))
+				}
+			}
+			return status, header
+		})
+	}
+	h.h.ServeWeb(req)
+}
+
+func (h *corsHandler) preflight(req *Request, origin string) {
+	header := NewHeader()
+	if h.varyOrigin() {
+		addVary(header, HeaderOrigin)
+	}
+	addVary(header, HeaderAccessControlRequestMethod)
+	addVary(header, HeaderAccessControlRequestHeaders)
+
+	if !h.allowed(origin) {
+		req.ErrorHandler(req, StatusForbidden, nil, header)
+		return
+	}
+
+	methods := h.options.AllowedMethods
+	if methods == nil {
+		if h.methodLister != nil {
+			methods = h.methodLister.Methods(req.URL.Path)
+			if methods == nil {
+				req.ErrorHandler(req, StatusNotFound, nil, header)
+				return
+			}
+			for _, m := range methods {
+				if m == "*" {
+					methods = []string{req.Header.Get(HeaderAccessControlRequestMethod)}
+					break
+				}
+			}
+		} else {
+			methods = defaultCORSMethods
+		}
+	}
+
+	h.setOrigin(header, origin)
+	header.Set(HeaderAccessControlAllowMethods, strings.Join(methods, ", "))
+	if requested := req.Header.GetList(HeaderAccessControlRequestHeaders); len(requested) > 0 {
+		var allowed []string
+		for _, name := range requested {
+			for _, a := range h.options.AllowedHeaders {
+				if a == "*" || strings.EqualFold(a, name) {
+					allowed = append(allowed, name)
+					break
+				}
+			}
+		}
+		if len(allowed) > 0 {
+			header.Set(HeaderAccessControlAllowHeaders, strings.Join(allowed, ", "))
+		}
+	}
+	if h.options.MaxAge > 0 {
+		header.Set(HeaderAccessControlMaxAge, strconv.Itoa(int(h.options.MaxAge/time.Second)))
+	}
+	req.Responder.Respond(StatusNoContent, header)
+}
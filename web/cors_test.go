@@ -0,0 +1,126 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package web
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+var corsTestHandler = HandlerFunc(func(req *Request) { req.Respond(StatusOK) })
+
+var corsTests = []struct {
+	method string
+	path   string
+	header Header
+	status int
+	expect Header
+}{
+	// Same origin request.
+	{"GET", "/items", nil, StatusOK,
+		NewHeader(HeaderVary, "Origin")},
+	// Allowed origins.
+	{"GET", "/items", NewHeader(HeaderOrigin, "https://app.example.com"), StatusOK,
+		NewHeader(HeaderVary, "Origin", HeaderAccessControlAllowOrigin, "https://app.example.com",
+			HeaderAccessControlAllowCredentials, "true", HeaderAccessControlExposeHeaders, "X-Total")},
+	{"GET", "/items", NewHeader(HeaderOrigin, "https://a.b.example.org"), StatusOK,
+		NewHeader(HeaderVary, "Origin", HeaderAccessControlAllowOrigin, "https://a.b.example.org",
+			HeaderAccessControlAllowCredentials, "true", HeaderAccessControlExposeHeaders, "X-Total")},
+	{"GET", "/items", NewHeader(HeaderOrigin, "http://localhost:8080"), StatusOK,
+		NewHeader(HeaderVary, "Origin", HeaderAccessControlAllowOrigin, "http://localhost:8080",
+			HeaderAccessControlAllowCredentials, "true", HeaderAccessControlExposeHeaders, "X-Total")},
+	// Origins not allowed.
+	{"GET", "/items", NewHeader(HeaderOrigin, "https://evil.com"), StatusOK,
+		NewHeader(HeaderVary, "Origin")},
+	{"GET", "/items", NewHeader(HeaderOrigin, "https://example.org"), StatusOK,
+		NewHeader(HeaderVary, "Origin")},
+	{"GET", "/items", NewHeader(HeaderOrigin, "http://a.example.org"), StatusOK,
+		NewHeader(HeaderVary, "Origin")},
+	// Preflight with methods from router.
+	{"OPTIONS", "/items", NewHeader(HeaderOrigin, "https://app.example.com",
+		HeaderAccessControlRequestMethod, "POST",
+		HeaderAccessControlRequestHeaders, "content-type, x-secret"), StatusNoContent,
+		NewHeader(HeaderVary, "Origin, Access-Control-Request-Method, Access-Control-Request-Headers",
+			HeaderAccessControlAllowOrigin, "https://app.example.com",
+			HeaderAccessControlAllowCredentials, "true",
+			HeaderAccessControlAllowMethods, "GET, HEAD, POST",
+			HeaderAccessControlAllowHeaders, "content-type",
+			HeaderAccessControlMaxAge, "600")},
+	{"OPTIONS", "/missing", NewHeader(HeaderOrigin, "https://app.example.com",
+		HeaderAccessControlRequestMethod, "POST"), StatusNotFound,
+		NewHeader(HeaderVary, "Origin, Access-Control-Request-Method, Access-Control-Request-Headers")},
+	{"OPTIONS", "/items", NewHeader(HeaderOrigin, "https://evil.com",
+		HeaderAccessControlRequestMethod, "POST"), StatusForbidden,
+		NewHeader(HeaderVary, "Origin, Access-Control-Request-Method, Access-Control-Request-Headers")},
+	// OPTIONS request that is not a preflight request.
+	{"OPTIONS", "/items", NewHeader(HeaderOrigin, "https://app.example.com"), StatusMethodNotAllowed,
+		NewHeader(HeaderVary, "Origin", HeaderAccessControlAllowOrigin, "https://app.example.com",
+			HeaderAccessControlAllowCredentials, "true", HeaderAccessControlExposeHeaders, "X-Total")},
+}
+
+func TestCORSHandler(t *testing.T) {
+	router := NewRouter().Register("/items", "GET", corsTestHandler, "POST", corsTestHandler)
+	h := CORSHandler(&CORSOptions{
+		AllowedOrigins:   []string{"https://app.example.com", "https://*.example.org"},
+		AllowOrigin:      func(origin string) bool { return origin == "http://localhost:8080" },
+		AllowedHeaders:   []string{"Content-Type"},
+		ExposedHeaders:   []string{"X-Total"},
+		AllowCredentials: true,
+		MaxAge:           10 * time.Minute,
+	}, router)
+	for _, tt := range corsTests {
+		status, header, _ := RunHandler("http://example.com"+tt.path, tt.method, tt.header, nil, h)
+		if status != tt.status {
+			t.Errorf("%s %s %v status=%d, want %d", tt.method, tt.path, tt.header, status, tt.status)
+		}
+		for name := range header {
+			if name != HeaderVary && !strings.HasPrefix(name, "Access-Control") {
+				delete(header, name)
+			}
+		}
+		if strings.Join(header[HeaderVary], ", ") != tt.expect.Get(HeaderVary) || len(header) != len(tt.expect) {
+			t.Errorf("%s %s %v header=%v, want %v", tt.method, tt.path, tt.header, header, tt.expect)
+			continue
+		}
+		for name := range tt.expect {
+			if name != HeaderVary && header.Get(name) != tt.expect.Get(name) {
+				t.Errorf("%s %s %v header=%v, want %v", tt.method, tt.path, tt.header, header, tt.expect)
+				break
+			}
+		}
+	}
+}
+
+func TestCORSHandlerAllowAll(t *testing.T) {
+	h := CORSHandler(&CORSOptions{AllowedOrigins: []string{"*"}}, corsTestHandler)
+	_, header, _ := RunHandler("http://example.com/", "GET", NewHeader(HeaderOrigin, "https://any.com"), nil, h)
+	if header.Get(HeaderAccessControlAllowOrigin) != "*" || header.Get(HeaderVary) != "" {
+		t.Errorf("header=%v", header)
+	}
+	status, header, _ := RunHandler("http://example.com/", "OPTIONS", NewHeader(HeaderOrigin, "https://any.com", HeaderAccessControlRequestMethod, "PUT"), nil, h)
+	if status != StatusNoContent || header.Get(HeaderAccessControlAllowMethods) != "GET, HEAD, POST" {
+		t.Errorf("preflight status=%d, header=%v", status, header)
+	}
+}
+
+func TestCORSHandlerAllowAllCredentials(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("CORSHandler did not panic on \"*\" origin with credentials")
+		}
+	}()
+	CORSHandler(&CORSOptions{AllowedOrigins: []string{"*"}, AllowCredentials: true}, corsTestHandler)
+}
@@ -0,0 +1,268 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package web
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net/url"
+	"strings"
+)
+
+// CSRFOptions specifies the options for CSRFHandler.
+type CSRFOptions struct {
+	// Secret used to sign tokens. The secret is required.
+	Secret string
+
+	// Name of the cookie holding the random seed for tokens. If "", then
+	// "csrf" is used.
+	CookieName string
+
+	// Name of the request parameter and header for submitting tokens. If "",
+	// then "csrf" and HeaderXCSRFToken are used.
+	ParamName  string
+	HeaderName string
+
+	// Secure attribute of the cookie.
+	Secure bool
+
+	// SessionID returns the session identifier for the request. If set,
+	// tokens are bound to the session and tokens issued for one session are
+	// not accepted in another session. Tokens become invalid when the session
+	// identifier changes.
+	SessionID func(req *Request) string
+
+	// TrustedOrigins is a list of origins, in the form "https://example.com",
+	// that are allowed in addition to the origin of the request URL.
+	TrustedOrigins []string
+
+	// ExemptPaths is a list of request paths that are not checked. A path
+	// ending with '/' exempts all paths with that prefix.
+	ExemptPaths []string
+
+	// Exempt returns true if the request should not be checked.
+	Exempt func(req *Request) bool
+}
+
+var (
+	errCSRFMissingToken  = errors.New("twister: CSRF token missing")
+	errCSRFBadToken      = errors.New("twister: CSRF token invalid")
+	errCSRFMissingCookie = errors.New("twister: CSRF cookie missing")
+	errCSRFBadOrigin     = errors.New("twister: CSRF origin does not match")
+	errCSRFBadReferer    = errors.New("twister: CSRF referer does not match")
+	errCSRFNoReferer     = errors.New("twister: CSRF referer missing")
+)
+
+// CSRFHandler returns a handler that implements cross-site request forgery
+// protection.
+//
+// The handler sets a cookie to a random seed. Tokens are HMAC-SHA256
+// signatures of the seed, the session identifier and an optional action.
+// Requests with methods other than GET, HEAD, OPTIONS and TRACE must include
+// a token in the request parameter or header. An attacker cannot create a
+// valid token because the attacker cannot read the cookie or compute the
+// signature without the secret.
+//
+// The handler also checks that the Origin header, or the Referer header when
+// the Origin header is missing, matches the origin of the request URL or a
+// trusted origin. HTTPS requests without an Origin or Referer header are
+// rejected.
+//
+// Requests that fail the checks are rejected with HTTP status 403. The error
+// passed to the request error handler describes the failure.
+//
+// Use CSRFToken and CSRFActionToken to get tokens for forms and requests from
+// scripts:
+//
+//  <form method="POST" action="/items">
+//  <input type="hidden" name="csrf" value="{{.CSRFToken}}">
+//
+// The handler reads the token from the parsed form. Install CSRFHandler
+// inside FormHandler:
+//
+//  h = web.FormHandler(10000, false, web.CSRFHandler(&options, h))
+func CSRFHandler(options *CSRFOptions, h Handler) Handler {
+	if options.Secret == "" {
+		panic("twister: CSRFHandler requires a secret")
+	}
+	return &csrfHandler{options: options, h: h}
+}
+
+type csrfHandler struct {
+	options *CSRFOptions
+	h       Handler
+}
+
+// csrfState is stored in the request Env for use by CSRFToken.
+type csrfState struct {
+	options *CSRFOptions
+	seed    string
+}
+
+const csrfEnvKey = "twister.web.csrf"
+
+const csrfSeedLen = 32
+
+func (h *csrfHandler) cookieName() string {
+	if h.options.CookieName == "" {
+		return "csrf"
+	}
+	return h.options.CookieName
+}
+
+func (h *csrfHandler) ServeWeb(req *Request) {
+	seed := req.Cookie.Get(h.cookieName())
+	if p, err := base64.RawURLEncoding.DecodeString(seed); err != nil || len(p) != csrfSeedLen {
+		seed = ""
+	}
+	newSeed := seed == ""
+	if newSeed {
+		p := make([]byte, csrfSeedLen)
+		if _, err := rand.Read(p); err != nil {
+			req.Error(StatusInternalServerError, err)
+			return
+		}
+		seed = base64.RawURLEncoding.EncodeToString(p)
+		c := NewCookie(h.cookieName(), seed).Secure(h.options.Secure).SameSite(SameSiteLax)
+		if err := SetCookie(req, c); err != nil {
+			req.Error(StatusInternalServerError, err)
+			return
+		}
+	}
+	state := &csrfState{options: h.options, seed: seed}
+	req.Env[csrfEnvKey] = state
+
+	if err := h.check(req, state, newSeed); err != nil {
+		req.Error(StatusForbidden, err)
+		return
+	}
+	h.h.ServeWeb(req)
+}
+
+func (h *csrfHandler) exempt(req *Request) bool {
+	switch req.Method {
+	case "GET", "HEAD", "OPTIONS", "TRACE":
+		return true
+	}
+	for _, p := range h.options.ExemptPaths {
+		if req.URL.Path == p || (strings.HasSuffix(p, "/") && strings.HasPrefix(req.URL.Path, p)) {
+			return true
+		}
+	}
+	return h.options.Exempt != nil && h.options.Exempt(req)
+}
+
+func (h *csrfHandler) check(req *Request, state *csrfState, newSeed bool) error {
+	if h.exempt(req) {
+		return nil
+	}
+	if err := h.checkOrigin(req); err != nil {
+		return err
+	}
+	if newSeed {
+		return errCSRFMissingCookie
+	}
+	headerName := h.options.HeaderName
+	if headerName == "" {
+		headerName = HeaderXCSRFToken
+	}
+	token := req.Header.Get(headerName)
+	if token == "" {
+		paramName := h.options.ParamName
+		if paramName == "" {
+			paramName = "csrf"
+		}
+		token = req.Param.Get(paramName)
+	}
+	if token == "" {
+		return errCSRFMissingToken
+	}
+	if hmac.Equal([]byte(token), []byte(state.token(req, ""))) ||
+		hmac.Equal([]byte(token), []byte(state.token(req, req.Method+" "+req.URL.Path))) {
+		return nil
+	}
+	return errCSRFBadToken
+}
+
+func (h *csrfHandler) checkOrigin(req *Request) error {
+	origin := req.Header.Get(HeaderOrigin)
+	mismatch := errCSRFBadOrigin
+	if origin == "" {
+		referer := req.Header.Get(HeaderReferer)
+		if referer == "" {
+			if req.URL.Scheme == "https" {
+				return errCSRFNoReferer
+			}
+			return nil
+		}
+		u, err := url.Parse(referer)
+		if err != nil {
+			return errCSRFBadReferer
+		}
+		origin = u.Scheme + "://" + u.Host
+		mismatch = errCSRFBadReferer
+	}
+	if strings.EqualFold(origin, req.URL.Scheme+"://"+req.URL.Host) {
+		return nil
+	}
+	for _, trusted := range h.options.TrustedOrigins {
+		if strings.EqualFold(origin, trusted) {
+			return nil
+		}
+	}
+	return mismatch
+}
+
+// token returns the token for the action.
+func (state *csrfState) token(req *Request, action string) string {
+	sessionID := ""
+	if state.options.SessionID != nil {
+		sessionID = state.options.SessionID(req)
+	}
+	hm := hmac.New(sha256.New, []byte(state.options.Secret))
+	io.WriteString(hm, state.seed)
+	hm.Write([]byte{0})
+	io.WriteString(hm, sessionID)
+	hm.Write([]byte{0})
+	io.WriteString(hm, action)
+	return base64.RawURLEncoding.EncodeToString(hm.Sum(nil))
+}
+
+func csrfStateFor(req *Request) *csrfState {
+	state, ok := req.Env[csrfEnvKey].(*csrfState)
+	if !ok {
+		panic("twister: CSRFHandler not installed")
+	}
+	return state
+}
+
+// CSRFToken returns a token that is valid for any request in the current
+// session. The function panics if the request was not dispatched through
+// CSRFHandler.
+func CSRFToken(req *Request) string {
+	return csrfStateFor(req).token(req, "")
+}
+
+// CSRFActionToken returns a token that is only valid for requests with the
+// given method and path. Use per-action tokens to limit the damage from a
+// leaked token. The function panics if the request was not dispatched through
+// CSRFHandler.
+func CSRFActionToken(req *Request, method, path string) string {
+	return csrfStateFor(req).token(req, method+" "+path)
+}
@@ -0,0 +1,82 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package web
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+const testCSRFSeed = "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY"
+
+func TestCSRFHandler(t *testing.T) {
+	options := &CSRFOptions{
+		Secret:         "secret",
+		SessionID:      func(req *Request) string { return req.Cookie.Get("sid") },
+		TrustedOrigins: []string{"https://trusted.example.com"},
+		ExemptPaths:    []string{"/hooks/"},
+	}
+	h := CSRFHandler(options, HandlerFunc(func(req *Request) {
+		w := req.Respond(StatusOK)
+		switch req.URL.Path {
+		case "/token":
+			io.WriteString(w, CSRFToken(req))
+		case "/action":
+			io.WriteString(w, CSRFActionToken(req, "POST", "/items"))
+		}
+	}))
+
+	status, header, body := RunHandler("https://example.com/token", "GET", NewHeader(HeaderCookie, "sid=1"), nil, h)
+	cookie := header.Get(HeaderSetCookie)
+	if status != StatusOK || !strings.HasPrefix(cookie, "csrf=") || !strings.Contains(cookie, "SameSite=Lax") {
+		t.Fatalf("GET status=%d, cookie=%q", status, cookie)
+	}
+	seed := cookie[len("csrf="):strings.Index(cookie, ";")]
+	token := string(body)
+
+	_, _, body = RunHandler("https://example.com/action", "GET", NewHeader(HeaderCookie, "sid=1; csrf="+seed), nil, h)
+	actionToken := string(body)
+
+	tests := []struct {
+		url    string
+		header Header
+		status int
+	}{
+		{"https://example.com/items", NewHeader(HeaderCookie, "sid=1; csrf="+seed, HeaderXCSRFToken, token, HeaderOrigin, "https://example.com"), StatusOK},
+		{"https://example.com/items?csrf=" + token, NewHeader(HeaderCookie, "sid=1; csrf="+seed, HeaderReferer, "https://example.com/form"), StatusOK},
+		{"https://example.com/items", NewHeader(HeaderCookie, "sid=1; csrf="+seed, HeaderXCSRFToken, token, HeaderOrigin, "https://trusted.example.com"), StatusOK},
+		{"https://example.com/items", NewHeader(HeaderCookie, "sid=1; csrf="+seed, HeaderXCSRFToken, actionToken, HeaderOrigin, "https://example.com"), StatusOK},
+		{"https://example.com/other", NewHeader(HeaderCookie, "sid=1; csrf="+seed, HeaderXCSRFToken, actionToken, HeaderOrigin, "https://example.com"), StatusForbidden},
+		{"https://example.com/items", NewHeader(HeaderCookie, "sid=2; csrf="+seed, HeaderXCSRFToken, token, HeaderOrigin, "https://example.com"), StatusForbidden},
+		{"https://example.com/items", NewHeader(HeaderCookie, "sid=1; csrf="+seed, HeaderOrigin, "https://example.com"), StatusForbidden},
+		{"https://example.com/items", NewHeader(HeaderCookie, "sid=1", HeaderXCSRFToken, token, HeaderOrigin, "https://example.com"), StatusForbidden},
+		{"https://example.com/items", NewHeader(HeaderCookie, "sid=1; csrf="+seed, HeaderXCSRFToken, token, HeaderOrigin, "https://evil.example.com"), StatusForbidden},
+		{"https://example.com/items", NewHeader(HeaderCookie, "sid=1; csrf="+seed, HeaderXCSRFToken, token, HeaderReferer, "https://evil.example.com/"), StatusForbidden},
+		{"https://example.com/items", NewHeader(HeaderCookie, "sid=1; csrf="+seed, HeaderXCSRFToken, token), StatusForbidden},
+		{"http://example.com/items", NewHeader(HeaderCookie, "sid=1; csrf="+seed, HeaderXCSRFToken, token), StatusOK},
+		{"https://example.com/hooks/github", nil, StatusOK},
+	}
+	for i, tt := range tests {
+		status, _, _ := RunHandler(tt.url, "POST", tt.header, nil, h)
+		if status != tt.status {
+			t.Errorf("test %d: POST %s status=%d, want %d", i, tt.url, status, tt.status)
+		}
+	}
+
+	if _, header, _ := RunHandler("https://example.com/", "GET", NewHeader(HeaderCookie, "csrf="+testCSRFSeed), nil, h); header.Get(HeaderSetCookie) != "" {
+		t.Error("cookie set when request has valid seed")
+	}
+}
@@ -0,0 +1,101 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package web
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"strconv"
+	"strings"
+)
+
+// opaqueTag returns the entity tag without the weak indicator and quotes.
+func opaqueTag(etag string) string {
+	return UnquoteHeaderValue(strings.TrimPrefix(strings.TrimSpace(etag), "W/"))
+}
+
+// MatchIfNoneMatch returns true if the If-None-Match header in the request
+// header matches etag using the weak comparison function from RFC 7232.
+func MatchIfNoneMatch(header Header, etag string) bool {
+	tag := opaqueTag(etag)
+	for _, s := range header.GetList(HeaderIfNoneMatch) {
+		if s == "*" || opaqueTag(s) == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// ETagHandler returns a handler that adds an ETag header to successful
+// responses from h to GET and HEAD requests and responds to matching
+// conditional requests with HTTP status 304.
+//
+// The entity tag is a hash of the response body. If weak is true, then the
+// entity tag is a weak validator. If h sets the ETag header, then the header
+// from h is used.
+//
+// Responses are buffered up to maxLen bytes. Responses that are larger than
+// maxLen, responses flushed by h and responses with the Cache-Control no-store
+// directive are sent unmodified. The handler sets the Content-Length header of
+// buffered responses. For HEAD requests, the body written by h is used to
+// compute the entity tag and Content-Length and is then discarded.
+func ETagHandler(maxLen int, weak bool, h Handler) Handler {
+	filter := func(req *Request, br *BufferedResponder) {
+		if br.Status != StatusOK {
+			return
+		}
+		for _, directive := range br.Header.GetList(HeaderCacheControl) {
+			if strings.EqualFold(directive, "no-store") {
+				return
+			}
+		}
+		if req.Method == "HEAD" && br.Body.Len() == 0 {
+			// The handler did not write the body. The entity tag and content
+			// length cannot be computed.
+			return
+		}
+		etag := br.Header.Get(HeaderETag)
+		if etag == "" {
+			sum := sha256.Sum256(br.Body.Bytes())
+			etag = `"` + base64.RawURLEncoding.EncodeToString(sum[:16]) + `"`
+			if weak {
+				etag = "W/" + etag
+			}
+			br.Header.Set(HeaderETag, etag)
+		}
+		if MatchIfNoneMatch(req.Header, etag) {
+			br.Status = StatusNotModified
+			for k := range br.Header {
+				if strings.HasPrefix(k, "Content-") {
+					delete(br.Header, k)
+				}
+			}
+			br.Body.Reset()
+			return
+		}
+		br.Header.Set(HeaderContentLength, strconv.Itoa(br.Body.Len()))
+		if req.Method == "HEAD" {
+			br.Body.Reset()
+		}
+	}
+	bh := BufferedHandler(maxLen, filter, h)
+	return HandlerFunc(func(req *Request) {
+		if req.Method != "GET" && req.Method != "HEAD" {
+			h.ServeWeb(req)
+			return
+		}
+		bh.ServeWeb(req)
+	})
+}
@@ -0,0 +1,110 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package web
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestMatchIfNoneMatch(t *testing.T) {
+	tests := []struct {
+		ifNoneMatch string
+		etag        string
+		match       bool
+	}{
+		{"", `"a"`, false},
+		{`"a"`, `"a"`, true},
+		{`"b", "a"`, `"a"`, true},
+		{`W/"a"`, `"a"`, true},
+		{`"a"`, `W/"a"`, true},
+		{`"b"`, `"a"`, false},
+		{`*`, `"a"`, true},
+	}
+	for _, tt := range tests {
+		header := NewHeader()
+		if tt.ifNoneMatch != "" {
+			header.Set(HeaderIfNoneMatch, tt.ifNoneMatch)
+		}
+		if match := MatchIfNoneMatch(header, tt.etag); match != tt.match {
+			t.Errorf("MatchIfNoneMatch(%q, %q) = %v, want %v", tt.ifNoneMatch, tt.etag, match, tt.match)
+		}
+	}
+}
+
+func etagTestHandler(status int, headerKeysAndValues ...string) Handler {
+	return HandlerFunc(func(req *Request) {
+		io.WriteString(req.Respond(status, headerKeysAndValues...), "hello world")
+	})
+}
+
+func TestETagHandler(t *testing.T) {
+	_, header, _ := RunHandler("/", "GET", nil, nil, ETagHandler(100, false, etagTestHandler(StatusOK)))
+	strong := header.Get(HeaderETag)
+	if !strings.HasPrefix(strong, `"`) || header.Get(HeaderContentLength) != "11" {
+		t.Fatalf("header=%v", header)
+	}
+	_, header, _ = RunHandler("/", "GET", nil, nil, ETagHandler(100, true, etagTestHandler(StatusOK)))
+	weak := header.Get(HeaderETag)
+	if weak != "W/"+strong {
+		t.Fatalf("weak=%q, strong=%q", weak, strong)
+	}
+
+	tests := []struct {
+		method      string
+		ifNoneMatch string
+		h           Handler
+		status      int
+		body        string
+		etag        bool
+	}{
+		{"GET", "", etagTestHandler(StatusOK), StatusOK, "hello world", true},
+		{"GET", strong, etagTestHandler(StatusOK), StatusNotModified, "", true},
+		{"GET", `"other", ` + weak, etagTestHandler(StatusOK), StatusNotModified, "", true},
+		{"GET", `"other"`, etagTestHandler(StatusOK), StatusOK, "hello world", true},
+		{"HEAD", "", etagTestHandler(StatusOK), StatusOK, "", true},
+		{"HEAD", strong, etagTestHandler(StatusOK), StatusNotModified, "", true},
+		{"POST", strong, etagTestHandler(StatusOK), StatusOK, "hello world", false},
+		{"GET", strong, etagTestHandler(StatusNotFound), StatusNotFound, "hello world", false},
+		{"GET", strong, etagTestHandler(StatusOK, HeaderCacheControl, "private, no-store"), StatusOK, "hello world", false},
+		{"GET", `"custom"`, etagTestHandler(StatusOK, HeaderETag, `"custom"`), StatusNotModified, "", true},
+	}
+	for i, tt := range tests {
+		header := NewHeader()
+		if tt.ifNoneMatch != "" {
+			header.Set(HeaderIfNoneMatch, tt.ifNoneMatch)
+		}
+		status, respHeader, body := RunHandler("/", tt.method, header, nil, ETagHandler(100, false, tt.h))
+		if status != tt.status || string(body) != tt.body {
+			t.Errorf("%d: status=%d, body=%q, want %d, %q", i, status, body, tt.status, tt.body)
+		}
+		if (respHeader.Get(HeaderETag) != "") != tt.etag {
+			t.Errorf("%d: ETag=%q", i, respHeader.Get(HeaderETag))
+		}
+		if status == StatusNotModified && respHeader.Get(HeaderContentLength) != "" {
+			t.Errorf("%d: Content-Length set on 304", i)
+		}
+		if tt.method == "HEAD" && status == StatusOK && respHeader.Get(HeaderContentLength) != "11" {
+			t.Errorf("%d: Content-Length=%q", i, respHeader.Get(HeaderContentLength))
+		}
+	}
+
+	// Large responses stream without an ETag.
+	status, header, body := RunHandler("/", "GET", nil, nil, ETagHandler(5, false, etagTestHandler(StatusOK)))
+	if status != StatusOK || string(body) != "hello world" || header.Get(HeaderETag) != "" {
+		t.Errorf("large: status=%d, header=%v, body=%q", status, header, body)
+	}
+}
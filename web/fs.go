@@ -67,14 +67,11 @@ func ServeFile(req *Request, fname string, options *ServeFileOptions) {
 		}
 	}
 
-	etag := strconv.FormatInt(info.ModTime().UnixNano(), 36)
-	header.Set(HeaderETag, QuoteHeaderValue(etag))
+	etag := QuoteHeaderValue(strconv.FormatInt(info.ModTime().UnixNano(), 36))
+	header.Set(HeaderETag, etag)
 
-	for _, qetag := range req.Header.GetList(HeaderIfNoneMatch) {
-		if etag == UnquoteHeaderValue(qetag) {
-			status = StatusNotModified
-			break
-		}
+	if MatchIfNoneMatch(req.Header, etag) {
+		status = StatusNotModified
 	}
 
 	if status == StatusNotModified {
@@ -399,9 +399,49 @@ const (
 	HeaderVia                = "Via"
 	HeaderWWWAuthenticate    = "Www-Authenticate"
 	HeaderWarning            = "Warning"
+	HeaderXCSRFToken         = "X-Csrf-Token"
 	HeaderXXSRFToken         = "X-Xsrftoken"
 )
 
+// Cross-origin resource sharing header names in canonical format.
+const (
+	HeaderAccessControlAllowCredentials = "Access-Control-Allow-Credentials"
+	HeaderAccessControlAllowHeaders     = "Access-Control-Allow-Headers"
+	HeaderAccessControlAllowMethods     = "Access-Control-Allow-Methods"
+	HeaderAccessControlAllowOrigin      = "Access-Control-Allow-Origin"
+	HeaderAccessControlExposeHeaders    = "Access-Control-Expose-Headers"
+	HeaderAccessControlMaxAge           = "Access-Control-Max-Age"
+	HeaderAccessControlRequestHeaders   = "Access-Control-Request-Headers"
+	HeaderAccessControlRequestMethod    = "Access-Control-Request-Method"
+)
+
+// Security header names in canonical format.
+const (
+	HeaderContentSecurityPolicy           = "Content-Security-Policy"
+	HeaderContentSecurityPolicyReportOnly = "Content-Security-Policy-Report-Only"
+	HeaderPermissionsPolicy               = "Permissions-Policy"
+	HeaderReferrerPolicy                  = "Referrer-Policy"
+	HeaderStrictTransportSecurity         = "Strict-Transport-Security"
+	HeaderXContentTypeOptions             = "X-Content-Type-Options"
+	HeaderXFrameOptions                   = "X-Frame-Options"
+)
+
+// Proxy header names in canonical format.
+const (
+	HeaderForwarded       = "Forwarded"
+	HeaderXForwardedFor   = "X-Forwarded-For"
+	HeaderXForwardedHost  = "X-Forwarded-Host"
+	HeaderXForwardedProto = "X-Forwarded-Proto"
+)
+
+// Rate limit header names in canonical format.
+const (
+	HeaderRateLimitLimit     = "Ratelimit-Limit"
+	HeaderRateLimitPolicy    = "Ratelimit-Policy"
+	HeaderRateLimitRemaining = "Ratelimit-Remaining"
+	HeaderRateLimitReset     = "Ratelimit-Reset"
+)
+
 // HeaderName returns the canonical format of the header name. 
 func HeaderName(name string) string {
 	return HeaderNameBytes([]byte(name))
@@ -0,0 +1,140 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package web
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"strings"
+)
+
+// ContentTypeJSON is the content type for JSON.
+const ContentTypeJSON = "application/json; charset=utf-8"
+
+var (
+	errNotJSON   = errors.New("twister: request content type is not JSON")
+	errEmptyJSON = errors.New("twister: request body is empty")
+)
+
+// isJSONType returns true if the lowercase content type t is application/json
+// or a type with the +json suffix.
+func isJSONType(t string) bool {
+	return t == "application/json" || (strings.HasPrefix(t, "application/") && strings.HasSuffix(t, "+json"))
+}
+
+// DecodeJSON reads the request body and decodes the JSON value in the body to
+// v. If maxLen is negative, then no limit is imposed on the length of the
+// body.
+//
+// If the body cannot be decoded, then DecodeJSON responds to the request using
+// req.Error and returns the error. The response status is 415 if the request
+// content type is not application/json or a +json type, 413 if the body is
+// longer than maxLen and 400 if the body is empty or is not valid JSON for v.
+// The application should not respond to the request when DecodeJSON returns
+// an error:
+//
+//  var v Item
+//  if err := web.DecodeJSON(req, 1<<20, &v); err != nil {
+//      return
+//  }
+func DecodeJSON(req *Request, maxLen int, v interface{}) error {
+	if !isJSONType(req.ContentType) {
+		req.Error(StatusUnsupportedMediaType, errNotJSON)
+		return errNotJSON
+	}
+	p, err := req.BodyBytes(maxLen)
+	if err != nil {
+		status := StatusBadRequest
+		if err == ErrRequestEntityTooLarge {
+			status = StatusRequestEntityTooLarge
+			if e := req.Header.Get(HeaderExpect); e != "" {
+				status = StatusExpectationFailed
+			}
+		}
+		req.Error(status, err)
+		return err
+	}
+	if len(p) == 0 {
+		req.Error(StatusBadRequest, errEmptyJSON)
+		return errEmptyJSON
+	}
+	if err := json.Unmarshal(p, v); err != nil {
+		req.Error(StatusBadRequest, err)
+		return err
+	}
+	return nil
+}
+
+// jsonResponseWriter responds to the request on the first call to Write.
+type jsonResponseWriter struct {
+	req    *Request
+	status int
+	header Header
+	w      io.Writer
+}
+
+func (w *jsonResponseWriter) Write(p []byte) (int, error) {
+	if w.w == nil {
+		w.w = w.req.Responder.Respond(w.status, w.header)
+	}
+	return w.w.Write(p)
+}
+
+// RespondJSON responds to the request with the JSON encoding of v. The value
+// is indented if the request has the parameter "pretty". Additional response
+// headers are specified by the (key, value) pairs in headerKeysAndValues.
+//
+// If v cannot be encoded, then RespondJSON responds to the request using
+// req.Error with status 500 and returns the error.
+func RespondJSON(req *Request, status int, v interface{}, headerKeysAndValues ...string) error {
+	header := NewHeader(headerKeysAndValues...)
+	header.Set(HeaderContentType, ContentTypeJSON)
+	w := &jsonResponseWriter{req: req, status: status, header: header}
+	enc := json.NewEncoder(w)
+	if _, pretty := req.Param["pretty"]; pretty {
+		enc.SetIndent("", "  ")
+	}
+	err := enc.Encode(v)
+	if err != nil && w.w == nil {
+		req.Error(StatusInternalServerError, err)
+	}
+	return err
+}
+
+// JSONErrorHandler is an ErrorHandler that responds with a JSON object
+// containing the status and status text. For status codes less than 500, the
+// object also contains the message from the reason for the error. Use
+// SetErrorHandler to install the handler.
+func JSONErrorHandler(req *Request, status int, reason error, header Header) {
+	v := struct {
+		Status  int    `json:"status"`
+		Error   string `json:"error"`
+		Message string `json:"message,omitempty"`
+	}{
+		Status: status,
+		Error:  StatusText(status),
+	}
+	if reason != nil && status < 500 {
+		v.Message = reason.Error()
+	}
+	header.Set(HeaderContentType, ContentTypeJSON)
+	w := req.Responder.Respond(status, header)
+	json.NewEncoder(w).Encode(&v)
+	if reason != nil || status >= 500 {
+		log.Println("ERROR", req.URL, status, reason)
+	}
+}
@@ -0,0 +1,93 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package web
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+type jsonTestItem struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+var decodeJSONTests = []struct {
+	contentType string
+	body        string
+	status      int
+	response    string
+}{
+	{"application/json", `{"name":"a","count":2}`, StatusOK, "{\"name\":\"a\",\"count\":3}\n"},
+	{"application/merge-patch+json", `{"name":"a"}`, StatusOK, "{\"name\":\"a\",\"count\":1}\n"},
+	{"text/plain", `{"name":"a"}`, StatusUnsupportedMediaType, ""},
+	{"application/json", `{"name":`, StatusBadRequest, ""},
+	{"application/json", `{"count":"x"}`, StatusBadRequest, ""},
+	{"application/json", ``, StatusBadRequest, ""},
+	{"application/json", `{"name":"aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"}`, StatusRequestEntityTooLarge, ""},
+}
+
+func TestDecodeJSON(t *testing.T) {
+	h := SetErrorHandler(JSONErrorHandler, HandlerFunc(func(req *Request) {
+		var v jsonTestItem
+		if err := DecodeJSON(req, 32, &v); err != nil {
+			return
+		}
+		v.Count += 1
+		RespondJSON(req, StatusOK, &v)
+	}))
+	for _, tt := range decodeJSONTests {
+		header := NewHeader(HeaderContentType, tt.contentType)
+		status, respHeader, body := RunHandler("/", "POST", header, []byte(tt.body), h)
+		if status != tt.status {
+			t.Errorf("body=%q, status=%d, want %d", tt.body, status, tt.status)
+			continue
+		}
+		if ct := respHeader.Get(HeaderContentType); ct != ContentTypeJSON {
+			t.Errorf("body=%q, content-type=%q", tt.body, ct)
+		}
+		if status == StatusOK {
+			if string(body) != tt.response {
+				t.Errorf("body=%q, response=%q, want %q", tt.body, body, tt.response)
+			}
+		} else {
+			var v struct {
+				Status int
+				Error  string
+			}
+			if err := json.Unmarshal(body, &v); err != nil || v.Status != tt.status || v.Error != StatusText(tt.status) {
+				t.Errorf("body=%q, error response=%q", tt.body, body)
+			}
+		}
+	}
+}
+
+func TestRespondJSON(t *testing.T) {
+	status, _, body := RunHandler("/?pretty=1", "GET", nil, nil, HandlerFunc(func(req *Request) {
+		RespondJSON(req, StatusCreated, map[string]int{"a": 1})
+	}))
+	if status != StatusCreated || string(body) != "{\n  \"a\": 1\n}\n" {
+		t.Errorf("status=%d, body=%q", status, body)
+	}
+
+	status, _, _ = RunHandler("/", "GET", nil, nil, HandlerFunc(func(req *Request) {
+		if err := RespondJSON(req, StatusOK, func() {}); err == nil {
+			t.Error("expected error")
+		}
+	}))
+	if status != StatusInternalServerError {
+		t.Errorf("status=%d, want %d", status, StatusInternalServerError)
+	}
+}
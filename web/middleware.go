@@ -94,6 +94,10 @@ func SetErrorHandler(e ErrorHandler, h Handler) Handler {
 //
 // The original values are added to the request Env with the keys
 // "twister.web.OriginalRemoteAddr" and "twister.web.OriginalScheme".
+//
+// ProxyHeaderHandler trusts the headers from any client. Use
+// TrustedProxyHandler when clients can connect to the application without
+// going through the proxy.
 func ProxyHeaderHandler(addrName, schemeName string, h Handler) Handler {
 	return proxyHeaderHandler{
 		addrName:   addrName,
@@ -63,6 +63,7 @@ const (
 	StatusNotModified                  = 304
 	StatusUseProxy                     = 305
 	StatusTemporaryRedirect            = 307
+	StatusPermanentRedirect            = 308
 	StatusBadRequest                   = 400
 	StatusUnauthorized                 = 401
 	StatusPaymentRequired              = 402
@@ -81,6 +82,8 @@ const (
 	StatusUnsupportedMediaType         = 415
 	StatusRequestedRangeNotSatisfiable = 416
 	StatusExpectationFailed            = 417
+	StatusUnprocessableEntity          = 422
+	StatusTooManyRequests              = 429
 	StatusInternalServerError          = 500
 	StatusNotImplemented               = 501
 	StatusBadGateway                   = 502
@@ -106,6 +109,7 @@ var statusText = map[int]string{
 	StatusNotModified:                  "Not Modified",
 	StatusUseProxy:                     "Use Proxy",
 	StatusTemporaryRedirect:            "Temporary Redirect",
+	StatusPermanentRedirect:            "Permanent Redirect",
 	StatusBadRequest:                   "Bad Request",
 	StatusUnauthorized:                 "Unauthorized",
 	StatusPaymentRequired:              "Payment Required",
@@ -124,6 +128,8 @@ var statusText = map[int]string{
 	StatusUnsupportedMediaType:         "Unsupported Media Type",
 	StatusRequestedRangeNotSatisfiable: "Requested Range Not Satisfiable",
 	StatusExpectationFailed:            "Expectation Failed",
+	StatusUnprocessableEntity:          "Unprocessable Entity",
+	StatusTooManyRequests:              "Too Many Requests",
 	StatusInternalServerError:          "Internal Server Error",
 	StatusNotImplemented:               "Not Implemented",
 	StatusBadGateway:                   "Bad Gateway",
@@ -302,11 +308,9 @@ func CheckXSRF(req *Request, cookieName string, paramName string) error {
 			return err
 		}
 		expectedToken = hex.EncodeToString(p)
-		c := NewCookie(cookieName, expectedToken).String()
-		FilterRespond(req, func(status int, header Header) (int, Header) {
-			header.Add(HeaderSetCookie, c)
-			return status, header
-		})
+		if err := SetCookie(req, NewCookie(cookieName, expectedToken)); err != nil {
+			return err
+		}
 	}
 
 	actualToken := req.Param.Get(paramName)
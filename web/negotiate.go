@@ -0,0 +1,243 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package web
+
+import (
+	"strconv"
+	"strings"
+)
+
+// acceptSpec is a parsed element of an Accept-* header.
+type acceptSpec struct {
+	value string
+	param map[string]string
+	q     float64
+}
+
+// parseAccept parses the Accept-* header with the given name.
+func parseAccept(header Header, key string) []acceptSpec {
+	var specs []acceptSpec
+	for _, part := range header.GetList(key) {
+		value, param, _ := splitValueParam(part)
+		if value == "" {
+			continue
+		}
+		spec := acceptSpec{value: value, param: param, q: 1}
+		if s, ok := param["q"]; ok {
+			q, err := strconv.ParseFloat(s, 64)
+			if err != nil || q < 0 {
+				q = 0
+			} else if q > 1 {
+				q = 1
+			}
+			spec.q = q
+			delete(param, "q")
+		}
+		specs = append(specs, spec)
+	}
+	return specs
+}
+
+// negotiate returns the offer with the highest quality. The quality of an
+// offer is the quality of the most specific matching spec as determined by
+// the match function. If match returns a negative specificity, then the spec
+// does not match the offer. The quality of an offer without a matching spec is
+// given by noMatchQ. Ties are broken by the order of the offers. If specs is
+// empty, then the first offer is returned.
+func negotiate(specs []acceptSpec, offers []string, noMatchQ func(offer string) float64, match func(spec *acceptSpec, offer string) int) string {
+	if len(offers) == 0 {
+		return ""
+	}
+	if len(specs) == 0 {
+		return offers[0]
+	}
+	best := ""
+	bestQ := 0.0
+	for _, offer := range offers {
+		q := noMatchQ(offer)
+		specificity := -1
+		for i := range specs {
+			if s := match(&specs[i], offer); s > specificity {
+				specificity = s
+				q = specs[i].q
+			}
+		}
+		if q > bestQ {
+			best = offer
+			bestQ = q
+		}
+	}
+	return best
+}
+
+func zeroQ(offer string) float64 { return 0 }
+
+// Negotiate returns the media type from offers that best matches the request
+// Accept header as described in RFC 7231. Offers are media types with
+// optional parameters. Media ranges in the Accept header can use the
+// wildcards "*/*" and "type/*". The most specific media range that matches an
+// offer determines the quality of the offer. Offers with quality zero are not
+// acceptable. If several offers have the same quality, then the first is
+// returned. If the request does not have an Accept header, then the first
+// offer is returned. If no offer is acceptable, then "" is returned.
+func Negotiate(req *Request, offers ...string) string {
+	return negotiate(parseAccept(req.Header, HeaderAccept), offers, zeroQ, matchMediaType)
+}
+
+func matchMediaType(spec *acceptSpec, offer string) int {
+	value, param, _ := splitValueParam(offer)
+	if spec.value == "*/*" {
+		return 0
+	}
+	i := strings.Index(value, "/")
+	if i < 0 {
+		return -1
+	}
+	if strings.HasSuffix(spec.value, "/*") {
+		if spec.value[:len(spec.value)-1] == value[:i+1] {
+			return 1
+		}
+		return -1
+	}
+	if spec.value != value {
+		return -1
+	}
+	for k, v := range spec.param {
+		if !strings.EqualFold(param[k], v) {
+			return -1
+		}
+	}
+	return 2 + len(spec.param)
+}
+
+// NegotiateLanguage returns the language tag from offers that best matches
+// the request Accept-Language header. A language range matches a tag if the
+// range equals the tag or if the range is a prefix of the tag followed by
+// '-'. The range "*" matches all tags. Comparisons are case insensitive. See
+// Negotiate for the rules on quality, ties and missing headers.
+func NegotiateLanguage(req *Request, offers ...string) string {
+	return negotiate(parseAccept(req.Header, HeaderAcceptLanguage), offers, zeroQ, matchLanguage)
+}
+
+func matchLanguage(spec *acceptSpec, offer string) int {
+	if spec.value == "*" {
+		return 0
+	}
+	offer = strings.ToLower(offer)
+	if offer == spec.value ||
+		(strings.HasPrefix(offer, spec.value) && offer[len(spec.value)] == '-') {
+		return len(spec.value)
+	}
+	return -1
+}
+
+// NegotiateCharset returns the charset from offers that best matches the
+// request Accept-Charset header. See Negotiate for the rules on quality, ties
+// and missing headers.
+func NegotiateCharset(req *Request, offers ...string) string {
+	return negotiate(parseAccept(req.Header, HeaderAcceptCharset), offers, zeroQ, matchToken)
+}
+
+func matchToken(spec *acceptSpec, offer string) int {
+	switch {
+	case spec.value == "*":
+		return 0
+	case strings.EqualFold(spec.value, offer):
+		return 1
+	}
+	return -1
+}
+
+// NegotiateEncoding returns the content coding from offers that best matches
+// the request Accept-Encoding header. The "identity" coding is acceptable
+// unless it is explicitly excluded by "identity;q=0" or "*;q=0". See Negotiate
+// for the rules on quality, ties and missing headers.
+func NegotiateEncoding(req *Request, offers ...string) string {
+	return negotiate(parseAccept(req.Header, HeaderAcceptEncoding), offers, identityQ, matchToken)
+}
+
+func identityQ(offer string) float64 {
+	if strings.EqualFold(offer, "identity") {
+		return 1
+	}
+	return 0
+}
+
+type producesHandler struct {
+	types    []string
+	handlers map[string]Handler
+}
+
+// ProducesHandler returns a handler that dispatches requests to other handlers
+// using the media type that best matches the request Accept header. The
+// structure of the handlers argument is:
+//
+//  (mediaType handler)+
+//
+// where mediaType is a string and handler is a Handler or a func(*Request).
+// If no media type is acceptable, then the handler responds with HTTP status
+// 406. The handler adds Accept to the Vary response header.
+//
+// Use ProducesHandler with a Router to dispatch by method and media type:
+//
+//  r.Register("/items/<id>", "GET", web.ProducesHandler(
+//      "text/html", serveItemHTML,
+//      "application/json", serveItemJSON))
+func ProducesHandler(handlers ...interface{}) Handler {
+	if len(handlers)%2 != 0 || len(handlers) == 0 {
+		panic("twister: Invalid handlers for ProducesHandler. Structure of handlers is [mediaType handler]+.")
+	}
+	h := &producesHandler{handlers: make(map[string]Handler)}
+	for i := 0; i < len(handlers); i += 2 {
+		mediaType, ok := handlers[i].(string)
+		if !ok {
+			panic("twister: Bad media type for ProducesHandler")
+		}
+		switch handler := handlers[i+1].(type) {
+		case Handler:
+			h.handlers[mediaType] = handler
+		case func(*Request):
+			h.handlers[mediaType] = HandlerFunc(handler)
+		default:
+			panic("twister: Bad handler for media type " + mediaType)
+		}
+		h.types = append(h.types, mediaType)
+	}
+	return h
+}
+
+func (h *producesHandler) ServeWeb(req *Request) {
+	FilterRespond(req, func(status int, header Header) (int, Header) {
+		addVary(header, HeaderAccept)
+		return status, header
+	})
+	mediaType := Negotiate(req, h.types...)
+	if mediaType == "" {
+		req.Error(StatusNotAcceptable, nil)
+		return
+	}
+	h.handlers[mediaType].ServeWeb(req)
+}
+
+// addVary adds name to the Vary header if the header does not already
+// include name or "*".
+func addVary(header Header, name string) {
+	for _, v := range header.GetList(HeaderVary) {
+		if v == "*" || strings.EqualFold(v, name) {
+			return
+		}
+	}
+	header.Add(HeaderVary, name)
+}
@@ -0,0 +1,94 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package web
+
+import (
+	"testing"
+)
+
+var negotiateTests = []struct {
+	f      func(*Request, ...string) string
+	name   string
+	header string
+	offers []string
+	expect string
+}{
+	{Negotiate, HeaderAccept, "", []string{"text/html", "application/json"}, "text/html"},
+	{Negotiate, HeaderAccept, "application/json", []string{"text/html", "application/json"}, "application/json"},
+	{Negotiate, HeaderAccept, "text/*, application/json;q=0.5", []string{"application/json", "text/html"}, "text/html"},
+	{Negotiate, HeaderAccept, "*/*;q=0.1, application/json", []string{"text/html", "application/json"}, "application/json"},
+	{Negotiate, HeaderAccept, "*/*, text/html;q=0", []string{"text/html", "application/json"}, "application/json"},
+	{Negotiate, HeaderAccept, "text/html;q=0", []string{"text/html"}, ""},
+	{Negotiate, HeaderAccept, "image/png", []string{"text/html"}, ""},
+	{Negotiate, HeaderAccept, "text/html;level=1, text/html;q=0.5", []string{"text/html;level=2", "text/html;level=1"}, "text/html;level=1"},
+	{Negotiate, HeaderAccept, "text/html, application/json", []string{"application/json", "text/html"}, "application/json"},
+	{NegotiateLanguage, HeaderAcceptLanguage, "en", []string{"fr", "en-US"}, "en-US"},
+	{NegotiateLanguage, HeaderAcceptLanguage, "en-gb, en;q=0.8, *;q=0.1", []string{"fr", "en-US", "en-GB"}, "en-GB"},
+	{NegotiateLanguage, HeaderAcceptLanguage, "en-gb, en;q=0.8, *;q=0.1", []string{"fr", "en-US"}, "en-US"},
+	{NegotiateLanguage, HeaderAcceptLanguage, "en", []string{"english"}, ""},
+	{NegotiateLanguage, HeaderAcceptLanguage, "*, fr;q=0", []string{"fr", "de"}, "de"},
+	{NegotiateCharset, HeaderAcceptCharset, "iso-8859-1, UTF-8;q=0.5", []string{"utf-8", "iso-8859-1"}, "iso-8859-1"},
+	{NegotiateCharset, HeaderAcceptCharset, "iso-8859-1", []string{"utf-8"}, ""},
+	{NegotiateEncoding, HeaderAcceptEncoding, "gzip", []string{"br", "gzip", "identity"}, "gzip"},
+	{NegotiateEncoding, HeaderAcceptEncoding, "br;q=0.5, gzip", []string{"br", "gzip"}, "gzip"},
+	{NegotiateEncoding, HeaderAcceptEncoding, "br", []string{"gzip", "identity"}, "identity"},
+	{NegotiateEncoding, HeaderAcceptEncoding, "br, identity;q=0", []string{"gzip", "identity"}, ""},
+	{NegotiateEncoding, HeaderAcceptEncoding, "*;q=0", []string{"identity"}, ""},
+}
+
+func TestNegotiate(t *testing.T) {
+	for _, tt := range negotiateTests {
+		header := Header{}
+		if tt.header != "" {
+			header.Set(tt.name, tt.header)
+		}
+		actual := tt.f(&Request{Header: header}, tt.offers...)
+		if actual != tt.expect {
+			t.Errorf("%s: %q, offers %q = %q, want %q", tt.name, tt.header, tt.offers, actual, tt.expect)
+		}
+	}
+}
+
+var producesTests = []struct {
+	accept string
+	status int
+	body   string
+}{
+	{"", StatusOK, "html"},
+	{"application/json", StatusOK, "json"},
+	{"image/png", StatusNotAcceptable, ""},
+}
+
+func TestProducesHandler(t *testing.T) {
+	h := ProducesHandler(
+		"text/html", routeTestHandler("html"),
+		"application/json", routeTestHandler("json"))
+	for _, tt := range producesTests {
+		header := Header{}
+		if tt.accept != "" {
+			header.Set(HeaderAccept, tt.accept)
+		}
+		status, respHeader, body := RunHandler("/", "GET", header, nil, h)
+		if status != tt.status {
+			t.Errorf("accept=%q, status=%d, want %d", tt.accept, status, tt.status)
+		}
+		if status == StatusOK && string(body) != tt.body {
+			t.Errorf("accept=%q, body=%q, want %q", tt.accept, body, tt.body)
+		}
+		if vary := respHeader.Get(HeaderVary); vary != HeaderAccept {
+			t.Errorf("accept=%q, vary=%q, want %q", tt.accept, vary, HeaderAccept)
+		}
+	}
+}
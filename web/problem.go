@@ -0,0 +1,200 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package web
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log"
+)
+
+// ContentTypeProblem is the content type for RFC 7807 problem details.
+const ContentTypeProblem = "application/problem+json"
+
+// StatusCoder is implemented by errors that specify the HTTP status for the
+// error.
+type StatusCoder interface {
+	StatusCode() int
+}
+
+// ErrorStatus returns the HTTP status for err. If err or an error wrapped by
+// err implements StatusCoder, then the status from that error is returned.
+// Otherwise, the status is 413 for ErrRequestEntityTooLarge, 400 for
+// ErrBadFormat and 500 for all other errors.
+func ErrorStatus(err error) int {
+	var sc StatusCoder
+	switch {
+	case errors.As(err, &sc):
+		return sc.StatusCode()
+	case errors.Is(err, ErrRequestEntityTooLarge):
+		return StatusRequestEntityTooLarge
+	case errors.Is(err, ErrBadFormat):
+		return StatusBadRequest
+	}
+	return StatusInternalServerError
+}
+
+// Fail responds to the request with an error using the status returned by
+// ErrorStatus(err).
+func (req *Request) Fail(err error, headerKeysAndValues ...string) {
+	req.Error(ErrorStatus(err), err, headerKeysAndValues...)
+}
+
+// Problem is an error with RFC 7807 problem details.
+type Problem struct {
+	// URI that identifies the problem type. If Type is "", then the type is
+	// "about:blank".
+	Type string
+
+	// Short summary of the problem type.
+	Title string
+
+	// HTTP status code.
+	Status int
+
+	// Explanation specific to this occurrence of the problem.
+	Detail string
+
+	// URI that identifies this occurrence of the problem.
+	Instance string
+
+	// Additional members of the problem details object.
+	Extensions map[string]interface{}
+}
+
+// NewProblem returns a problem with the given status, the status text as the
+// title and the given detail.
+func NewProblem(status int, detail string) *Problem {
+	return &Problem{Title: StatusText(status), Status: status, Detail: detail}
+}
+
+// Error returns the title and detail.
+func (p *Problem) Error() string {
+	if p.Detail == "" {
+		return p.Title
+	}
+	return p.Title + ": " + p.Detail
+}
+
+// StatusCode returns the problem status.
+func (p *Problem) StatusCode() int {
+	if p.Status == 0 {
+		return StatusInternalServerError
+	}
+	return p.Status
+}
+
+// MarshalJSON encodes the problem as a problem details object with the
+// extension members at the top level of the object.
+func (p *Problem) MarshalJSON() ([]byte, error) {
+	m := make(map[string]interface{}, len(p.Extensions)+5)
+	for k, v := range p.Extensions {
+		m[k] = v
+	}
+	if p.Type != "" {
+		m["type"] = p.Type
+	}
+	if p.Title != "" {
+		m["title"] = p.Title
+	}
+	if p.Status != 0 {
+		m["status"] = p.Status
+	}
+	if p.Detail != "" {
+		m["detail"] = p.Detail
+	}
+	if p.Instance != "" {
+		m["instance"] = p.Instance
+	}
+	return json.Marshal(m)
+}
+
+// RespondProblem responds to the request with the problem encoded as
+// application/problem+json. The response status is p.StatusCode().
+func RespondProblem(req *Request, p *Problem, headerKeysAndValues ...string) {
+	header := NewHeader(headerKeysAndValues...)
+	respondProblem(req, p.StatusCode(), p, header)
+}
+
+func respondProblem(req *Request, status int, p *Problem, header Header) {
+	b, err := json.Marshal(p)
+	if err != nil {
+		log.Println("ERROR", req.URL, err)
+		b, _ = json.Marshal(NewProblem(status, ""))
+	}
+	header.Set(HeaderContentType, ContentTypeProblem)
+	w := req.Responder.Respond(status, header)
+	w.Write(b)
+}
+
+// problemFor returns the response status and problem for an error response.
+// If reason is or wraps a *Problem, then that problem is used and the
+// response status is the problem status. If the problem status is not set,
+// then a copy of the problem with the given status is used. Otherwise, a
+// problem is created from the status and reason. The reason is only included
+// in the detail for status codes less than 500.
+func problemFor(status int, reason error) (int, *Problem) {
+	var p *Problem
+	if errors.As(reason, &p) {
+		if p.Status != 0 {
+			return p.Status, p
+		}
+		q := *p
+		q.Status = status
+		return status, &q
+	}
+	p = NewProblem(status, "")
+	if reason != nil && status < 500 {
+		p.Detail = reason.Error()
+		var errs FieldErrors
+		if errors.As(reason, &errs) {
+			p.Detail = "The request has invalid fields."
+			p.Extensions = map[string]interface{}{"errors": errs}
+		}
+	}
+	return status, p
+}
+
+// ProblemErrorHandler is an ErrorHandler that responds with RFC 7807 problem
+// details. The response is application/problem+json or HTML depending on the
+// request Accept header. If reason is or wraps a *Problem, then the problem
+// details are taken from that problem and the response status is the problem
+// status if set. Use SetErrorHandler to install the handler.
+func ProblemErrorHandler(req *Request, status int, reason error, header Header) {
+	status, p := problemFor(status, reason)
+	addVary(header, HeaderAccept)
+	if Negotiate(req, ContentTypeProblem, "application/json", "text/html") == "text/html" {
+		var b bytes.Buffer
+		b.WriteString("<!DOCTYPE html>\n<html><head><title>")
+		b.WriteString(HTMLEscapeString(p.Title))
+		b.WriteString("</title></head><body>\n<h1>")
+		b.WriteString(HTMLEscapeString(p.Title))
+		b.WriteString("</h1>\n")
+		if p.Detail != "" {
+			b.WriteString("<p>")
+			b.WriteString(HTMLEscapeString(p.Detail))
+			b.WriteString("</p>\n")
+		}
+		b.WriteString("</body></html>\n")
+		header.Set(HeaderContentType, ContentTypeHTML)
+		req.Responder.Respond(status, header).Write(b.Bytes())
+	} else {
+		respondProblem(req, status, p, header)
+	}
+	if reason != nil || status >= 500 {
+		log.Println("ERROR", req.URL, status, reason)
+	}
+}
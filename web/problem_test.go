@@ -0,0 +1,130 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package web
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var errorStatusTests = []struct {
+	err    error
+	status int
+}{
+	{errors.New("x"), StatusInternalServerError},
+	{ErrRequestEntityTooLarge, StatusRequestEntityTooLarge},
+	{fmt.Errorf("wrapped: %w", ErrBadFormat), StatusBadRequest},
+	{NewProblem(StatusConflict, "x"), StatusConflict},
+	{fmt.Errorf("wrapped: %w", NewProblem(StatusGone, "x")), StatusGone},
+	{FieldErrors{"a": {"b"}}, StatusUnprocessableEntity},
+}
+
+func TestErrorStatus(t *testing.T) {
+	for _, tt := range errorStatusTests {
+		if status := ErrorStatus(tt.err); status != tt.status {
+			t.Errorf("ErrorStatus(%v)=%d, want %d", tt.err, status, tt.status)
+		}
+	}
+}
+
+var problemTests = []struct {
+	accept      string
+	err         error
+	errorStatus int // if set, call req.Error with this status
+	status      int
+	contentType string
+	body        map[string]interface{}
+	html        string
+}{
+	{
+		err:         &Problem{Type: "https://example.com/out-of-credit", Title: "Out of credit", Status: StatusForbidden, Detail: "Balance is 30", Extensions: map[string]interface{}{"balance": 30}},
+		status:      StatusForbidden,
+		contentType: ContentTypeProblem,
+		body:        map[string]interface{}{"type": "https://example.com/out-of-credit", "title": "Out of credit", "status": float64(403), "detail": "Balance is 30", "balance": float64(30)},
+	},
+	{
+		err:         errors.New("secret"),
+		status:      StatusInternalServerError,
+		contentType: ContentTypeProblem,
+		body:        map[string]interface{}{"title": "Internal Server Error", "status": float64(500)},
+	},
+	{
+		err:         FieldErrors{"name": {"is required"}},
+		status:      StatusUnprocessableEntity,
+		contentType: ContentTypeProblem,
+		body:        map[string]interface{}{"title": "Unprocessable Entity", "status": float64(422), "detail": "The request has invalid fields.", "errors": map[string]interface{}{"name": []interface{}{"is required"}}},
+	},
+	{
+		accept:      "text/html,*/*;q=0.8",
+		err:         NewProblem(StatusNotFound, "No <item>"),
+		status:      StatusNotFound,
+		contentType: ContentTypeHTML,
+		html:        "<h1>Not Found</h1>\n<p>No &lt;item&gt;</p>",
+	},
+	{
+		err:         &Problem{Title: "Invalid order", Status: StatusUnprocessableEntity},
+		errorStatus: StatusInternalServerError,
+		status:      StatusUnprocessableEntity,
+		contentType: ContentTypeProblem,
+		body:        map[string]interface{}{"title": "Invalid order", "status": float64(422)},
+	},
+	{
+		err:         &Problem{Title: "Gone fishing"},
+		errorStatus: StatusServiceUnavailable,
+		status:      StatusServiceUnavailable,
+		contentType: ContentTypeProblem,
+		body:        map[string]interface{}{"title": "Gone fishing", "status": float64(503)},
+	},
+}
+
+func TestProblemErrorHandler(t *testing.T) {
+	for _, tt := range problemTests {
+		err, errorStatus := tt.err, tt.errorStatus
+		h := SetErrorHandler(ProblemErrorHandler, HandlerFunc(func(req *Request) {
+			if errorStatus != 0 {
+				req.Error(errorStatus, err)
+			} else {
+				req.Fail(err)
+			}
+		}))
+		header := Header{}
+		if tt.accept != "" {
+			header.Set(HeaderAccept, tt.accept)
+		}
+		status, respHeader, body := RunHandler("/", "GET", header, nil, h)
+		if status != tt.status {
+			t.Errorf("err=%v, status=%d, want %d", tt.err, status, tt.status)
+		}
+		if ct := respHeader.Get(HeaderContentType); ct != tt.contentType {
+			t.Errorf("err=%v, content-type=%q, want %q", tt.err, ct, tt.contentType)
+		}
+		if tt.body != nil {
+			var v map[string]interface{}
+			if err := json.Unmarshal(body, &v); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(v, tt.body) {
+				t.Errorf("err=%v, body=%v, want %v", tt.err, v, tt.body)
+			}
+		}
+		if tt.html != "" && !strings.Contains(string(body), tt.html) {
+			t.Errorf("err=%v, body=%s, want %s", tt.err, body, tt.html)
+		}
+	}
+}
@@ -0,0 +1,335 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package web
+
+import (
+	"errors"
+	"hash/fnv"
+	"log"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RateLimitAlgorithm specifies the algorithm used to limit requests.
+type RateLimitAlgorithm int
+
+const (
+	// TokenBucket allows bursts of up to Burst requests. Tokens are added to
+	// the bucket at the rate of Limit per Window.
+	TokenBucket RateLimitAlgorithm = iota
+
+	// SlidingWindow allows Limit requests in any Window. The count for the
+	// window is estimated from the counts for the current and previous fixed
+	// windows.
+	SlidingWindow
+)
+
+// RateLimitOptions specifies the options for RateLimitHandler.
+type RateLimitOptions struct {
+	// Limit is the number of requests allowed per Window.
+	Limit  int
+	Window time.Duration
+
+	// Burst is the token bucket capacity. If zero, then Limit is used.
+	Burst int
+
+	Algorithm RateLimitAlgorithm
+
+	// Key returns the key for the request. Requests with the same key share
+	// a limit. If Key returns "", then the request is not limited. If Key is
+	// nil, then the request's remote address is used.
+	Key func(req *Request) string
+
+	// Store holds the state for each key. If nil, then a memory store with a
+	// capacity of 100000 keys is used.
+	Store RateLimitStore
+}
+
+// RateLimitResult is the result of taking a request from a limit.
+type RateLimitResult struct {
+	// Allowed is true if the request is within the limit.
+	Allowed bool
+
+	// Remaining is the number of requests remaining.
+	Remaining int
+
+	// Reset is the time until the limit is fully restored.
+	Reset time.Duration
+
+	// RetryAfter is the time until the next request is allowed. RetryAfter
+	// is set only when Allowed is false.
+	RetryAfter time.Duration
+}
+
+// RateLimitStore is the interface for the state of rate limits. Implement
+// this interface to share limits between servers.
+type RateLimitStore interface {
+	// Take takes a request for key from the limit specified by options.
+	Take(key string, options *RateLimitOptions, now time.Time) (RateLimitResult, error)
+}
+
+const rateLimitShards = 32
+
+// MemoryRateLimitStore is a RateLimitStore that holds the state in memory.
+// The keys are divided between shards to reduce lock contention. Idle keys
+// are evicted when the limit for the key is fully restored. When the store is
+// full, the least recently used key in the shard is evicted.
+type MemoryRateLimitStore struct {
+	shards [rateLimitShards]rateLimitShard
+	max    int
+}
+
+type rateLimitShard struct {
+	mu      sync.Mutex
+	entries map[string]*rateLimitEntry
+	added   int
+}
+
+type rateLimitEntry struct {
+	// Time of last request and the time when the limit is fully restored.
+	last, idle time.Time
+
+	// Token bucket state.
+	tokens float64
+
+	// Sliding window state.
+	start          time.Time
+	count, prevCnt int
+}
+
+// NewMemoryRateLimitStore returns a new memory store holding up to capacity
+// keys.
+func NewMemoryRateLimitStore(capacity int) *MemoryRateLimitStore {
+	s := &MemoryRateLimitStore{max: (capacity + rateLimitShards - 1) / rateLimitShards}
+	for i := range s.shards {
+		s.shards[i].entries = make(map[string]*rateLimitEntry)
+	}
+	return s
+}
+
+// Take implements the RateLimitStore interface.
+func (s *MemoryRateLimitStore) Take(key string, options *RateLimitOptions, now time.Time) (RateLimitResult, error) {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	shard := &s.shards[h.Sum32()%rateLimitShards]
+
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	e := shard.entries[key]
+	if e == nil {
+		shard.evict(s.max, now)
+		e = &rateLimitEntry{}
+		shard.entries[key] = e
+	}
+
+	var result RateLimitResult
+	switch options.Algorithm {
+	case TokenBucket:
+		result = e.takeToken(options, now)
+	case SlidingWindow:
+		result = e.takeWindow(options, now)
+	default:
+		return result, errors.New("twister: unknown rate limit algorithm")
+	}
+	e.last = now
+	e.idle = now.Add(result.Reset)
+	return result, nil
+}
+
+// evict makes room for a new entry. Idle entries are removed after every 1000
+// new entries and when the shard is full. If the shard is still full, then
+// the least recently used entry is removed. The caller must hold the lock.
+func (shard *rateLimitShard) evict(max int, now time.Time) {
+	shard.added++
+	if shard.added%1000 == 0 || len(shard.entries) >= max {
+		for key, e := range shard.entries {
+			if !now.Before(e.idle) {
+				delete(shard.entries, key)
+			}
+		}
+	}
+	if len(shard.entries) < max {
+		return
+	}
+	var oldestKey string
+	var oldest *rateLimitEntry
+	for key, e := range shard.entries {
+		if oldest == nil || e.last.Before(oldest.last) {
+			oldestKey, oldest = key, e
+		}
+	}
+	delete(shard.entries, oldestKey)
+}
+
+func (e *rateLimitEntry) takeToken(options *RateLimitOptions, now time.Time) RateLimitResult {
+	burst := float64(options.Burst)
+	if burst <= 0 {
+		burst = float64(options.Limit)
+	}
+	rate := float64(options.Limit) / options.Window.Seconds()
+	if e.last.IsZero() {
+		e.tokens = burst
+	} else {
+		e.tokens = math.Min(burst, e.tokens+now.Sub(e.last).Seconds()*rate)
+	}
+	var result RateLimitResult
+	if e.tokens >= 1 {
+		e.tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = time.Duration((1 - e.tokens) / rate * float64(time.Second))
+	}
+	result.Remaining = int(e.tokens)
+	result.Reset = time.Duration((burst - e.tokens) / rate * float64(time.Second))
+	return result
+}
+
+func (e *rateLimitEntry) takeWindow(options *RateLimitOptions, now time.Time) RateLimitResult {
+	start := now.Truncate(options.Window)
+	if !start.Equal(e.start) {
+		if start.Sub(e.start) == options.Window {
+			e.prevCnt = e.count
+		} else {
+			e.prevCnt = 0
+		}
+		e.count = 0
+		e.start = start
+	}
+	elapsed := now.Sub(start)
+	weight := 1 - float64(elapsed)/float64(options.Window)
+	estimate := float64(e.prevCnt)*weight + float64(e.count)
+
+	var result RateLimitResult
+	if estimate+1 <= float64(options.Limit) {
+		e.count++
+		estimate++
+		result.Allowed = true
+	} else if e.count+1 <= options.Limit {
+		// Wait until the weighted count from the previous window decreases
+		// enough to allow the request.
+		w := float64(options.Limit-e.count-1) / float64(e.prevCnt)
+		result.RetryAfter = time.Duration((1-w)*float64(options.Window)) - elapsed
+	} else {
+		// Wait until the weighted count from the current window decreases
+		// enough in the next window.
+		w := float64(options.Limit-1) / float64(e.count)
+		result.RetryAfter = options.Window - elapsed + time.Duration((1-w)*float64(options.Window))
+	}
+	if result.RetryAfter < 0 {
+		result.RetryAfter = 0
+	}
+	result.Remaining = options.Limit - int(math.Ceil(estimate))
+	if result.Remaining < 0 {
+		result.Remaining = 0
+	}
+	// The previous window does not contribute after the current window ends.
+	// The current window does not contribute after the next window ends.
+	if e.count > 0 {
+		result.Reset = 2*options.Window - elapsed
+	} else {
+		result.Reset = options.Window - elapsed
+	}
+	return result
+}
+
+// RateLimitHandler returns a handler that limits the rate of requests for
+// each key. Requests over the limit are rejected with HTTP status 429 and a
+// Retry-After header. The RateLimit-Limit, RateLimit-Remaining,
+// RateLimit-Reset and RateLimit-Policy headers are added to all responses.
+//
+// The key is the request's remote address by default. Use the Key option to
+// limit by API key or other request attributes. Wrap individual handlers
+// registered with a router to limit by route:
+//
+//  router.Register("/search", "GET", web.RateLimitHandler(&web.RateLimitOptions{
+//      Limit:  10,
+//      Window: time.Minute,
+//      Key:    func(req *web.Request) string { return req.Header.Get("X-Api-Key") },
+//  }, searchHandler))
+//
+// If the store returns an error, then the error is logged and the request is
+// allowed.
+func RateLimitHandler(options *RateLimitOptions, h Handler) Handler {
+	if options.Limit <= 0 || options.Window <= 0 {
+		panic("twister: RateLimitHandler requires positive limit and window")
+	}
+	rh := &rateLimitHandler{
+		options: options,
+		store:   options.Store,
+		policy:  strconv.Itoa(options.Limit) + ";w=" + strconv.Itoa(int(math.Ceil(options.Window.Seconds()))),
+		h:       h,
+	}
+	if rh.store == nil {
+		rh.store = NewMemoryRateLimitStore(100000)
+	}
+	if options.Algorithm == TokenBucket && options.Burst > 0 {
+		rh.policy += ";burst=" + strconv.Itoa(options.Burst)
+	}
+	return rh
+}
+
+var errRateLimited = errors.New("twister: rate limit exceeded")
+
+type rateLimitHandler struct {
+	options *RateLimitOptions
+	store   RateLimitStore
+	policy  string
+	h       Handler
+}
+
+// ceilSeconds returns d in seconds rounded up.
+func ceilSeconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
+
+func (h *rateLimitHandler) ServeWeb(req *Request) {
+	var key string
+	if h.options.Key != nil {
+		key = h.options.Key(req)
+	} else {
+		key = stripPort(req.RemoteAddr)
+	}
+	if key == "" {
+		h.h.ServeWeb(req)
+		return
+	}
+	result, err := h.store.Take(key, h.options, time.Now())
+	if err != nil {
+		log.Println("ERROR", req.URL, "rate limit store:", err)
+		h.h.ServeWeb(req)
+		return
+	}
+	headers := []string{
+		HeaderRateLimitLimit, strconv.Itoa(h.options.Limit),
+		HeaderRateLimitRemaining, strconv.Itoa(result.Remaining),
+		HeaderRateLimitReset, ceilSeconds(result.Reset),
+		HeaderRateLimitPolicy, h.policy,
+	}
+	if !result.Allowed {
+		headers = append(headers, HeaderRetryAfter, ceilSeconds(result.RetryAfter))
+		req.Error(StatusTooManyRequests, errRateLimited, headers...)
+		return
+	}
+	FilterRespond(req, func(status int, header Header) (int, Header) {
+		for i := 0; i < len(headers); i += 2 {
+			header.Set(headers[i], headers[i+1])
+		}
+		return status, header
+	})
+	h.h.ServeWeb(req)
+}
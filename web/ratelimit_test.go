@@ -0,0 +1,147 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package web
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	s := NewMemoryRateLimitStore(100)
+	options := &RateLimitOptions{Limit: 2, Window: time.Second, Burst: 3}
+	t0 := time.Unix(1000, 0)
+	tests := []struct {
+		offset     time.Duration
+		allowed    bool
+		remaining  int
+		retryAfter time.Duration
+	}{
+		{0, true, 2, 0},
+		{0, true, 1, 0},
+		{0, true, 0, 0},
+		{0, false, 0, 500 * time.Millisecond},
+		{250 * time.Millisecond, false, 0, 250 * time.Millisecond},
+		{500 * time.Millisecond, true, 0, 0},
+		{10 * time.Second, true, 2, 0},
+	}
+	for i, tt := range tests {
+		r, err := s.Take("k", options, t0.Add(tt.offset))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.Allowed != tt.allowed || r.Remaining != tt.remaining || r.RetryAfter != tt.retryAfter {
+			t.Errorf("%d: result=%+v, want allowed=%v remaining=%d retryAfter=%v", i, r, tt.allowed, tt.remaining, tt.retryAfter)
+		}
+	}
+}
+
+func TestSlidingWindow(t *testing.T) {
+	s := NewMemoryRateLimitStore(100)
+	options := &RateLimitOptions{Limit: 4, Window: 10 * time.Second, Algorithm: SlidingWindow}
+	t0 := time.Unix(1000, 0)
+	tests := []struct {
+		offset     time.Duration
+		allowed    bool
+		remaining  int
+		retryAfter time.Duration
+	}{
+		{0, true, 3, 0},
+		{1 * time.Second, true, 2, 0},
+		{2 * time.Second, true, 1, 0},
+		{3 * time.Second, true, 0, 0},
+		// Next window at 1010 requires the weight of the 4 requests to drop
+		// to 3, at 1012.5.
+		{4 * time.Second, false, 0, 8500 * time.Millisecond},
+		{11 * time.Second, false, 0, 1500 * time.Millisecond},
+		{13 * time.Second, true, 0, 0},
+		// Previous window no longer counts.
+		{25 * time.Second, true, 2, 0},
+	}
+	for i, tt := range tests {
+		r, err := s.Take("k", options, t0.Add(tt.offset))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.Allowed != tt.allowed || r.Remaining != tt.remaining || r.RetryAfter != tt.retryAfter {
+			t.Errorf("%d: result=%+v, want allowed=%v remaining=%d retryAfter=%v", i, r, tt.allowed, tt.remaining, tt.retryAfter)
+		}
+	}
+}
+
+func TestMemoryRateLimitStoreEviction(t *testing.T) {
+	s := NewMemoryRateLimitStore(rateLimitShards * 2)
+	options := &RateLimitOptions{Limit: 1, Window: time.Hour}
+	now := time.Unix(1000, 0)
+	for i := 0; i < 1000; i++ {
+		s.Take(strconv.Itoa(i), options, now.Add(time.Duration(i)))
+	}
+	for i := range s.shards {
+		if n := len(s.shards[i].entries); n > 2 {
+			t.Errorf("shard %d has %d entries", i, n)
+		}
+	}
+	// Most recently used key is retained.
+	if r, _ := s.Take("999", options, now.Add(time.Second)); r.Allowed {
+		t.Errorf("recent key evicted")
+	}
+}
+
+type errorRateLimitStore struct{}
+
+func (errorRateLimitStore) Take(key string, options *RateLimitOptions, now time.Time) (RateLimitResult, error) {
+	return RateLimitResult{}, errors.New("unavailable")
+}
+
+func TestRateLimitHandler(t *testing.T) {
+	h := RateLimitHandler(&RateLimitOptions{Limit: 2, Window: time.Minute}, corsTestHandler)
+	for i, want := range []int{StatusOK, StatusOK, StatusTooManyRequests} {
+		status, header, _ := RunHandler("/", "GET", nil, nil, h)
+		if status != want {
+			t.Errorf("%d: status=%d, want %d", i, status, want)
+		}
+		if header.Get(HeaderRateLimitLimit) != "2" || header.Get(HeaderRateLimitPolicy) != "2;w=60" ||
+			header.Get(HeaderRateLimitRemaining) != strconv.Itoa(1-i+i/2) {
+			t.Errorf("%d: header=%v", i, header)
+		}
+		if (status == StatusTooManyRequests) != (header.Get(HeaderRetryAfter) == "30") {
+			t.Errorf("%d: Retry-After=%q", i, header.Get(HeaderRetryAfter))
+		}
+	}
+
+	// Key function.
+	h = RateLimitHandler(&RateLimitOptions{
+		Limit:  1,
+		Window: time.Minute,
+		Key:    func(req *Request) string { return req.Header.Get("X-Api-Key") },
+	}, corsTestHandler)
+	for i, tt := range []struct {
+		key    string
+		status int
+	}{{"a", StatusOK}, {"b", StatusOK}, {"a", StatusTooManyRequests}, {"", StatusOK}, {"", StatusOK}} {
+		status, _, _ := RunHandler("/", "GET", NewHeader("X-Api-Key", tt.key), nil, h)
+		if status != tt.status {
+			t.Errorf("key %d: status=%d, want %d", i, status, tt.status)
+		}
+	}
+
+	// Store errors allow the request.
+	h = RateLimitHandler(&RateLimitOptions{Limit: 1, Window: time.Minute, Store: errorRateLimitStore{}}, corsTestHandler)
+	if status, _, _ := RunHandler("/", "GET", nil, nil, h); status != StatusOK {
+		t.Errorf("store error: status=%d", status)
+	}
+}
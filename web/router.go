@@ -16,8 +16,13 @@ package web
 
 import (
 	"bytes"
+	"fmt"
 	"path"
+	"reflect"
 	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
 	"strings"
 )
 
@@ -49,17 +54,57 @@ import (
 // request URLParam field.
 //
 // If a pattern ends with '/', then the router redirects the URL without the
-// trailing slash to the URL with the trailing slash.
+// trailing slash to the URL with the trailing slash. The router also
+// redirects request paths that are not clean to the clean path. The
+// PathPolicy field changes this behavior.
 //
 type Router struct {
+	// PathPolicy specifies how the router handles a request path that is not
+	// clean or that is missing the trailing slash required by a pattern.
+	PathPolicy PathPolicy
+
+	// RedirectStatus is the status used for redirects when PathPolicy is
+	// PathRedirect. If RedirectStatus is zero, then StatusMovedPermanently is
+	// used. Use StatusPermanentRedirect to preserve the request method and
+	// body.
+	RedirectStatus int
+
+	// If CaseInsensitive is true, then patterns match the request path
+	// without regard to case. Parameter values are taken from the request
+	// path as is.
+	CaseInsensitive bool
+
 	routes []*route
 }
 
+// PathPolicy specifies how a Router handles request paths that are not clean
+// or that are missing the trailing slash required by a pattern.
+type PathPolicy int
+
+const (
+	// PathRedirect redirects to the clean path or the path with the trailing
+	// slash.
+	PathRedirect PathPolicy = iota
+
+	// PathRewrite replaces the request URL path with the clean path or the
+	// path with the trailing slash and dispatches the request without
+	// redirecting.
+	PathRewrite
+
+	// PathStrict does not match request paths that are not clean or that are
+	// missing the trailing slash.
+	PathStrict
+)
+
 type route struct {
-	addSlash bool
-	regexp   *regexp.Regexp
-	names    []string
-	handlers map[string]Handler
+	pattern    string
+	name       string
+	addSlash   bool
+	regexp     *regexp.Regexp
+	foldRegexp *regexp.Regexp
+	names      []string
+	handlers   map[string]Handler
+	docs       map[string]*RouteDoc
 }
 
 var parameterRegexp = regexp.MustCompile("<([A-Za-z0-9_]*)(:[^>]*)?>")
@@ -118,9 +163,10 @@ func (router *Router) Register(pattern string, handlers ...interface{}) *Router
 		panic("twister: Invalid handlers for pattern " + pattern +
 			". Structure of handlers is [method handler]+.")
 	}
-	r := route{}
+	r := route{pattern: pattern}
 	r.addSlash = pattern[len(pattern)-1] == '/'
 	r.regexp, r.names = compilePattern(pattern, r.addSlash, "/")
+	r.foldRegexp = regexp.MustCompile("(?i)" + r.regexp.String())
 	r.handlers = make(map[string]Handler)
 	for i := 0; i < len(handlers); i += 2 {
 		method, ok := handlers[i].(string)
@@ -140,47 +186,280 @@ func (router *Router) Register(pattern string, handlers ...interface{}) *Router
 	return router
 }
 
+// Name sets the name of the most recently registered route. The name is
+// reported by the Routes method.
+func (router *Router) Name(name string) *Router {
+	if len(router.routes) == 0 {
+		panic("twister: Name called before Register")
+	}
+	router.routes[len(router.routes)-1].name = name
+	return router
+}
+
+// RouteDoc documents the handler for a method on a route.
+type RouteDoc struct {
+	// Short summary of what the handler does.
+	Summary string
+
+	// Longer description of the handler.
+	Description string
+
+	// Tags for grouping handlers in generated documentation.
+	Tags []string
+
+	// Request is a value whose type describes the request body or nil if
+	// the handler does not accept a body.
+	Request interface{}
+
+	// Response is a value whose type describes the response body or nil if
+	// the response body is not described.
+	Response interface{}
+
+	// Params maps parameter names to descriptions.
+	Params map[string]string
+}
+
+// Doc attaches documentation for the given method to the most recently
+// registered route.
+func (router *Router) Doc(method string, doc *RouteDoc) *Router {
+	if len(router.routes) == 0 {
+		panic("twister: Doc called before Register")
+	}
+	r := router.routes[len(router.routes)-1]
+	if r.handlers[method] == nil {
+		panic("twister: Doc for unregistered method " + method + " on pattern " + r.pattern)
+	}
+	if r.docs == nil {
+		r.docs = make(map[string]*RouteDoc)
+	}
+	r.docs[method] = doc
+	return router
+}
+
+// RouteInfo describes a registered route.
+type RouteInfo struct {
+	// Name of the route or "" if the route is not named.
+	Name string
+
+	// Host pattern for routes registered with a SiteRouter.
+	Host string
+
+	// Pattern passed to Register.
+	Pattern string
+
+	// Regexp compiled from the pattern.
+	Regexp *regexp.Regexp
+
+	// Names of the parameters in the pattern.
+	Params []string
+
+	// Handlers sorted by method.
+	Handlers []MethodInfo
+}
+
+// MethodInfo describes the handler registered for a method.
+type MethodInfo struct {
+	// Method or "*" for all methods.
+	Method string
+
+	// HandlerType is the name of the handler's type. If the handler is a
+	// HandlerFunc, then HandlerType is the name of the function.
+	HandlerType string
+
+	// Documentation attached with Router.Doc or nil.
+	Doc *RouteDoc
+}
+
+// RouteParam describes a parameter in a route pattern.
+type RouteParam struct {
+	// Name of the parameter. Unnamed parameters are given the names "_1",
+	// "_2" and so on.
+	Name string
+
+	// Regular expression for the parameter value.
+	Regexp string
+}
+
+// PathTemplate returns the route pattern with parameters replaced by
+// '{' name '}' and a description of the parameters in the order they appear
+// in the pattern.
+func (ri *RouteInfo) PathTemplate() (string, []RouteParam) {
+	var buf bytes.Buffer
+	var params []RouteParam
+	pattern := ri.Pattern
+	for {
+		a := parameterRegexp.FindStringSubmatchIndex(pattern)
+		if len(a) == 0 {
+			buf.WriteString(pattern)
+			break
+		}
+		buf.WriteString(pattern[0:a[0]])
+		p := RouteParam{Name: pattern[a[2]:a[3]], Regexp: "[^/]+"}
+		if p.Name == "" {
+			p.Name = "_" + strconv.Itoa(len(params)+1)
+		}
+		if a[4] >= 0 {
+			p.Regexp = pattern[a[4]+1 : a[5]]
+		}
+		params = append(params, p)
+		buf.WriteString("{" + p.Name + "}")
+		pattern = pattern[a[1]:]
+	}
+	return buf.String(), params
+}
+
+// RouteLister is implemented by routers that can enumerate their routes.
+type RouteLister interface {
+	Routes() []RouteInfo
+}
+
+// handlerTypeName returns a description of the handler's type.
+func handlerTypeName(h Handler) string {
+	if f, ok := h.(HandlerFunc); ok {
+		if fn := runtime.FuncForPC(reflect.ValueOf(f).Pointer()); fn != nil {
+			return fn.Name()
+		}
+	}
+	return fmt.Sprintf("%T", h)
+}
+
+// Routes returns the routes in the order that the routes were registered.
+func (router *Router) Routes() []RouteInfo {
+	result := make([]RouteInfo, len(router.routes))
+	for i, r := range router.routes {
+		ri := &result[i]
+		ri.Name = r.name
+		ri.Pattern = r.pattern
+		ri.Regexp = r.regexp
+		ri.Params = append([]string(nil), r.names...)
+		for method, handler := range r.handlers {
+			ri.Handlers = append(ri.Handlers, MethodInfo{Method: method, HandlerType: handlerTypeName(handler), Doc: r.docs[method]})
+		}
+		sort.Sort(byMethod(ri.Handlers))
+	}
+	return result
+}
+
+type byMethod []MethodInfo
+
+func (p byMethod) Len() int           { return len(p) }
+func (p byMethod) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+func (p byMethod) Less(i, j int) bool { return p[i].Method < p[j].Method }
+
 type routerError int
 
 func (status routerError) ServeWeb(req *Request) {
 	req.Error(int(status), nil)
 }
 
-// addSlash redirects to the request URL with a trailing slash.
-func addSlash(req *Request) {
-	path := req.URL.Path + "/"
+// redirect redirects to path with the request query using the router's
+// redirect status.
+func (router *Router) redirect(req *Request, path string) {
 	if len(req.URL.RawQuery) > 0 {
 		path = path + "?" + req.URL.RawQuery
 	}
-	req.Redirect(path, true)
+	if router.RedirectStatus == 0 {
+		req.Redirect(path, true)
+		return
+	}
+	req.Respond(router.RedirectStatus, HeaderLocation, path)
+}
+
+// addSlash redirects to the request URL with a trailing slash.
+func (router *Router) addSlash(req *Request) {
+	router.redirect(req, req.URL.Path+"/")
+}
+
+// rewriteSlash returns a handler that adds a trailing slash to the request URL
+// path and calls h.
+func rewriteSlash(h Handler) Handler {
+	return HandlerFunc(func(req *Request) {
+		req.URL.Path += "/"
+		h.ServeWeb(req)
+	})
 }
 
 // find the handler and path parameters given the path component of the request
 // URL and the request method.
 func (router *Router) find(path string, method string) (Handler, []string, []string) {
 	for _, r := range router.routes {
-		values := r.regexp.FindStringSubmatch(path)
+		re := r.regexp
+		if router.CaseInsensitive {
+			re = r.foldRegexp
+		}
+		values := re.FindStringSubmatch(path)
 		if len(values) == 0 {
 			continue
 		}
 		if r.addSlash && path[len(path)-1] != '/' {
-			return HandlerFunc(addSlash), nil, nil
+			switch router.PathPolicy {
+			case PathStrict:
+				continue
+			case PathRewrite:
+				handler, names, values := r.findMethod(method, values[1:])
+				if _, ok := handler.(routerError); !ok {
+					handler = rewriteSlash(handler)
+				}
+				return handler, names, values
+			}
+			return HandlerFunc(router.addSlash), nil, nil
 		}
-		values = values[1:]
-		if handler := r.handlers[method]; handler != nil {
+		return r.findMethod(method, values[1:])
+	}
+	return routerError(StatusNotFound), nil, nil
+}
+
+// findMethod finds the handler for the request method.
+func (r *route) findMethod(method string, values []string) (Handler, []string, []string) {
+	if handler := r.handlers[method]; handler != nil {
+		return handler, r.names, values
+	}
+	if method == "HEAD" {
+		if handler := r.handlers["GET"]; handler != nil {
 			return handler, r.names, values
 		}
-		if method == "HEAD" {
-			if handler := r.handlers["GET"]; handler != nil {
-				return handler, r.names, values
-			}
+	}
+	if handler := r.handlers["*"]; handler != nil {
+		return handler, r.names, values
+	}
+	return routerError(StatusMethodNotAllowed), nil, nil
+}
+
+// MethodLister is implemented by handlers that can report the methods
+// supported for a request path.
+type MethodLister interface {
+	// Methods returns the methods supported for path or nil if the path is
+	// not found. The method "*" indicates that all methods are supported.
+	Methods(path string) []string
+}
+
+// Methods returns the sorted list of methods registered for the route matching
+// path. HEAD is included if GET is registered. Methods returns nil if no route
+// matches the path.
+func (router *Router) Methods(path string) []string {
+	path = cleanUrlPath(path)
+	for _, r := range router.routes {
+		re := r.regexp
+		if router.CaseInsensitive {
+			re = r.foldRegexp
 		}
-		if handler := r.handlers["*"]; handler != nil {
-			return handler, r.names, values
+		if !re.MatchString(path) {
+			continue
+		}
+		if r.addSlash && path[len(path)-1] != '/' && router.PathPolicy == PathStrict {
+			continue
+		}
+		methods := make([]string, 0, len(r.handlers)+1)
+		for method := range r.handlers {
+			methods = append(methods, method)
 		}
-		return routerError(StatusMethodNotAllowed), nil, nil
+		if r.handlers["GET"] != nil && r.handlers["HEAD"] == nil {
+			methods = append(methods, "HEAD")
+		}
+		sort.Strings(methods)
+		return methods
 	}
-	return routerError(StatusNotFound), nil, nil
+	return nil
 }
 
 func cleanUrlPath(p string) string {
@@ -199,8 +478,16 @@ func cleanUrlPath(p string) string {
 func (router *Router) ServeWeb(req *Request) {
 	p := cleanUrlPath(req.URL.Path)
 	if p != req.URL.Path {
-		req.Redirect(p, true)
-		return
+		switch router.PathPolicy {
+		case PathRewrite:
+			req.URL.Path = p
+		case PathStrict:
+			req.Error(StatusNotFound, nil)
+			return
+		default:
+			router.redirect(req, p)
+			return
+		}
 	}
 	handler, names, values := router.find(p, req.Method)
 	if req.URLParam == nil {
@@ -225,13 +512,18 @@ func NewRouter() *Router {
 // the patterns in the order that the routes were registered. If a matching
 // route is found, the request is dispatched to the route's handler.
 //
-// A pattern is a string with embedded parameters. A parameter has the syntax:
+// A pattern is a string with embedded parameters and an optional port. A
+// parameter has the syntax:
 //
 //  '<' name (':' regexp)? '>'
 //
 // If the regular expression is not specified, then the regular expression
 // [^.]+ is used.
 //
+// If the pattern ends with ':' port, then the pattern only matches requests
+// for that port. Otherwise, the pattern matches requests for any port. The
+// host is compared without regard to case.
+//
 // Any matching parameters are in route pattern are stored in the in the
 // request URLParam field.
 type HostRouter struct {
@@ -240,9 +532,66 @@ type HostRouter struct {
 }
 
 type hostRoute struct {
+	hostPattern
+	name    string
+	handler Handler
+}
+
+// hostPattern matches the host and port of a request URL.
+type hostPattern struct {
+	pattern string
 	regexp  *regexp.Regexp
 	names   []string
-	handler Handler
+	port    string
+}
+
+func compileHostPattern(pattern string) hostPattern {
+	hp := hostPattern{pattern: pattern}
+	host := pattern
+	if i := strings.LastIndex(pattern, ":"); i >= 0 && i > strings.LastIndex(pattern, ">") && i > strings.LastIndex(pattern, "]") {
+		host, hp.port = pattern[:i], pattern[i+1:]
+	}
+	hp.regexp, hp.names = compilePattern(lowerLiterals(host), false, ".")
+	return hp
+}
+
+// lowerLiterals returns the host pattern with the text outside of parameters
+// converted to lower case. Request hosts are converted to lower case before
+// matching.
+func lowerLiterals(pattern string) string {
+	var buf bytes.Buffer
+	for {
+		a := parameterRegexp.FindStringIndex(pattern)
+		if a == nil {
+			buf.WriteString(strings.ToLower(pattern))
+			return buf.String()
+		}
+		buf.WriteString(strings.ToLower(pattern[:a[0]]))
+		buf.WriteString(pattern[a[0]:a[1]])
+		pattern = pattern[a[1]:]
+	}
+}
+
+// match returns the parameter values if the pattern matches host and port.
+func (hp *hostPattern) match(host, port string) ([]string, bool) {
+	if hp.port != "" && hp.port != port {
+		return nil, false
+	}
+	values := hp.regexp.FindStringSubmatch(host)
+	if len(values) == 0 {
+		return nil, false
+	}
+	return values[1:], true
+}
+
+// splitHostPort splits a request URL host into a lowercase host name and port.
+// The port is "" if not specified.
+func splitHostPort(s string) (host, port string) {
+	host = s
+	if i := strings.LastIndex(s, ":"); i >= 0 && i > strings.LastIndex(s, "]") {
+		host, port = s[:i], s[i+1:]
+	}
+	return strings.ToLower(host), port
 }
 
 // NewHostRouter allocates and initializes a new HostRouter.
@@ -255,27 +604,48 @@ func NewHostRouter(defaultHandler Handler) *HostRouter {
 
 // Register a handler for the given pattern.
 func (router *HostRouter) Register(hostPattern string, handler Handler) *HostRouter {
-	regex, names := compilePattern(hostPattern, false, ".")
-	router.routes = append(router.routes, hostRoute{regexp: regex, names: names, handler: handler})
+	router.routes = append(router.routes, hostRoute{hostPattern: compileHostPattern(hostPattern), handler: handler})
 	return router
 }
 
-func (router *HostRouter) find(host string) (Handler, []string, []string) {
-	for _, r := range router.routes {
-		values := r.regexp.FindStringSubmatch(host)
-		if len(values) == 0 {
-			continue
+// Name sets the name of the most recently registered route.
+func (router *HostRouter) Name(name string) *HostRouter {
+	if len(router.routes) == 0 {
+		panic("twister: Name called before Register")
+	}
+	router.routes[len(router.routes)-1].name = name
+	return router
+}
+
+// Routes returns the routes in the order that the routes were registered. The
+// handler for each route is reported with the method "*".
+func (router *HostRouter) Routes() []RouteInfo {
+	result := make([]RouteInfo, len(router.routes))
+	for i, r := range router.routes {
+		result[i] = RouteInfo{
+			Name:     r.name,
+			Pattern:  r.pattern,
+			Regexp:   r.regexp,
+			Params:   append([]string(nil), r.names...),
+			Handlers: []MethodInfo{{Method: "*", HandlerType: handlerTypeName(r.handler)}},
+		}
+	}
+	return result
+}
+
+func (router *HostRouter) find(host, port string) (Handler, []string, []string) {
+	for i := range router.routes {
+		r := &router.routes[i]
+		if values, ok := r.match(host, port); ok {
+			return r.handler, r.names, values
 		}
-		values = values[1:]
-		return r.handler, r.names, values
 	}
 	return router.defaultHandler, nil, nil
 }
 
 // ServeWeb dispatches the request to a registered handler.
 func (router *HostRouter) ServeWeb(req *Request) {
-	host := strings.ToLower(req.URL.Host)
-	handler, names, values := router.find(host)
+	handler, names, values := router.find(splitHostPort(req.URL.Host))
 	if req.URLParam == nil {
 		req.URLParam = make(map[string]string, len(values))
 	}
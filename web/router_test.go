@@ -15,7 +15,11 @@
 package web
 
 import (
+	"encoding/json"
+	"errors"
+	"reflect"
 	"sort"
+	"strings"
 	"testing"
 )
 
@@ -92,6 +96,27 @@ func TestRouter(t *testing.T) {
 	}
 }
 
+func TestRouterMethods(t *testing.T) {
+	r := NewRouter()
+	r.Register("/a", "GET", routeTestHandler("a-get"), "*", routeTestHandler("a-*"))
+	r.Register("/b/<x>", "POST", routeTestHandler("b-post"), "GET", routeTestHandler("b-get"))
+	r.Register("/c", "PUT", routeTestHandler("c-put"))
+	tests := []struct {
+		path   string
+		expect []string
+	}{
+		{"/a", []string{"*", "GET", "HEAD"}},
+		{"/b/1", []string{"GET", "HEAD", "POST"}},
+		{"/c", []string{"PUT"}},
+		{"/d", nil},
+	}
+	for _, tt := range tests {
+		if methods := r.Methods(tt.path); !reflect.DeepEqual(methods, tt.expect) {
+			t.Errorf("Methods(%q) = %v, want %v", tt.path, methods, tt.expect)
+		}
+	}
+}
+
 var hostRouteTests = []struct {
 	url    string
 	status int
@@ -100,12 +125,19 @@ var hostRouteTests = []struct {
 	{url: "http://www.example.com/", status: StatusOK, body: "www.example.com"},
 	{url: "http://foo.example.com/", status: StatusOK, body: "*.example.com x:foo"},
 	{url: "http://example.com/", status: StatusOK, body: "default"},
+	{url: "http://WWW.Example.com:8080/", status: StatusOK, body: "www.example.com"},
+	{url: "http://foo.example.com:8080/", status: StatusOK, body: "*.example.com x:foo"},
+	{url: "http://example.com:9000/", status: StatusOK, body: "example.com:9000"},
+	{url: "http://api.foo.example.net/", status: StatusOK, body: "api.*.example.net x:foo"},
+	{url: "http://API.Foo.EXAMPLE.NET/", status: StatusOK, body: "api.*.example.net x:foo"},
 }
 
 func TestHostRouter(t *testing.T) {
 	r := NewHostRouter(routeTestHandler("default"))
 	r.Register("www.example.com", routeTestHandler("www.example.com"))
 	r.Register("<x>.example.com", routeTestHandler("*.example.com"))
+	r.Register("example.com:9000", routeTestHandler("example.com:9000"))
+	r.Register("API.<x>.Example.NET", routeTestHandler("api.*.example.net"))
 
 	for _, rt := range hostRouteTests {
 		status, _, body := RunHandler(rt.url, "GET", nil, nil, r)
@@ -119,3 +151,180 @@ func TestHostRouter(t *testing.T) {
 		}
 	}
 }
+
+func testRouteFunc(req *Request) {}
+
+func TestRouterRoutes(t *testing.T) {
+	r := NewRouter()
+	r.Register("/", "GET", routeTestHandler("home")).Name("home")
+	r.Register("/a/<x>/<y:[0-9]+>", "POST", testRouteFunc, "GET", routeTestHandler("a"))
+
+	routes := r.Routes()
+	if len(routes) != 2 {
+		t.Fatalf("len(routes)=%d, want 2", len(routes))
+	}
+	if routes[0].Name != "home" || routes[0].Pattern != "/" || len(routes[0].Params) != 0 {
+		t.Errorf("routes[0]=%+v", routes[0])
+	}
+	if routes[1].Name != "" || routes[1].Pattern != "/a/<x>/<y:[0-9]+>" {
+		t.Errorf("routes[1]=%+v", routes[1])
+	}
+	if !reflect.DeepEqual(routes[1].Params, []string{"x", "y"}) {
+		t.Errorf("routes[1].Params=%q, want [x y]", routes[1].Params)
+	}
+	if s := routes[1].Regexp.String(); s != "^/a/([^/]+)/([0-9]+)$" {
+		t.Errorf("routes[1].Regexp=%q", s)
+	}
+	handlers := routes[1].Handlers
+	if len(handlers) != 2 ||
+		handlers[0].Method != "GET" || handlers[0].HandlerType != "web.routeTestHandler" ||
+		handlers[1].Method != "POST" || !strings.HasSuffix(handlers[1].HandlerType, ".testRouteFunc") {
+		t.Errorf("routes[1].Handlers=%+v", handlers)
+	}
+}
+
+func TestRouteTableHandler(t *testing.T) {
+	r := NewRouter()
+	r.Register("/", "GET", routeTestHandler("home")).Name("home")
+	r.Register("/debug/routes", "GET", RouteTableHandler(r))
+
+	status, header, body := RunHandler("/debug/routes?format=json", "GET", nil, nil, r)
+	if status != StatusOK {
+		t.Fatalf("status=%d, want %d", status, StatusOK)
+	}
+	if ct := header.Get(HeaderContentType); !strings.HasPrefix(ct, "application/json") {
+		t.Errorf("content-type=%q", ct)
+	}
+	var v []jsonRoute
+	if err := json.Unmarshal(body, &v); err != nil {
+		t.Fatal(err)
+	}
+	if len(v) != 2 || v[0].Name != "home" || v[0].Handlers["GET"] != "web.routeTestHandler" {
+		t.Errorf("json=%s", body)
+	}
+
+	status, header, body = RunHandler("/debug/routes", "GET", nil, nil, r)
+	if status != StatusOK {
+		t.Fatalf("status=%d, want %d", status, StatusOK)
+	}
+	if ct := header.Get(HeaderContentType); ct != ContentTypeHTML {
+		t.Errorf("content-type=%q", ct)
+	}
+	if !strings.Contains(string(body), "<td>web.routeTestHandler</td>") {
+		t.Errorf("html=%s", body)
+	}
+}
+
+var routerPathPolicyTests = []struct {
+	policy          PathPolicy
+	redirectStatus  int
+	caseInsensitive bool
+	url             string
+	method          string
+	status          int
+	location        string
+	body            string
+}{
+	{policy: PathRedirect, url: "/d?x=1", method: "POST", status: StatusMovedPermanently, location: "/d/?x=1"},
+	{policy: PathRedirect, redirectStatus: StatusPermanentRedirect, url: "/d", method: "POST", status: StatusPermanentRedirect, location: "/d/"},
+	{policy: PathRedirect, redirectStatus: StatusPermanentRedirect, url: "/a/../e/x", method: "GET", status: StatusPermanentRedirect, location: "/e/x"},
+	{policy: PathRewrite, url: "/d", method: "POST", status: StatusOK, body: "d /d/"},
+	{policy: PathRewrite, url: "/d", method: "PUT", status: StatusMethodNotAllowed},
+	{policy: PathRewrite, url: "/a/../e/x", method: "GET", status: StatusOK, body: "e /e/x"},
+	{policy: PathStrict, url: "/d", method: "POST", status: StatusNotFound},
+	{policy: PathStrict, url: "/d/", method: "POST", status: StatusOK, body: "d /d/"},
+	{policy: PathStrict, url: "/a/../e/x", method: "GET", status: StatusNotFound},
+	{url: "/E/x", method: "GET", status: StatusNotFound},
+	{caseInsensitive: true, url: "/E/Xy", method: "GET", status: StatusOK, body: "e /E/Xy"},
+}
+
+func TestRouterPathPolicy(t *testing.T) {
+	h := func(name string) HandlerFunc {
+		return func(req *Request) {
+			req.Respond(StatusOK).Write([]byte(name + " " + req.URL.Path))
+		}
+	}
+	for _, tt := range routerPathPolicyTests {
+		r := NewRouter()
+		r.PathPolicy = tt.policy
+		r.RedirectStatus = tt.redirectStatus
+		r.CaseInsensitive = tt.caseInsensitive
+		r.Register("/d/", "POST", h("d"))
+		r.Register("/e/<x>", "GET", h("e"))
+
+		status, header, body := RunHandler(tt.url, tt.method, nil, nil, r)
+		if status != tt.status {
+			t.Errorf("policy=%d url=%s method=%s, status=%d, want %d", tt.policy, tt.url, tt.method, status, tt.status)
+			continue
+		}
+		if location := header.Get(HeaderLocation); location != tt.location {
+			t.Errorf("policy=%d url=%s method=%s, location=%q, want %q", tt.policy, tt.url, tt.method, location, tt.location)
+		}
+		if status == StatusOK && string(body) != tt.body {
+			t.Errorf("policy=%d url=%s method=%s, body=%q, want %q", tt.policy, tt.url, tt.method, body, tt.body)
+		}
+	}
+}
+
+var siteRouteTests = []struct {
+	url    string
+	status int
+	body   string
+}{
+	{url: "http://www.example.com/", status: StatusOK, body: "www"},
+	// Paths not in the first matching site fall through to later sites.
+	{url: "http://www.example.com/items/1", status: StatusOK, body: "item id:1 tenant:www www"},
+	{url: "http://www.example.com/missing", status: StatusNotFound},
+	{url: "http://acme.example.com/", status: StatusOK, body: "tenant-home tenant:acme acme"},
+	{url: "http://acme.example.com:8080/items/1", status: StatusOK, body: "item id:1 tenant:acme acme"},
+	{url: "http://bogus.example.com/", status: StatusNotFound},
+	{url: "http://admin.example.com:9000/", status: StatusOK, body: "admin"},
+	{url: "http://admin.example.com/", status: StatusOK, body: "tenant-home tenant:admin admin"},
+	{url: "http://example.org/", status: StatusOK, body: "default"},
+	{url: "http://api.example.com/", status: StatusOK, body: "api"},
+	{url: "http://API.EXAMPLE.COM/", status: StatusOK, body: "api"},
+}
+
+func TestSiteRouter(t *testing.T) {
+	h := func(name string) HandlerFunc {
+		return func(req *Request) {
+			routeTestHandler(name).ServeWeb(req)
+			if tenant, ok := RequestTenant(req).(string); ok {
+				req.Responder.(testResponder).t.out.WriteString(" " + tenant)
+			}
+		}
+	}
+	r := NewSiteRouter(routeTestHandler("default"))
+	r.Tenant = func(req *Request) (interface{}, error) {
+		tenant := req.URLParam["tenant"]
+		if tenant == "" {
+			return nil, nil
+		}
+		if tenant == "bogus" {
+			return nil, errors.New("unknown tenant")
+		}
+		return tenant, nil
+	}
+	r.Register("www.example.com", "/", "GET", h("www"))
+	r.Register("admin.example.com:9000", "/", "GET", h("admin"))
+	r.Register("API.Example.com", "/", "GET", h("api"))
+	r.Register("<tenant>.example.com", "/", "GET", h("tenant-home"))
+	r.Register("<tenant>.example.com", "/items/<id>", "GET", h("item"))
+
+	for _, rt := range siteRouteTests {
+		status, _, body := RunHandler(rt.url, "GET", nil, nil, r)
+		if status != rt.status {
+			t.Errorf("url=%s, status=%d, want %d", rt.url, status, rt.status)
+		}
+		if status == StatusOK {
+			if string(body) != rt.body {
+				t.Errorf("url=%s, body=%q, want %q", rt.url, string(body), rt.body)
+			}
+		}
+	}
+
+	routes := r.Routes()
+	if len(routes) != 5 || routes[4].Host != "<tenant>.example.com" || routes[4].Pattern != "/items/<id>" {
+		t.Errorf("routes=%+v", routes)
+	}
+}
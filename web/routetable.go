@@ -0,0 +1,115 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package web
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+	"strings"
+)
+
+// RouteTableHandler returns a handler that responds with the routes reported
+// by the router. The routes are rendered as JSON if the "format" request
+// parameter is "json" or if the client prefers application/json. Otherwise,
+// the routes are rendered as an HTML table.
+//
+// The application should wrap the handler with appropriate access control. An
+// example of registering the handler with a web.Router is:
+//
+//  r.Register("/debug/routes", "GET", web.RouteTableHandler(r))
+func RouteTableHandler(router RouteLister) Handler {
+	return routeTableHandler{router}
+}
+
+type routeTableHandler struct {
+	router RouteLister
+}
+
+type jsonRoute struct {
+	Name     string            `json:"name,omitempty"`
+	Host     string            `json:"host,omitempty"`
+	Pattern  string            `json:"pattern"`
+	Regexp   string            `json:"regexp"`
+	Params   []string          `json:"params"`
+	Handlers map[string]string `json:"handlers"`
+}
+
+func wantsJSON(req *Request) bool {
+	switch req.Param.Get("format") {
+	case "json":
+		return true
+	case "html":
+		return false
+	}
+	return Negotiate(req, ContentTypeHTML, "application/json") == "application/json"
+}
+
+func (h routeTableHandler) ServeWeb(req *Request) {
+	routes := h.router.Routes()
+
+	if wantsJSON(req) {
+		v := make([]jsonRoute, len(routes))
+		for i, r := range routes {
+			v[i] = jsonRoute{
+				Name:     r.Name,
+				Host:     r.Host,
+				Pattern:  r.Pattern,
+				Regexp:   r.Regexp.String(),
+				Params:   r.Params,
+				Handlers: make(map[string]string),
+			}
+			if v[i].Params == nil {
+				v[i].Params = []string{}
+			}
+			for _, m := range r.Handlers {
+				v[i].Handlers[m.Method] = m.HandlerType
+			}
+		}
+		b, err := json.MarshalIndent(v, "", " ")
+		if err != nil {
+			req.Error(StatusInternalServerError, err)
+			return
+		}
+		req.Respond(StatusOK, HeaderContentType, "application/json; charset=utf-8").Write(b)
+		return
+	}
+
+	var b bytes.Buffer
+	b.WriteString("<!DOCTYPE html>\n<html><head><title>Routes</title></head><body>\n<table border=\"1\">\n")
+	b.WriteString("<tr><th>Name</th><th>Host</th><th>Pattern</th><th>Regexp</th><th>Params</th><th>Method</th><th>Handler</th></tr>\n")
+	for _, r := range routes {
+		for i, m := range r.Handlers {
+			b.WriteString("<tr>")
+			if i == 0 {
+				n := len(r.Handlers)
+				for _, s := range []string{r.Name, r.Host, r.Pattern, r.Regexp.String(), strings.Join(r.Params, ", ")} {
+					b.WriteString("<td rowspan=\"")
+					b.WriteString(strconv.Itoa(n))
+					b.WriteString("\">")
+					b.WriteString(HTMLEscapeString(s))
+					b.WriteString("</td>")
+				}
+			}
+			b.WriteString("<td>")
+			b.WriteString(HTMLEscapeString(m.Method))
+			b.WriteString("</td><td>")
+			b.WriteString(HTMLEscapeString(m.HandlerType))
+			b.WriteString("</td></tr>\n")
+		}
+	}
+	b.WriteString("</table>\n</body></html>\n")
+	req.Respond(StatusOK, HeaderContentType, ContentTypeHTML).Write(b.Bytes())
+}
@@ -0,0 +1,186 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package web
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SecureOptions specifies the options for SecureHandler. Headers with empty
+// or zero options are not sent.
+type SecureOptions struct {
+	// RedirectHTTPS redirects requests with the "http" scheme to the same URL
+	// with the "https" scheme. The port is removed from the host in the
+	// redirect URL.
+	RedirectHTTPS bool
+
+	// CanonicalHost redirects requests for other hosts to the canonical host.
+	CanonicalHost string
+
+	// RedirectStatus is the status used for redirects. If zero, then
+	// StatusMovedPermanently is used. Use StatusPermanentRedirect to preserve
+	// the request method and body.
+	RedirectStatus int
+
+	// Strict-Transport-Security header. The header is only sent with
+	// responses to HTTPS requests.
+	HSTSMaxAge            time.Duration
+	HSTSIncludeSubdomains bool
+	HSTSPreload           bool
+
+	// ContentSecurityPolicy is the Content-Security-Policy header value. The
+	// string "{nonce}" in the policy is replaced with a random nonce for each
+	// request. Use CSPNonce to get the nonce for the request.
+	ContentSecurityPolicy string
+
+	// If CSPReportOnly is true, then the policy is sent in the
+	// Content-Security-Policy-Report-Only header.
+	CSPReportOnly bool
+
+	// ContentTypeNosniff sets X-Content-Type-Options to "nosniff".
+	ContentTypeNosniff bool
+
+	// FrameOptions is the X-Frame-Options header value, "DENY" or
+	// "SAMEORIGIN".
+	FrameOptions string
+
+	// ReferrerPolicy is the Referrer-Policy header value.
+	ReferrerPolicy string
+
+	// PermissionsPolicy is the Permissions-Policy header value.
+	PermissionsPolicy string
+}
+
+// SecureHandler returns a handler that redirects requests to HTTPS and the
+// canonical host and adds security headers to responses from h. Headers set
+// by h are not replaced.
+//
+// The handler uses the request URL scheme and host. When the application is
+// behind a proxy, wrap the handler with ProxyHeaderHandler so that the scheme
+// is the scheme used by the client:
+//
+//  h = web.SecureHandler(&web.SecureOptions{
+//      RedirectHTTPS:         true,
+//      CanonicalHost:         "www.example.com",
+//      HSTSMaxAge:            365 * 24 * time.Hour,
+//      ContentSecurityPolicy: "default-src 'self'; script-src 'self' 'nonce-{nonce}'",
+//      ContentTypeNosniff:    true,
+//      FrameOptions:          "DENY",
+//      ReferrerPolicy:        "strict-origin-when-cross-origin",
+//  }, h)
+//  h = web.ProxyHeaderHandler("X-Real-Ip", "X-Scheme", h)
+//  server.Run(":8080", h)
+//
+// Templates add the nonce to script elements:
+//
+//  <script nonce="{{.Nonce}}">...</script>
+func SecureHandler(options *SecureOptions, h Handler) Handler {
+	return &secureHandler{options: options, h: h}
+}
+
+type secureHandler struct {
+	options *SecureOptions
+	h       Handler
+}
+
+const cspNonceEnvKey = "twister.web.cspNonce"
+
+// CSPNonce returns the Content-Security-Policy nonce for the request or "" if
+// the request was not dispatched through a SecureHandler with a policy that
+// uses a nonce.
+func CSPNonce(req *Request) string {
+	s, _ := req.Env[cspNonceEnvKey].(string)
+	return s
+}
+
+func (h *secureHandler) ServeWeb(req *Request) {
+	scheme := req.URL.Scheme
+	host := req.URL.Host
+	if h.options.RedirectHTTPS && scheme == "http" {
+		scheme = "https"
+		if i := strings.LastIndex(host, ":"); i >= 0 && !strings.HasSuffix(host, "]") {
+			host = host[:i]
+		}
+	}
+	if h.options.CanonicalHost != "" && !strings.EqualFold(host, h.options.CanonicalHost) {
+		host = h.options.CanonicalHost
+	}
+	if scheme != req.URL.Scheme || host != req.URL.Host {
+		status := h.options.RedirectStatus
+		if status == 0 {
+			status = StatusMovedPermanently
+		}
+		req.Respond(status, HeaderLocation, scheme+"://"+host+req.URL.RequestURI())
+		return
+	}
+
+	csp := h.options.ContentSecurityPolicy
+	if strings.Contains(csp, "{nonce}") {
+		p := make([]byte, 16)
+		if _, err := rand.Read(p); err != nil {
+			req.Error(StatusInternalServerError, err)
+			return
+		}
+		nonce := base64.StdEncoding.EncodeToString(p)
+		req.Env[cspNonceEnvKey] = nonce
+		csp = strings.Replace(csp, "{nonce}", nonce, -1)
+	}
+
+	var headers []string
+	if req.URL.Scheme == "https" && h.options.HSTSMaxAge > 0 {
+		v := "max-age=" + strconv.FormatInt(int64(h.options.HSTSMaxAge/time.Second), 10)
+		if h.options.HSTSIncludeSubdomains {
+			v += "; includeSubDomains"
+		}
+		if h.options.HSTSPreload {
+			v += "; preload"
+		}
+		headers = append(headers, HeaderStrictTransportSecurity, v)
+	}
+	if csp != "" {
+		if h.options.CSPReportOnly {
+			headers = append(headers, HeaderContentSecurityPolicyReportOnly, csp)
+		} else {
+			headers = append(headers, HeaderContentSecurityPolicy, csp)
+		}
+	}
+	if h.options.ContentTypeNosniff {
+		headers = append(headers, HeaderXContentTypeOptions, "nosniff")
+	}
+	if h.options.FrameOptions != "" {
+		headers = append(headers, HeaderXFrameOptions, h.options.FrameOptions)
+	}
+	if h.options.ReferrerPolicy != "" {
+		headers = append(headers, HeaderReferrerPolicy, h.options.ReferrerPolicy)
+	}
+	if h.options.PermissionsPolicy != "" {
+		headers = append(headers, HeaderPermissionsPolicy, h.options.PermissionsPolicy)
+	}
+	if len(headers) > 0 {
+		FilterRespond(req, func(status int, header Header) (int, Header) {
+			for i := 0; i < len(headers); i += 2 {
+				if _, found := header[headers[i]]; !found {
+					header.Set(headers[i], headers[i+1])
+				}
+			}
+			return status, header
+		})
+	}
+	h.h.ServeWeb(req)
+}
@@ -0,0 +1,80 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package web
+
+import (
+	"io"
+	"testing"
+	"time"
+)
+
+var secureRedirectTests = []struct {
+	url      string
+	header   Header
+	location string
+}{
+	{"http://example.com:8080/a?b=c", nil, "https://www.example.com/a?b=c"},
+	{"https://example.com/a", nil, "https://www.example.com/a"},
+	{"http://www.example.com/a", nil, "https://www.example.com/a"},
+	{"https://WWW.example.com/a", nil, ""},
+	{"http://www.example.com/a", NewHeader("X-Scheme", "https"), ""},
+}
+
+func TestSecureHandler(t *testing.T) {
+	h := SecureHandler(&SecureOptions{
+		RedirectHTTPS:         true,
+		CanonicalHost:         "www.example.com",
+		HSTSMaxAge:            time.Hour,
+		HSTSIncludeSubdomains: true,
+		ContentSecurityPolicy: "script-src 'nonce-{nonce}'",
+		ContentTypeNosniff:    true,
+		FrameOptions:          "DENY",
+		ReferrerPolicy:        "no-referrer",
+		PermissionsPolicy:     "camera=()",
+	}, HandlerFunc(func(req *Request) {
+		w := req.Respond(StatusOK, HeaderXFrameOptions, "SAMEORIGIN")
+		io.WriteString(w, CSPNonce(req))
+	}))
+	h = ProxyHeaderHandler("", "X-Scheme", h)
+
+	for _, tt := range secureRedirectTests {
+		status, header, _ := RunHandler(tt.url, "GET", tt.header, nil, h)
+		location := header.Get(HeaderLocation)
+		if location != tt.location || (location != "" && status != StatusMovedPermanently) {
+			t.Errorf("%s status=%d location=%q, want %q", tt.url, status, location, tt.location)
+		}
+	}
+
+	status, header, body := RunHandler("https://www.example.com/", "GET", nil, nil, h)
+	nonce := string(body)
+	if status != StatusOK || len(nonce) != 24 {
+		t.Fatalf("status=%d, nonce=%q", status, nonce)
+	}
+	expect := NewHeader(
+		HeaderStrictTransportSecurity, "max-age=3600; includeSubDomains",
+		HeaderContentSecurityPolicy, "script-src 'nonce-"+nonce+"'",
+		HeaderXContentTypeOptions, "nosniff",
+		HeaderXFrameOptions, "SAMEORIGIN",
+		HeaderReferrerPolicy, "no-referrer",
+		HeaderPermissionsPolicy, "camera=()")
+	for name := range expect {
+		if header.Get(name) != expect.Get(name) {
+			t.Errorf("%s=%q, want %q", name, header.Get(name), expect.Get(name))
+		}
+	}
+	if _, _, body := RunHandler("https://www.example.com/", "GET", nil, nil, h); string(body) == nonce {
+		t.Error("nonce reused")
+	}
+}
@@ -0,0 +1,165 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package web
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CookieKey is a key used by SecureCookie.
+type CookieKey struct {
+	// ID identifies the key in encoded values.
+	ID byte
+
+	// Secret is the AES key. The secret must be 16, 24 or 32 bytes long to
+	// select AES-128, AES-192 or AES-256.
+	Secret []byte
+}
+
+// SecureCookie encrypts and authenticates cookie values using AES-GCM.
+//
+// An encoded value contains the ID of the key used to encrypt the value and
+// the time that the value was encoded. Keys are rotated by adding a new key
+// at the front of the key list and removing old keys after values encoded
+// with the old keys have expired:
+//
+//  sc, err := web.NewSecureCookie(30*24*time.Hour,
+//      web.CookieKey{ID: 2, Secret: newSecret},  // encrypts new values
+//      web.CookieKey{ID: 1, Secret: oldSecret})  // decrypts old values
+//
+//  func uidCookieValue(uid string) (string, error) {
+//      s, err := sc.Encode("uid", uid)
+//      if err != nil {
+//          return "", err
+//      }
+//      return web.NewCookie("uid", s).MaxAge(sc.MaxAge).String(), nil
+//  }
+//
+//  func requestUid(req *web.Request) (string, error) {
+//      return sc.Decode("uid", req.Cookie.Get("uid"))
+//  }
+type SecureCookie struct {
+	// MaxAge is the maximum age of an encoded value. If MaxAge is zero, then
+	// values do not expire.
+	MaxAge time.Duration
+
+	// LegacySecret is the secret for verifying values created by SignValue.
+	// Set LegacySecret to migrate values from SignValue to SecureCookie. If
+	// LegacySecret is "", then SignValue values are rejected.
+	LegacySecret string
+
+	aeads map[byte]cipher.AEAD
+	id    byte
+}
+
+const (
+	secureCookieVersion    = 1
+	secureCookieHeaderSize = 1 + 1 + 8 // version, key id, timestamp
+)
+
+// NewSecureCookie returns a codec for the given keys. The first key is used
+// to encrypt values. All keys are used to decrypt values.
+func NewSecureCookie(maxAge time.Duration, keys ...CookieKey) (*SecureCookie, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("twister: NewSecureCookie requires a key")
+	}
+	sc := &SecureCookie{MaxAge: maxAge, aeads: make(map[byte]cipher.AEAD), id: keys[0].ID}
+	for _, key := range keys {
+		if _, found := sc.aeads[key.ID]; found {
+			return nil, errors.New("twister: duplicate cookie key ID " + strconv.Itoa(int(key.ID)))
+		}
+		block, err := aes.NewCipher(key.Secret)
+		if err != nil {
+			return nil, err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		sc.aeads[key.ID] = aead
+	}
+	return sc, nil
+}
+
+// Encode encrypts value. The context, typically the cookie name, is
+// authenticated with the value so that a value encoded for one context cannot
+// be used in another.
+func (sc *SecureCookie) Encode(context, value string) (string, error) {
+	return sc.encodeAt(context, value, time.Now())
+}
+
+func (sc *SecureCookie) encodeAt(context, value string, t time.Time) (string, error) {
+	aead := sc.aeads[sc.id]
+	p := make([]byte, secureCookieHeaderSize+aead.NonceSize(), secureCookieHeaderSize+aead.NonceSize()+len(value)+aead.Overhead())
+	p[0] = secureCookieVersion
+	p[1] = sc.id
+	binary.BigEndian.PutUint64(p[2:secureCookieHeaderSize], uint64(t.Unix()))
+	nonce := p[secureCookieHeaderSize:]
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	p = aead.Seal(p, nonce, []byte(value), additionalData(context, p[:secureCookieHeaderSize]))
+	return base64.RawURLEncoding.EncodeToString(p), nil
+}
+
+// Decode decrypts a value created by Encode. An error is returned if the
+// value has expired, the key is not known or the value was not created by
+// Encode with the same context. If LegacySecret is set, then values created
+// by SignValue are verified with VerifyValue.
+func (sc *SecureCookie) Decode(context, encoded string) (string, error) {
+	if strings.Contains(encoded, "~") {
+		if sc.LegacySecret == "" {
+			return "", errVerificationFailure
+		}
+		return VerifyValue(sc.LegacySecret, context, encoded)
+	}
+	p, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil || len(p) < secureCookieHeaderSize || p[0] != secureCookieVersion {
+		return "", errVerificationFailure
+	}
+	aead := sc.aeads[p[1]]
+	if aead == nil || len(p) < secureCookieHeaderSize+aead.NonceSize()+aead.Overhead() {
+		return "", errVerificationFailure
+	}
+	if sc.MaxAge > 0 {
+		created := time.Unix(int64(binary.BigEndian.Uint64(p[2:secureCookieHeaderSize])), 0)
+		if time.Since(created) > sc.MaxAge {
+			return "", errVerificationFailure
+		}
+	}
+	nonce := p[secureCookieHeaderSize : secureCookieHeaderSize+aead.NonceSize()]
+	ciphertext := p[secureCookieHeaderSize+aead.NonceSize():]
+	value, err := aead.Open(nil, nonce, ciphertext, additionalData(context, p[:secureCookieHeaderSize]))
+	if err != nil {
+		return "", errVerificationFailure
+	}
+	return string(value), nil
+}
+
+// additionalData returns the data authenticated with the encrypted value.
+func additionalData(context string, header []byte) []byte {
+	ad := make([]byte, 0, len(context)+1+len(header))
+	ad = append(ad, context...)
+	ad = append(ad, 0)
+	return append(ad, header...)
+}
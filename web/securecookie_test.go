@@ -0,0 +1,86 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package web
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+var (
+	testKey1 = CookieKey{ID: 1, Secret: []byte("0123456789abcdef")}
+	testKey2 = CookieKey{ID: 2, Secret: []byte("0123456789abcdef0123456789abcdef")}
+)
+
+func TestSecureCookie(t *testing.T) {
+	old, err := NewSecureCookie(time.Hour, testKey1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sc, err := NewSecureCookie(time.Hour, testKey2, testKey1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encoded, err := sc.Encode("uid", "gopher")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(encoded, "gopher") || strings.ContainsAny(encoded, "+/=~") {
+		t.Errorf("encoded=%q", encoded)
+	}
+	if v, err := sc.Decode("uid", encoded); err != nil || v != "gopher" {
+		t.Errorf("Decode() = %q, %v, want gopher", v, err)
+	}
+	if _, err := sc.Decode("other", encoded); err == nil {
+		t.Error("decoded value with wrong context")
+	}
+	if _, err := old.Decode("uid", encoded); err == nil {
+		t.Error("decoded value with unknown key")
+	}
+	b := []byte(encoded)
+	b[len(b)-2] ^= 1
+	if _, err := sc.Decode("uid", string(b)); err == nil {
+		t.Error("decoded modified value")
+	}
+
+	// Values encoded with an old key are decoded after rotation.
+	encoded, _ = old.Encode("uid", "gopher")
+	if v, err := sc.Decode("uid", encoded); err != nil || v != "gopher" {
+		t.Errorf("Decode(old key) = %q, %v, want gopher", v, err)
+	}
+
+	encoded, _ = sc.encodeAt("uid", "gopher", time.Now().Add(-2*time.Hour))
+	if _, err := sc.Decode("uid", encoded); err == nil {
+		t.Error("decoded expired value")
+	}
+
+	legacy := SignValue("secret", "uid", time.Hour, "gopher")
+	if _, err := sc.Decode("uid", legacy); err == nil {
+		t.Error("decoded legacy value without LegacySecret")
+	}
+	sc.LegacySecret = "secret"
+	if v, err := sc.Decode("uid", legacy); err != nil || v != "gopher" {
+		t.Errorf("Decode(legacy) = %q, %v, want gopher", v, err)
+	}
+
+	if _, err := NewSecureCookie(time.Hour, testKey1, testKey1); err == nil {
+		t.Error("NewSecureCookie accepted duplicate key ID")
+	}
+	if _, err := NewSecureCookie(time.Hour, CookieKey{ID: 3, Secret: []byte("short")}); err == nil {
+		t.Error("NewSecureCookie accepted short key")
+	}
+}
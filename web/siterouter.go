@@ -0,0 +1,155 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package web
+
+// SiteRouter is a request handler that dispatches HTTP requests to other
+// handlers using the host HTTP header, the request URL path and the request
+// method.
+//
+// A site router has a list of sites. A site is a host pattern and a Router.
+// Host patterns have the syntax described for HostRouter and path patterns
+// have the syntax described for Router:
+//
+//  r := web.NewSiteRouter(nil)
+//  r.Register("www.example.com", "/", "GET", serveHome)
+//  r.Register("<tenant>.example.com", "/", "GET", serveTenantHome)
+//  r.Register("<tenant>.example.com", "/items/<id>", "GET", serveItem)
+//
+// The router dispatches requests to the first site with a host pattern that
+// matches the request host and a route that matches the request path. The
+// site's Router dispatches the request using the path and method. A request
+// for www.example.com/items/1 is handled by the serveItem route above. If
+// the host matches sites but the path does not match a route in those sites,
+// then the first site with a matching host responds to the request. If no
+// site matches the host, then the request is dispatched to the default
+// handler.
+//
+// Parameters in the host and path patterns are stored in the request URLParam
+// field.
+type SiteRouter struct {
+	// Tenant, if not nil, is called after a site is selected and the host
+	// parameters are stored in the request URLParam field. The returned
+	// tenant is stored in the request Env. If Tenant returns an error, then
+	// the router responds to the request with HTTP status 404.
+	Tenant TenantFunc
+
+	defaultHandler Handler
+	sites          []*site
+}
+
+type site struct {
+	host   hostPattern
+	router *Router
+}
+
+// TenantFunc resolves the tenant for a request.
+type TenantFunc func(req *Request) (tenant interface{}, err error)
+
+const tenantEnvKey = "twister.web.tenant"
+
+// RequestTenant returns the tenant resolved by a SiteRouter or nil if the
+// tenant was not resolved.
+func RequestTenant(req *Request) interface{} {
+	return req.Env[tenantEnvKey]
+}
+
+// NewSiteRouter allocates and initializes a new SiteRouter.
+func NewSiteRouter(defaultHandler Handler) *SiteRouter {
+	if defaultHandler == nil {
+		defaultHandler = NotFoundHandler()
+	}
+	return &SiteRouter{defaultHandler: defaultHandler}
+}
+
+// Site returns the Router for the given host pattern. The Router is created
+// if it does not already exist. Use the returned router to set options, name
+// routes and document routes.
+func (router *SiteRouter) Site(hostPattern string) *Router {
+	for _, s := range router.sites {
+		if s.host.pattern == hostPattern {
+			return s.router
+		}
+	}
+	s := &site{host: compileHostPattern(hostPattern), router: NewRouter()}
+	router.sites = append(router.sites, s)
+	return s.router
+}
+
+// Register the route with the given host pattern, path pattern and handlers.
+// The structure of the handlers argument is the same as for Router.Register.
+func (router *SiteRouter) Register(hostPattern string, pathPattern string, handlers ...interface{}) *SiteRouter {
+	router.Site(hostPattern).Register(pathPattern, handlers...)
+	return router
+}
+
+// Routes returns the routes for each site in the order that the sites were
+// created.
+func (router *SiteRouter) Routes() []RouteInfo {
+	var result []RouteInfo
+	for _, s := range router.sites {
+		for _, ri := range s.router.Routes() {
+			ri.Host = s.host.pattern
+			result = append(result, ri)
+		}
+	}
+	return result
+}
+
+// ServeWeb dispatches the request to a registered handler.
+func (router *SiteRouter) ServeWeb(req *Request) {
+	host, port := splitHostPort(req.URL.Host)
+	var (
+		first       *site
+		firstValues []string
+	)
+	for _, s := range router.sites {
+		values, ok := s.host.match(host, port)
+		if !ok {
+			continue
+		}
+		if s.router.Methods(req.URL.Path) != nil {
+			router.serveSite(req, s, values)
+			return
+		}
+		if first == nil {
+			first, firstValues = s, values
+		}
+	}
+	if first != nil {
+		router.serveSite(req, first, firstValues)
+		return
+	}
+	router.defaultHandler.ServeWeb(req)
+}
+
+// serveSite dispatches the request to the site with the values of the host
+// parameters.
+func (router *SiteRouter) serveSite(req *Request, s *site, values []string) {
+	if req.URLParam == nil {
+		req.URLParam = make(map[string]string, len(values))
+	}
+	for i, name := range s.host.names {
+		req.URLParam[name] = values[i]
+	}
+	if router.Tenant != nil {
+		tenant, err := router.Tenant(req)
+		if err != nil {
+			req.Error(StatusNotFound, err)
+			return
+		}
+		req.Env[tenantEnvKey] = tenant
+	}
+	s.router.ServeWeb(req)
+}
@@ -0,0 +1,196 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package web
+
+import (
+	"net"
+	"strings"
+)
+
+// TrustedProxyHandler returns a handler that sets the Request.RemoteAddr
+// field, the Request.URL.Scheme field and the Request.URL.Host field from
+// headers set by trusted proxies.
+//
+// The headers are used only when the immediate peer is a trusted proxy. The
+// trusted argument is a list of IP addresses and CIDR ranges such as
+// "10.0.0.0/8". The function panics if an entry is not valid.
+//
+// The header argument specifies the headers written by the trusted proxies.
+// If header is HeaderForwarded, then the for, proto and host parameters of the
+// RFC 7239 Forwarded header are used. If header is HeaderXForwardedFor, then
+// the X-Forwarded-For, X-Forwarded-Proto and X-Forwarded-Host headers are
+// used. The other headers are ignored because a proxy that does not write a
+// header passes the header from the client through unmodified. The function
+// panics if header is not one of these values.
+//
+// The client address is found by walking the list of forwarded addresses from
+// right to left, skipping the addresses of trusted proxies. The first
+// untrusted address is the client address.
+//
+//  h = web.TrustedProxyHandler(web.HeaderXForwardedFor, []string{"10.0.0.0/8"}, h)
+//
+// The original values are added to the request Env with the keys
+// "twister.web.OriginalRemoteAddr", "twister.web.OriginalScheme" and
+// "twister.web.OriginalHost".
+func TrustedProxyHandler(header string, trusted []string, h Handler) Handler {
+	if header != HeaderForwarded && header != HeaderXForwardedFor {
+		panic("twister: TrustedProxyHandler header must be Forwarded or X-Forwarded-For")
+	}
+	th := &trustedProxyHandler{forwarded: header == HeaderForwarded, h: h}
+	for _, s := range trusted {
+		if !strings.Contains(s, "/") {
+			if strings.Contains(s, ":") {
+				s += "/128"
+			} else {
+				s += "/32"
+			}
+		}
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			panic("twister: bad trusted proxy " + s)
+		}
+		th.trusted = append(th.trusted, n)
+	}
+	return th
+}
+
+type trustedProxyHandler struct {
+	trusted   []*net.IPNet
+	forwarded bool
+	h         Handler
+}
+
+// forwardedElement is an element of the Forwarded header.
+type forwardedElement struct {
+	addr, proto, host string
+}
+
+func (h *trustedProxyHandler) isTrusted(addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, n := range h.trusted {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// stripPort returns the address without the port and brackets.
+func stripPort(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(addr, "["), "]")
+}
+
+// parseForwarded parses the RFC 7239 Forwarded header.
+func parseForwarded(values []string) []forwardedElement {
+	var elements []forwardedElement
+	for _, s := range values {
+		for s != "" {
+			var e forwardedElement
+			for {
+				var name, value string
+				name, s = splitToken(skipSpace(s))
+				s = skipSpace(s)
+				if !strings.HasPrefix(s, "=") {
+					break
+				}
+				value, s = splitTokenOrQuoted(skipSpace(s[1:]))
+				switch toLowerToken(name) {
+				case "for":
+					e.addr = stripPort(value)
+				case "proto":
+					e.proto = strings.ToLower(value)
+				case "host":
+					e.host = value
+				}
+				s = skipSpace(s)
+				if !strings.HasPrefix(s, ";") {
+					break
+				}
+				s = s[1:]
+			}
+			elements = append(elements, e)
+			// Skip to the next element.
+			if i := strings.Index(s, ","); i >= 0 {
+				s = s[i+1:]
+			} else {
+				s = ""
+			}
+		}
+	}
+	return elements
+}
+
+// xForwardedElements returns the elements from the X-Forwarded-* headers.
+func xForwardedElements(header Header) []forwardedElement {
+	addrs := header.GetList(HeaderXForwardedFor)
+	protos := header.GetList(HeaderXForwardedProto)
+	hosts := header.GetList(HeaderXForwardedHost)
+	elements := make([]forwardedElement, len(addrs))
+	for i, addr := range addrs {
+		elements[i].addr = stripPort(addr)
+		if len(protos) == len(addrs) {
+			elements[i].proto = strings.ToLower(protos[i])
+		} else if len(protos) > 0 {
+			elements[i].proto = strings.ToLower(protos[len(protos)-1])
+		}
+		if len(hosts) == len(addrs) {
+			elements[i].host = hosts[i]
+		} else if len(hosts) > 0 {
+			elements[i].host = hosts[len(hosts)-1]
+		}
+	}
+	return elements
+}
+
+func (h *trustedProxyHandler) ServeWeb(req *Request) {
+	if !h.isTrusted(stripPort(req.RemoteAddr)) {
+		h.h.ServeWeb(req)
+		return
+	}
+	var elements []forwardedElement
+	if h.forwarded {
+		elements = parseForwarded(req.Header[HeaderForwarded])
+	} else {
+		elements = xForwardedElements(req.Header)
+	}
+	if len(elements) == 0 {
+		h.h.ServeWeb(req)
+		return
+	}
+	i := len(elements) - 1
+	for i > 0 && h.isTrusted(elements[i].addr) {
+		i--
+	}
+	e := elements[i]
+	if net.ParseIP(e.addr) != nil {
+		req.Env["twister.web.OriginalRemoteAddr"] = req.RemoteAddr
+		req.RemoteAddr = e.addr
+	}
+	if e.proto == "http" || e.proto == "https" {
+		req.Env["twister.web.OriginalScheme"] = req.URL.Scheme
+		req.URL.Scheme = e.proto
+	}
+	if e.host != "" && !strings.ContainsAny(e.host, "/?#@ \t") {
+		req.Env["twister.web.OriginalHost"] = req.URL.Host
+		req.URL.Host = e.host
+	}
+	h.h.ServeWeb(req)
+}
@@ -0,0 +1,70 @@
+// Copyright 2011 Gary Burd
+//
+// Licensed under the Apache License, Version 2.0 (the "License"): you may
+// not use this file except in compliance with the License. You may obtain
+// a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS, WITHOUT
+// WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied. See the
+// License for the specific language governing permissions and limitations
+// under the License.
+
+package web
+
+import (
+	"fmt"
+	"testing"
+)
+
+var trustedProxyTests = []struct {
+	proxyHeader string
+	trusted     []string
+	header      Header
+	expect      string
+}{
+	// Peer not trusted.
+	{HeaderXForwardedFor, []string{"10.0.0.0/8"}, NewHeader(HeaderXForwardedFor, "5.6.7.8", HeaderXForwardedProto, "https"),
+		"1.2.3.4 http example.com"},
+	// X-Forwarded-*.
+	{HeaderXForwardedFor, []string{"1.2.3.0/24"}, NewHeader(HeaderXForwardedFor, "5.6.7.8", HeaderXForwardedProto, "https", HeaderXForwardedHost, "www.example.com"),
+		"5.6.7.8 https www.example.com"},
+	{HeaderXForwardedFor, []string{"1.2.3.4", "10.0.0.0/8"}, NewHeader(HeaderXForwardedFor, "9.9.9.9, 5.6.7.8, 10.1.1.1"),
+		"5.6.7.8 http example.com"},
+	{HeaderXForwardedFor, []string{"1.2.3.4", "10.0.0.0/8"}, NewHeader(HeaderXForwardedFor, "10.2.2.2, 10.1.1.1"),
+		"10.2.2.2 http example.com"},
+	{HeaderXForwardedFor, []string{"1.2.3.4"}, NewHeader(HeaderXForwardedFor, "[2001:db8::1]:4711"),
+		"2001:db8::1 http example.com"},
+	{HeaderXForwardedFor, []string{"1.2.3.4"}, NewHeader(HeaderXForwardedFor, "junk", HeaderXForwardedProto, "ftp", HeaderXForwardedHost, "a/b"),
+		"1.2.3.4 http example.com"},
+	// Forwarded.
+	{HeaderForwarded, []string{"1.2.3.4"}, NewHeader(HeaderForwarded, `for=192.0.2.60;proto=https;host="www.example.com:8443";by=203.0.113.43`),
+		"192.0.2.60 https www.example.com:8443"},
+	{HeaderForwarded, []string{"1.2.3.4", "10.0.0.0/8"}, NewHeader(HeaderForwarded, `for="[2001:db8:cafe::17]:4711";proto=https, For=10.1.1.1;proto=http`),
+		"2001:db8:cafe::17 https example.com"},
+	{HeaderForwarded, []string{"1.2.3.4"}, NewHeader(HeaderForwarded, `for=unknown;proto=https`, HeaderXForwardedFor, "5.6.7.8"),
+		"1.2.3.4 https example.com"},
+	// Forged header not written by the trusted proxy.
+	{HeaderXForwardedFor, []string{"1.2.3.4"}, NewHeader(HeaderForwarded, `for=9.9.9.9;proto=https;host=evil.example.org`, HeaderXForwardedFor, "5.6.7.8"),
+		"5.6.7.8 http example.com"},
+	{HeaderXForwardedFor, []string{"1.2.3.4"}, NewHeader(HeaderForwarded, `for=9.9.9.9;proto=https;host=evil.example.org`),
+		"1.2.3.4 http example.com"},
+	{HeaderForwarded, []string{"1.2.3.4"}, NewHeader(HeaderForwarded, `for=5.6.7.8`, HeaderXForwardedFor, "9.9.9.9", HeaderXForwardedHost, "evil.example.org"),
+		"5.6.7.8 http example.com"},
+	{HeaderForwarded, []string{"1.2.3.4"}, NewHeader(HeaderXForwardedFor, "9.9.9.9", HeaderXForwardedProto, "https"),
+		"1.2.3.4 http example.com"},
+}
+
+func TestTrustedProxyHandler(t *testing.T) {
+	for _, tt := range trustedProxyTests {
+		h := TrustedProxyHandler(tt.proxyHeader, tt.trusted, HandlerFunc(func(req *Request) {
+			fmt.Fprintf(req.Respond(StatusOK), "%s %s %s", req.RemoteAddr, req.URL.Scheme, req.URL.Host)
+		}))
+		_, _, body := RunHandler("http://example.com/", "GET", tt.header, nil, h)
+		if string(body) != tt.expect {
+			t.Errorf("proxyHeader=%s trusted=%v header=%v got %q, want %q", tt.proxyHeader, tt.trusted, tt.header, body, tt.expect)
+		}
+	}
+}